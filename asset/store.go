@@ -124,6 +124,10 @@ func (s *store) Get(shasum string) (*bytes.Buffer, map[string]string, error) {
 	return base.Storage.Get(AssetContainerName, shasum)
 }
 
+func (s *store) Open(shasum string) (*base.Object, error) {
+	return base.Storage.Open(AssetContainerName, shasum)
+}
+
 func (s *store) Remove(shasum, source string) error {
 	var doc *base.Asset
 	row := s.db.Get(s.ctx, shasum)
@@ -0,0 +1,205 @@
+// Package audit records the administrative actions made on the registry, from
+// the command line or through the API, in an append-only CouchDB database.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/go-kivik/kivik/v3"
+	"github.com/sirupsen/logrus"
+)
+
+const auditDBSuffix = "audit"
+
+// The actions recorded in the audit log.
+const (
+	AppModified            = "app.modified"
+	AppRemoved             = "app.removed"
+	AppTransferred         = "app.transferred"
+	MaintenanceActivated   = "app.maintenance_activated"
+	MaintenanceDeactivated = "app.maintenance_deactivated"
+	VersionApproved        = "version.approved"
+	VersionRejected        = "version.rejected"
+	VersionDeleted         = "version.deleted"
+	VersionYanked          = "version.yanked"
+	VersionUnyanked        = "version.unyanked"
+	VersionPromoted        = "version.promoted"
+	RolloutUpdated         = "version.rollout_updated"
+	SpaceRemoved           = "space.removed"
+	EditorModified         = "editor.modified"
+	EditorRemoved          = "editor.removed"
+	TokenRevoked           = "editor.token_revoked"
+	TokensRevoked          = "editor.tokens_revoked"
+)
+
+// designDoc makes the database append-only: the entries cannot be modified
+// or deleted once they have been recorded.
+const designDoc = `function(newDoc, oldDoc) {
+  if (oldDoc && newDoc._id.indexOf("_design/") !== 0) {
+    throw({forbidden: "the audit log is append-only"});
+  }
+}`
+
+// Entry is an action recorded in the audit log. Before and After are the
+// values that have been changed by the action, when it makes sense.
+type Entry struct {
+	ID        string      `json:"_id,omitempty"`
+	Rev       string      `json:"_rev,omitempty"`
+	Actor     string      `json:"actor"`
+	Action    string      `json:"action"`
+	Space     string      `json:"space,omitempty"`
+	Slug      string      `json:"slug,omitempty"`
+	Version   string      `json:"version,omitempty"`
+	Editor    string      `json:"editor,omitempty"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Filters are the criteria to select the entries of the audit log. The
+// empty fields are ignored.
+type Filters struct {
+	Actor  string
+	Action string
+	Space  string
+	Slug   string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// DB returns the database of the audit log.
+func DB() *kivik.DB {
+	return base.DBClient.DB(context.Background(), base.DBName(auditDBSuffix))
+}
+
+// Prepare makes sure that the database of the audit log exists, and that it
+// is append-only.
+func Prepare() error {
+	ctx := context.Background()
+	dbName := base.DBName(auditDBSuffix)
+	exists, err := base.DBClient.DBExists(ctx, dbName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		fmt.Printf("Creating database %q...", dbName)
+		if err := base.DBClient.CreateDB(ctx, dbName); err != nil {
+			return err
+		}
+		fmt.Println("ok.")
+	}
+
+	db := DB()
+	id := "_design/append-only"
+	row := db.Get(ctx, id)
+	if row.Err == nil {
+		return nil
+	} else if kivik.StatusCode(row.Err) != http.StatusNotFound {
+		return row.Err
+	}
+	_, err = db.Put(ctx, id, map[string]interface{}{
+		"validate_doc_update": designDoc,
+	})
+	return err
+}
+
+// CLIActor returns the actor for the actions made from the command line: the
+// name of the operator on the system.
+func CLIActor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if name == "" {
+		return "cli"
+	}
+	return "cli:" + name
+}
+
+// Record adds an entry to the audit log. The action has already been made,
+// so an error is only logged.
+func Record(entry *Entry) {
+	now := time.Now().UTC()
+	entry.ID = fmt.Sprintf("%019d-%s", now.UnixNano(), newID())
+	entry.CreatedAt = now
+	if _, err := DB().Put(context.Background(), entry.ID, entry); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"nspace":    "audit",
+			"actor":     entry.Actor,
+			"action":    entry.Action,
+			"space":     entry.Space,
+			"slug":      entry.Slug,
+			"version":   entry.Version,
+			"error_msg": err,
+		}).Error("Cannot record the action in the audit log")
+	}
+}
+
+// List returns the entries of the audit log that match the filters, the most
+// recent first.
+func List(filters Filters) ([]*Entry, error) {
+	// The IDs start with the date, and the lower bound excludes the design
+	// document
+	id := map[string]interface{}{"$gte": "0"}
+	if !filters.Since.IsZero() {
+		id["$gte"] = fmt.Sprintf("%019d", filters.Since.UnixNano())
+	}
+	if !filters.Until.IsZero() {
+		id["$lt"] = fmt.Sprintf("%019d", filters.Until.UnixNano())
+	}
+	selector := map[string]interface{}{"_id": id}
+	if filters.Actor != "" {
+		selector["actor"] = filters.Actor
+	}
+	if filters.Action != "" {
+		selector["action"] = filters.Action
+	}
+	if filters.Space != "" {
+		selector["space"] = filters.Space
+	}
+	if filters.Slug != "" {
+		selector["slug"] = filters.Slug
+	}
+	limit := filters.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	rows, err := DB().Find(context.Background(), map[string]interface{}{
+		"selector": selector,
+		"sort":     []map[string]string{{"_id": "desc"}},
+		"limit":    limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]*Entry, 0)
+	for rows.Next() {
+		var entry Entry
+		if err = rows.ScanDoc(&entry); err != nil {
+			return nil, err
+		}
+		entry.Rev = ""
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -57,6 +58,9 @@ type (
 		masterSalt         []byte
 		autoPublication    bool
 		revocationCounters map[string]int
+		publicKeys         []ed25519.PublicKey
+		tokens             []*TokenInfo
+		profile            *Profile
 	}
 )
 
@@ -168,14 +172,25 @@ func (r *EditorRegistry) CreateEditorWithoutPublicKey(editorName string, autoPub
 
 func (r *EditorRegistry) RevokeMasterTokens(editor *Editor) error {
 	editor.masterSalt = readRand(saltsLen)
+	markTokensRevoked(editor, true)
 	return r.UpdateEditor(editor)
 }
 
 func (r *EditorRegistry) RevokeEditorTokens(editor *Editor) error {
 	editor.editorSalt = readRand(saltsLen)
+	markTokensRevoked(editor, false)
 	return r.UpdateEditor(editor)
 }
 
+func markTokensRevoked(editor *Editor, master bool) {
+	now := time.Now().UTC()
+	for _, t := range editor.tokens {
+		if t.Master == master && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+}
+
 func DecryptMasterSecret(content, passphrase []byte) ([]byte, error) {
 	var encryptedSecret struct {
 		Salt   []byte
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The operations that can be allowed for an editor token.
+const (
+	// OperationPublish allows to publish new versions of the application.
+	OperationPublish = "publish"
+	// OperationMaintenance allows to activate and deactivate the maintenance
+	// mode for the application.
+	OperationMaintenance = "maintenance"
+	// OperationPatch allows to modify the application.
+	OperationPatch = "patch"
+	// OperationStats allows to read the download statistics of the
+	// application.
+	OperationStats = "stats"
+)
+
+var validOperations = []string{OperationPublish, OperationMaintenance, OperationPatch, OperationStats}
+var validChannels = []string{"stable", "beta", "dev"}
+
+// Claims restrict what an editor token can be used for. An empty list means
+// that there is no restriction, except for the operations where the legacy
+// tokens can only publish new versions.
+type Claims struct {
+	Spaces     []string `json:"spaces,omitempty"`
+	Channels   []string `json:"channels,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+}
+
+// Action describes what a request wants to do with an editor token.
+type Action struct {
+	Operation string
+	// Space is the name of the space (__default__ for the default space)
+	Space string
+	// Channel is the channel of the version for the publish operation
+	Channel string
+}
+
+// NewClaims checks and normalizes the given restrictions.
+func NewClaims(spaces, channels, operations []string) (*Claims, error) {
+	for _, channel := range channels {
+		if !inList(channel, validChannels) {
+			return nil, fmt.Errorf("Invalid channel %q: should be stable, beta or dev", channel)
+		}
+	}
+	for _, op := range operations {
+		if !inList(op, validOperations) {
+			return nil, fmt.Errorf("Invalid operation %q: should be %s", op, strings.Join(validOperations, ", "))
+		}
+	}
+	claims := &Claims{
+		Spaces:     normalizeList(spaces),
+		Channels:   normalizeList(channels),
+		Operations: normalizeList(operations),
+	}
+	if claims.IsEmpty() {
+		return nil, nil
+	}
+	return claims, nil
+}
+
+// IsEmpty returns true if the claims do not add any restriction.
+func (c *Claims) IsEmpty() bool {
+	return c == nil || (len(c.Spaces) == 0 && len(c.Channels) == 0 && len(c.Operations) == 0)
+}
+
+// Allows returns true if the action is allowed by the claims.
+func (c *Claims) Allows(action Action) bool {
+	operations := []string{OperationPublish}
+	if c != nil && len(c.Operations) > 0 {
+		operations = c.Operations
+	}
+	if !inList(action.Operation, operations) {
+		return false
+	}
+	if c == nil {
+		return true
+	}
+	if len(c.Spaces) > 0 && !inList(action.Space, c.Spaces) {
+		return false
+	}
+	if action.Channel != "" && len(c.Channels) > 0 && !inList(action.Channel, c.Channels) {
+		return false
+	}
+	return true
+}
+
+// String returns a human readable description of the claims.
+func (c *Claims) String() string {
+	describe := func(list []string, all string) string {
+		if len(list) == 0 {
+			return all
+		}
+		return strings.Join(list, ", ")
+	}
+	var operations []string
+	if c != nil {
+		operations = c.Operations
+	}
+	if len(operations) == 0 {
+		operations = []string{OperationPublish}
+	}
+	if c == nil {
+		c = &Claims{}
+	}
+	return fmt.Sprintf("spaces: %s\nchannels: %s\noperations: %s",
+		describe(c.Spaces, "all"),
+		describe(c.Channels, "all"),
+		strings.Join(operations, ", "))
+}
+
+// additionalData returns the serialization of the claims that is added to
+// the additional data of the token, so that a token and its claims cannot be
+// separated.
+func (c *Claims) additionalData() string {
+	if c.IsEmpty() {
+		return ""
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return ";claims=" + string(data)
+}
+
+func normalizeList(list []string) []string {
+	var normalized []string
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item != "" && !inList(item, normalized) {
+			normalized = append(normalized, item)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func inList(item string, list []string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
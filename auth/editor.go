@@ -13,13 +13,17 @@ import (
 )
 
 type tokenData struct {
-	App string `json:"app"`
+	ID     string  `json:"id,omitempty"`
+	App    string  `json:"app,omitempty"`
+	Claims *Claims `json:"claims,omitempty"`
 }
 
 func (t *tokenData) UnmarshalJSON(data []byte) error {
 	var v struct {
-		Apps []string `json:"apps"` // retro-compat
-		App  string   `json:"app"`
+		ID     string   `json:"id"`
+		Apps   []string `json:"apps"` // retro-compat
+		App    string   `json:"app"`
+		Claims *Claims  `json:"claims"`
 	}
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
@@ -29,6 +33,8 @@ func (t *tokenData) UnmarshalJSON(data []byte) error {
 	} else {
 		t.App = v.App
 	}
+	t.ID = v.ID
+	t.Claims = v.Claims
 	return nil
 }
 
@@ -38,9 +44,15 @@ func NewEditorForTest(name string) *Editor {
 
 func (e *Editor) MarshalJSON() ([]byte, error) {
 	v := struct {
-		Name string `json:"name"`
+		Name       string   `json:"name"`
+		PublicKeys []string `json:"public_keys,omitempty"`
+		Profile
 	}{
-		Name: e.name,
+		Name:    e.name,
+		Profile: e.Profile(),
+	}
+	for _, key := range e.publicKeys {
+		v.PublicKeys = append(v.PublicKeys, EncodePublicKey(key))
 	}
 	return json.Marshal(v)
 }
@@ -57,16 +69,28 @@ func (e *Editor) IsComplete() bool {
 	return len(e.name) > 0 && len(e.editorSalt) == saltsLen
 }
 
-func (e *Editor) GenerateMasterToken(masterSecret []byte, maxAge time.Duration) ([]byte, error) {
+// GenerateMasterToken returns a new master token for the editor, with the
+// informations about it that can be recorded with Editors.AddToken.
+func (e *Editor) GenerateMasterToken(masterSecret []byte, maxAge time.Duration) ([]byte, *TokenInfo, error) {
 	editorSecret, err := e.derivateSecret(masterSecret, e.masterSalt)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	token, err := generateToken(editorSecret, nil, nil, 0)
+	info := newTokenInfo(maxAge)
+	info.Master = true
+	data, err := json.Marshal(tokenData{ID: info.ID})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	token, err := generateToken(editorSecret, data, nil, 0)
+	if err != nil {
+		return nil, nil, err
 	}
-	return generateToken(masterSecret, token, nil, maxAge)
+	token, err = generateToken(masterSecret, token, nil, maxAge)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, info, nil
 }
 
 func (e *Editor) VerifyMasterToken(masterSecret, token []byte) bool {
@@ -78,64 +102,102 @@ func (e *Editor) VerifyMasterToken(masterSecret, token []byte) bool {
 	if err != nil {
 		return false
 	}
-	_, ok = verifyToken(sessionSecret, value, nil)
-	return ok
+	data, ok := verifyToken(sessionSecret, value, nil)
+	if !ok {
+		return false
+	}
+	var v tokenData
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &v); err != nil {
+			return false
+		}
+	}
+	return !e.isTokenRevoked(v.ID)
 }
 
-func (e *Editor) GenerateEditorToken(masterSecret []byte, maxAge time.Duration, appName string) ([]byte, error) {
+// GenerateEditorToken returns a token that can be used to publish versions of
+// the given application, with the informations about it that can be recorded
+// with Editors.AddToken. The claims are optional, and can be used to restrict
+// the spaces, channels and operations allowed for this token.
+func (e *Editor) GenerateEditorToken(masterSecret []byte, maxAge time.Duration, appName string, claims *Claims) ([]byte, *TokenInfo, error) {
 	if appName == "" {
-		return nil, fmt.Errorf("Could not generate editor token without application name")
+		return nil, nil, fmt.Errorf("Could not generate editor token without application name")
 	}
 	sessionSecret, err := e.derivateSecret(masterSecret, e.editorSalt)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	var data []byte
-	data, err = json.Marshal(tokenData{App: appName})
+	if claims.IsEmpty() {
+		claims = nil
+	}
+	info := newTokenInfo(maxAge)
+	info.App = appName
+	info.Claims = claims
+	data, err = json.Marshal(tokenData{ID: info.ID, App: appName, Claims: claims})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	token, err := generateToken(sessionSecret, data, e.additionalData(appName), 0)
+	token, err := generateToken(sessionSecret, data, e.additionalData(appName, claims), 0)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	token, err = generateToken(masterSecret, token, nil, maxAge)
+	if err != nil {
+		return nil, nil, err
 	}
-	return generateToken(masterSecret, token, nil, maxAge)
+	return token, info, nil
 }
 
 func (e *Editor) VerifyEditorToken(masterSecret, token []byte, appName string) bool {
+	_, ok := e.EditorTokenClaims(masterSecret, token, appName)
+	return ok
+}
+
+// EditorTokenClaims verifies the editor token for the given application, and
+// returns its claims (nil for a token without restriction on the spaces and
+// channels).
+func (e *Editor) EditorTokenClaims(masterSecret, token []byte, appName string) (*Claims, bool) {
 	if appName == "" {
 		panic(errors.New("Could not verify token: empty application name"))
 	}
 	value, ok := verifyToken(masterSecret, token, nil)
 	if !ok {
-		return false
+		return nil, false
 	}
 	sessionSecret, err := e.derivateSecret(masterSecret, e.editorSalt)
 	if err != nil {
-		return false
+		return nil, false
 	}
-	var data []byte
-	data, ok = verifyToken(sessionSecret, value, e.additionalData(appName))
-	if !ok {
-		return false
+	// The claims are part of the additional data, so they must be read from
+	// the message before verifying it
+	if len(value) < 8+32 {
+		return nil, false
 	}
 	var v tokenData
-	if len(data) > 0 {
+	if data := value[8 : len(value)-32]; len(data) > 0 {
 		if err = json.Unmarshal(data, &v); err != nil {
-			return false
+			return nil, false
 		}
 	}
-	if v.App == "" {
-		return true
+	if _, ok = verifyToken(sessionSecret, value, e.additionalData(appName, v.Claims)); !ok {
+		return nil, false
+	}
+	if v.App != "" && appName != v.App {
+		return nil, false
+	}
+	if e.isTokenRevoked(v.ID) {
+		return nil, false
 	}
-	return appName == v.App
+	return v.Claims, true
 }
 
-func (e *Editor) additionalData(appName string) []byte {
+func (e *Editor) additionalData(appName string, claims *Claims) []byte {
 	editorName := strings.ToLower(e.name)
 	if counter, ok := e.revocationCounters[appName]; ok && counter > 0 {
 		editorName += fmt.Sprintf(";counter=%d", counter)
 	}
+	editorName += claims.additionalData()
 	return []byte(editorName)
 }
 
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kivik/kivik/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditorTokenClaims(t *testing.T) {
+	secret := GenerateMasterSecret()
+	editor := &Editor{
+		name:       "cozy",
+		editorSalt: readRand(saltsLen),
+		masterSalt: readRand(saltsLen),
+	}
+
+	// A token without claims can only publish
+	token, _, err := editor.GenerateEditorToken(secret, time.Hour, "drive", nil)
+	assert.NoError(t, err)
+	claims, ok := editor.EditorTokenClaims(secret, token, "drive")
+	assert.True(t, ok)
+	assert.Nil(t, claims)
+	assert.True(t, claims.Allows(Action{Operation: OperationPublish, Space: "__default__", Channel: "stable"}))
+	assert.False(t, claims.Allows(Action{Operation: OperationMaintenance, Space: "__default__"}))
+	assert.False(t, editor.VerifyEditorToken(secret, token, "photos"))
+
+	claims, err = NewClaims([]string{"__default__"}, []string{"dev", "beta"}, []string{"publish", "maintenance"})
+	assert.NoError(t, err)
+	token, _, err = editor.GenerateEditorToken(secret, time.Hour, "drive", claims)
+	assert.NoError(t, err)
+	claims, ok = editor.EditorTokenClaims(secret, token, "drive")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"beta", "dev"}, claims.Channels)
+	assert.True(t, claims.Allows(Action{Operation: OperationPublish, Space: "__default__", Channel: "beta"}))
+	assert.False(t, claims.Allows(Action{Operation: OperationPublish, Space: "__default__", Channel: "stable"}))
+	assert.False(t, claims.Allows(Action{Operation: OperationPublish, Space: "other", Channel: "dev"}))
+	assert.True(t, claims.Allows(Action{Operation: OperationMaintenance, Space: "__default__"}))
+	assert.False(t, claims.Allows(Action{Operation: OperationPatch, Space: "__default__"}))
+
+	// The claims cannot be modified without invalidating the token
+	tampered := append([]byte{}, token...)
+	for i := range tampered {
+		if string(tampered[i:i+4]) == "beta" {
+			copy(tampered[i:], "prod")
+			break
+		}
+	}
+	_, ok = editor.EditorTokenClaims(secret, tampered, "drive")
+	assert.False(t, ok)
+}
+
+func TestNewClaimsInvalid(t *testing.T) {
+	_, err := NewClaims(nil, []string{"nightly"}, nil)
+	assert.Error(t, err)
+	_, err = NewClaims(nil, nil, []string{"delete"})
+	assert.Error(t, err)
+	claims, err := NewClaims(nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, claims)
+}
+
+func TestRevokedTokens(t *testing.T) {
+	secret := GenerateMasterSecret()
+	editor := &Editor{
+		name:       "cozy",
+		editorSalt: readRand(saltsLen),
+		masterSalt: readRand(saltsLen),
+	}
+
+	token1, info1, err := editor.GenerateEditorToken(secret, 0, "drive", nil)
+	assert.NoError(t, err)
+	token2, info2, err := editor.GenerateEditorToken(secret, 0, "drive", nil)
+	assert.NoError(t, err)
+	master, info3, err := editor.GenerateMasterToken(secret, time.Hour)
+	assert.NoError(t, err)
+	assert.NotEqual(t, info1.ID, info2.ID)
+	assert.Equal(t, info1.ID, tokenID(token1))
+	assert.Equal(t, info3.ID, tokenID(master))
+	assert.True(t, info3.Master)
+	assert.NotNil(t, info3.ExpiresAt)
+	editor.tokens = []*TokenInfo{info1, info2, info3}
+
+	now := time.Now()
+	info1.RevokedAt = &now
+	assert.False(t, editor.VerifyEditorToken(secret, token1, "drive"))
+	assert.True(t, editor.VerifyEditorToken(secret, token2, "drive"))
+	assert.True(t, editor.VerifyMasterToken(secret, master))
+
+	info3.RevokedAt = &now
+	assert.False(t, editor.VerifyMasterToken(secret, master))
+}
+
+type memoryVault struct {
+	editors map[string]*Editor
+}
+
+func (v *memoryVault) GetEditor(name string) (*Editor, error) {
+	if e, ok := v.editors[name]; ok {
+		return e, nil
+	}
+	return nil, ErrEditorNotFound
+}
+func (v *memoryVault) CreateEditor(e *Editor) error { v.editors[e.name] = e; return nil }
+func (v *memoryVault) UpdateEditor(e *Editor) error { v.editors[e.name] = e; return nil }
+func (v *memoryVault) DeleteEditor(e *Editor) error { delete(v.editors, e.name); return nil }
+func (v *memoryVault) AllEditors() ([]*Editor, error) {
+	editors := make([]*Editor, 0, len(v.editors))
+	for _, e := range v.editors {
+		editors = append(editors, e)
+	}
+	return editors, nil
+}
+
+func TestModifyProfile(t *testing.T) {
+	r := NewEditorRegistry(&memoryVault{editors: make(map[string]*Editor)})
+	editor, err := r.CreateEditorWithoutPublicKey("cozy", false)
+	assert.NoError(t, err)
+	assert.Equal(t, Profile{}, editor.Profile())
+
+	name, website, email := "Cozy Cloud", "https://cozy.io", "support@cozy.io"
+	err = r.ModifyProfile(editor, ProfileOptions{
+		DisplayName:  &name,
+		Website:      &website,
+		SupportEmail: &email,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, r.SetLogo(editor, "abcdef"))
+	assert.NoError(t, r.SetVerified(editor, true))
+
+	b, err := json.Marshal(editor)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"name": "cozy",
+		"display_name": "Cozy Cloud",
+		"website": "https://cozy.io",
+		"support_email": "support@cozy.io",
+		"logo": "abcdef",
+		"verified": true
+	}`, string(b))
+
+	// The fields that are not given are left unchanged
+	empty := ""
+	err = r.ModifyProfile(editor, ProfileOptions{Website: &empty})
+	assert.NoError(t, err)
+	assert.Equal(t, "", editor.Profile().Website)
+	assert.Equal(t, "Cozy Cloud", editor.Profile().DisplayName)
+
+	// An editor can't verify itself
+	var opts ProfileOptions
+	assert.NoError(t, json.Unmarshal([]byte(`{"verified": false}`), &opts))
+	assert.NoError(t, r.ModifyProfile(editor, opts))
+	assert.True(t, editor.Profile().Verified)
+
+	invalid := "javascript:alert(1)"
+	err = r.ModifyProfile(editor, ProfileOptions{Website: &invalid})
+	assert.Equal(t, ErrInvalidWebsite, err)
+	invalid = "Cozy <support@cozy.io>"
+	err = r.ModifyProfile(editor, ProfileOptions{SupportEmail: &invalid})
+	assert.Equal(t, ErrInvalidSupportEmail, err)
+	invalid = strings.Repeat("a", maxDescriptionLen+1)
+	err = r.ModifyProfile(editor, ProfileOptions{Description: &invalid})
+	assert.Equal(t, ErrDescriptionTooLong, err)
+}
+
+func TestRevokeAppTokens(t *testing.T) {
+	secret := GenerateMasterSecret()
+	r := NewEditorRegistry(&memoryVault{editors: make(map[string]*Editor)})
+	editor, err := r.CreateEditorWithoutPublicKey("cozy", false)
+	assert.NoError(t, err)
+
+	drive, info, err := editor.GenerateEditorToken(secret, 0, "drive", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, r.AddToken(editor, info, ""))
+	photos, _, err := editor.GenerateEditorToken(secret, 0, "photos", nil)
+	assert.NoError(t, err)
+	master, _, err := editor.GenerateMasterToken(secret, 0)
+	assert.NoError(t, err)
+
+	assert.NoError(t, r.RevokeAppTokens(editor, "drive"))
+	assert.False(t, editor.VerifyEditorToken(secret, drive, "drive"))
+	assert.NotNil(t, info.RevokedAt)
+	assert.True(t, editor.VerifyEditorToken(secret, photos, "photos"))
+	assert.True(t, editor.VerifyMasterToken(secret, master))
+
+	// The new tokens for the application are valid
+	drive, _, err = editor.GenerateEditorToken(secret, 0, "drive", nil)
+	assert.NoError(t, err)
+	assert.True(t, editor.VerifyEditorToken(secret, drive, "drive"))
+}
+
+func TestAddToken(t *testing.T) {
+	secret := GenerateMasterSecret()
+	r := NewEditorRegistry(&memoryVault{editors: make(map[string]*Editor)})
+	editor, err := r.CreateEditorWithoutPublicKey("cozy", false)
+	assert.NoError(t, err)
+
+	expired := time.Now().Add(-2 * expiredTokensRetention)
+	var infos []*TokenInfo
+	for _, app := range []string{"drive", "photos"} {
+		_, info, err := editor.GenerateEditorToken(secret, 0, app, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, r.AddToken(editor, info, ""))
+		infos = append(infos, info)
+	}
+	infos[0].ExpiresAt = &expired
+	before := editor.Tokens()
+
+	_, info, err := editor.GenerateEditorToken(secret, 0, "banks", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, r.AddToken(editor, info, ""))
+	assert.Equal(t, []*TokenInfo{infos[1], info}, editor.Tokens())
+	// The tokens previously returned are not modified
+	assert.Equal(t, infos, before)
+}
+
+func TestRemovePublicKey(t *testing.T) {
+	r := NewEditorRegistry(&memoryVault{editors: make(map[string]*Editor)})
+	editor, err := r.CreateEditorWithoutPublicKey("cozy", false)
+	assert.NoError(t, err)
+
+	var keys []ed25519.PublicKey
+	for i := 0; i < 3; i++ {
+		pub, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		assert.NoError(t, r.AddPublicKey(editor, pub))
+		keys = append(keys, pub)
+	}
+	before := editor.PublicKeys()
+
+	assert.NoError(t, r.RemovePublicKey(editor, PublicKeyFingerprint(keys[1])))
+	assert.Equal(t, []ed25519.PublicKey{keys[0], keys[2]}, editor.PublicKeys())
+	// The keys previously returned are not modified
+	assert.Equal(t, keys, before)
+
+	assert.NoError(t, r.RemovePublicKey(editor, EncodePublicKey(keys[0])))
+	assert.Equal(t, []ed25519.PublicKey{keys[2]}, editor.PublicKeys())
+	assert.Equal(t, ErrPublicKeyNotFound, r.RemovePublicKey(editor, EncodePublicKey(keys[1])))
+}
+
+// conflictingVault returns a conflict on the first updates of the editors.
+type conflictingVault struct {
+	*memoryVault
+	conflicts int
+	updates   int
+}
+
+func (v *conflictingVault) UpdateEditor(e *Editor) error {
+	v.updates++
+	if v.conflicts > 0 {
+		v.conflicts--
+		return &kivik.Error{HTTPStatus: http.StatusConflict}
+	}
+	return v.memoryVault.UpdateEditor(e)
+}
+
+func TestFlushLastUses(t *testing.T) {
+	secret := GenerateMasterSecret()
+	vault := &conflictingVault{memoryVault: &memoryVault{editors: make(map[string]*Editor)}}
+	r := NewEditorRegistry(vault)
+	editor, err := r.CreateEditorWithoutPublicKey("cozy", false)
+	assert.NoError(t, err)
+
+	drive, driveInfo, err := editor.GenerateEditorToken(secret, 0, "drive", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, r.AddToken(editor, driveInfo, ""))
+	master, masterInfo, err := editor.GenerateMasterToken(secret, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, r.AddToken(editor, masterInfo, ""))
+
+	// The last uses are saved with a single update of the editor, even when
+	// the tokens are used several times
+	vault.updates = 0
+	vault.conflicts = 1
+	TouchToken(editor, drive)
+	TouchToken(editor, master)
+	TouchToken(editor, drive)
+	assert.Nil(t, driveInfo.LastUsedAt)
+	assert.NoError(t, r.FlushLastUses())
+	assert.Equal(t, 2, vault.updates)
+	assert.NotNil(t, driveInfo.LastUsedAt)
+	assert.NotNil(t, masterInfo.LastUsedAt)
+
+	// Nothing is written when no token has been used
+	vault.updates = 0
+	assert.NoError(t, r.FlushLastUses())
+	assert.Equal(t, 0, vault.updates)
+
+	// The last uses that cannot be saved are kept for the next flush
+	photos, photosInfo, err := editor.GenerateEditorToken(secret, 0, "photos", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, r.AddToken(editor, photosInfo, ""))
+	vault.conflicts = maxLastUsesRetries + 1
+	TouchToken(editor, photos)
+	assert.Error(t, r.FlushLastUses())
+	vault.updates = 0
+	assert.NoError(t, r.FlushLastUses())
+	assert.Equal(t, 1, vault.updates)
+	assert.NotNil(t, photosInfo.LastUsedAt)
+}
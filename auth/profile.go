@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cozy/cozy-apps-registry/errshttp"
+)
+
+// maxDescriptionLen is the maximal number of characters of the description
+// of an editor.
+const maxDescriptionLen = 2000
+
+var (
+	ErrInvalidWebsite      = errshttp.NewError(http.StatusBadRequest, "Website should be an http or https URL")
+	ErrInvalidSupportEmail = errshttp.NewError(http.StatusBadRequest, "Support email should be a valid email address")
+	ErrDescriptionTooLong  = errshttp.NewError(http.StatusBadRequest, "Description should not be longer than 2000 characters")
+)
+
+// Profile is the public information about an editor, shown in the store next
+// to its applications. Logo is the shasum of the logo in the global asset
+// store.
+type Profile struct {
+	DisplayName  string `json:"display_name,omitempty"`
+	Website      string `json:"website,omitempty"`
+	SupportEmail string `json:"support_email,omitempty"`
+	Logo         string `json:"logo,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Verified     bool   `json:"verified"`
+}
+
+// ProfileOptions are the fields of the profile that an editor can modify. The
+// nil fields are left unchanged, and an empty string removes the field.
+type ProfileOptions struct {
+	DisplayName  *string `json:"display_name"`
+	Website      *string `json:"website"`
+	SupportEmail *string `json:"support_email"`
+	Description  *string `json:"description"`
+}
+
+// Profile returns the profile of the editor.
+func (e *Editor) Profile() Profile {
+	if e.profile == nil {
+		return Profile{}
+	}
+	return *e.profile
+}
+
+// ModifyProfile changes the fields of the profile of the editor given in the
+// options.
+func (r *EditorRegistry) ModifyProfile(editor *Editor, opts ProfileOptions) error {
+	profile := editor.Profile()
+	if opts.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*opts.DisplayName)
+	}
+	if opts.Website != nil {
+		website := strings.TrimSpace(*opts.Website)
+		if website != "" {
+			u, err := url.Parse(website)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return ErrInvalidWebsite
+			}
+		}
+		profile.Website = website
+	}
+	if opts.SupportEmail != nil {
+		email := strings.TrimSpace(*opts.SupportEmail)
+		if email != "" {
+			addr, err := mail.ParseAddress(email)
+			if err != nil || addr.Address != email {
+				return ErrInvalidSupportEmail
+			}
+		}
+		profile.SupportEmail = email
+	}
+	if opts.Description != nil {
+		description := strings.TrimSpace(*opts.Description)
+		if utf8.RuneCountInString(description) > maxDescriptionLen {
+			return ErrDescriptionTooLong
+		}
+		profile.Description = description
+	}
+	editor.profile = &profile
+	return r.UpdateEditor(editor)
+}
+
+// SetVerified marks the editor as verified, or not, by the administrators of
+// the registry. It is not in the ProfileOptions, as an editor can't verify
+// itself.
+func (r *EditorRegistry) SetVerified(editor *Editor, verified bool) error {
+	profile := editor.Profile()
+	profile.Verified = verified
+	editor.profile = &profile
+	return r.UpdateEditor(editor)
+}
+
+// SetLogo changes the shasum of the logo of the editor. An empty shasum
+// removes the logo.
+func (r *EditorRegistry) SetLogo(editor *Editor, shasum string) error {
+	profile := editor.Profile()
+	profile.Logo = shasum
+	editor.profile = &profile
+	return r.UpdateEditor(editor)
+}
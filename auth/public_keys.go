@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-apps-registry/errshttp"
+)
+
+var (
+	ErrInvalidPublicKey  = errshttp.NewError(http.StatusBadRequest, "Public key should be an Ed25519 key, in PEM or base64")
+	ErrPublicKeyExists   = errshttp.NewError(http.StatusConflict, "Public key is already registered for this editor")
+	ErrPublicKeyNotFound = errshttp.NewError(http.StatusNotFound, "Public key was not found for this editor")
+)
+
+// ParsePublicKey parses an Ed25519 public key. It can be given in PEM (the
+// format used by `openssl pkey -pubout`) or as the base64 encoding of the 32
+// raw bytes of the key.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	encoded = strings.TrimSpace(encoded)
+	if block, _ := pem.Decode([]byte(encoded)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, ErrInvalidPublicKey
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, ErrInvalidPublicKey
+		}
+		return pub, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// EncodePublicKey returns the base64 encoding of the raw bytes of the key.
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// PublicKeyFingerprint returns a short identifier for the key, that can be
+// used to remove it.
+func PublicKeyFingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// PublicKeys returns the Ed25519 public keys registered for the editor.
+func (e *Editor) PublicKeys() []ed25519.PublicKey {
+	return e.publicKeys
+}
+
+// HasPublicKeys returns true if the editor has registered at least one public
+// key, meaning that its versions must be signed.
+func (e *Editor) HasPublicKeys() bool {
+	return len(e.publicKeys) > 0
+}
+
+// VerifySignature checks that the signature of the message has been made with
+// the private key matching one of the public keys of the editor.
+func (e *Editor) VerifySignature(message, signature []byte) bool {
+	if len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	for _, key := range e.publicKeys {
+		if ed25519.Verify(key, message, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPublicKey registers a new public key for the editor.
+func (r *EditorRegistry) AddPublicKey(editor *Editor, key ed25519.PublicKey) error {
+	for _, k := range editor.publicKeys {
+		if bytes.Equal(k, key) {
+			return ErrPublicKeyExists
+		}
+	}
+	editor.publicKeys = append(editor.publicKeys, key)
+	return r.UpdateEditor(editor)
+}
+
+// RemovePublicKey removes a public key from the editor. The key can be given
+// by its fingerprint, or in one of the formats accepted by ParsePublicKey.
+func (r *EditorRegistry) RemovePublicKey(editor *Editor, keyOrFingerprint string) error {
+	keyOrFingerprint = strings.TrimSpace(keyOrFingerprint)
+	parsed, _ := ParsePublicKey(keyOrFingerprint)
+	for i, k := range editor.publicKeys {
+		if PublicKeyFingerprint(k) == keyOrFingerprint || (parsed != nil && bytes.Equal(k, parsed)) {
+			// A new slice is used, as the keys can be shared with a copy of
+			// the editor
+			keys := make([]ed25519.PublicKey, 0, len(editor.publicKeys)-1)
+			keys = append(keys, editor.publicKeys[:i]...)
+			keys = append(keys, editor.publicKeys[i+1:]...)
+			editor.publicKeys = keys
+			return r.UpdateEditor(editor)
+		}
+	}
+	return ErrPublicKeyNotFound
+}
+
+func encodePublicKeys(keys []ed25519.PublicKey) [][]byte {
+	if len(keys) == 0 {
+		return nil
+	}
+	encoded := make([][]byte, len(keys))
+	for i, key := range keys {
+		encoded[i] = []byte(key)
+	}
+	return encoded
+}
+
+func decodePublicKeys(legacy []byte, keys [][]byte) []ed25519.PublicKey {
+	var decoded []ed25519.PublicKey
+	if len(legacy) == ed25519.PublicKeySize {
+		decoded = append(decoded, ed25519.PublicKey(legacy))
+	}
+	for _, key := range keys {
+		if len(key) == ed25519.PublicKeySize {
+			decoded = append(decoded, ed25519.PublicKey(key))
+		}
+	}
+	return decoded
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/go-kivik/kivik/v3"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+)
+
+// lastUseThrottle is the minimal delay between two updates of the last use
+// date of a token in the vault.
+const lastUseThrottle = time.Hour
+
+// lastUsesFlushInterval is the default delay between two flushes of the last
+// use dates of the tokens.
+const lastUsesFlushInterval = time.Minute
+
+// maxLastUsesRetries is the number of times an editor is updated again when
+// there is a conflict with a concurrent update.
+const maxLastUsesRetries = 5
+
+// expiredTokensRetention is how long the informations about an expired token
+// are kept before being removed from the vault.
+const expiredTokensRetention = 30 * 24 * time.Hour
+
+var ErrTokenNotFound = errshttp.NewError(http.StatusNotFound, "Token was not found for this editor")
+
+// TokenInfo is the information recorded about a generated token. The token
+// itself is not kept, only its ID.
+type TokenInfo struct {
+	ID          string        `json:"id"`
+	Master      bool          `json:"master,omitempty"`
+	App         string        `json:"app,omitempty"`
+	Claims      *Claims       `json:"claims,omitempty"`
+	Description string        `json:"description,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	MaxAge      time.Duration `json:"max_age,omitempty"`
+	ExpiresAt   *time.Time    `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time    `json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time    `json:"revoked_at,omitempty"`
+}
+
+func newTokenInfo(maxAge time.Duration) *TokenInfo {
+	now := time.Now().UTC()
+	info := &TokenInfo{
+		ID:        hex.EncodeToString(readRand(8)),
+		CreatedAt: now,
+		MaxAge:    maxAge,
+	}
+	if maxAge > 0 {
+		expiresAt := now.Add(maxAge)
+		info.ExpiresAt = &expiresAt
+	}
+	return info
+}
+
+// IsExpired returns true if the token has expired.
+func (t *TokenInfo) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// Tokens returns the informations about the tokens generated for the editor.
+func (e *Editor) Tokens() []*TokenInfo {
+	return e.tokens
+}
+
+// TokenInfo returns the informations about a token of the editor that has
+// already been verified, or nil if they are not known (old tokens).
+func (e *Editor) TokenInfo(token []byte) *TokenInfo {
+	return e.findToken(tokenID(token))
+}
+
+func (e *Editor) findToken(id string) *TokenInfo {
+	for _, t := range e.tokens {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (e *Editor) isTokenRevoked(id string) bool {
+	if id == "" {
+		return false
+	}
+	t := e.findToken(id)
+	return t != nil && t.RevokedAt != nil
+}
+
+// AddToken records the informations about a newly generated token in the
+// vault. The informations about the tokens expired for a long time are
+// removed at the same time.
+func (r *EditorRegistry) AddToken(editor *Editor, info *TokenInfo, description string) error {
+	info.Description = description
+	// A new slice is used, as the tokens can be shared with a copy of the
+	// editor
+	tokens := make([]*TokenInfo, 0, len(editor.tokens)+1)
+	for _, t := range editor.tokens {
+		if t.ExpiresAt == nil || time.Since(*t.ExpiresAt) < expiredTokensRetention {
+			tokens = append(tokens, t)
+		}
+	}
+	editor.tokens = append(tokens, info)
+	return r.UpdateEditor(editor)
+}
+
+// RevokeToken revokes a single token of the editor, without affecting the
+// others.
+func (r *EditorRegistry) RevokeToken(editor *Editor, id string) error {
+	t := editor.findToken(id)
+	if t == nil {
+		return ErrTokenNotFound
+	}
+	if t.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	t.RevokedAt = &now
+	return r.UpdateEditor(editor)
+}
+
+// RevokeAppTokens revokes all the tokens of the editor for an application,
+// including the old tokens that have no ID.
+func (r *EditorRegistry) RevokeAppTokens(editor *Editor, appName string) error {
+	if editor.revocationCounters == nil {
+		editor.revocationCounters = make(map[string]int)
+	}
+	editor.revocationCounters[appName]++
+	now := time.Now().UTC()
+	for _, t := range editor.tokens {
+		if !t.Master && t.App == appName && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return r.UpdateEditor(editor)
+}
+
+// The last use dates are kept in memory, and flushed periodically to the
+// vault: an editor is updated once for all its tokens, instead of once per
+// request. dates is used to throttle the updates, and pending are the dates
+// to save, by editor name and token ID.
+var lastUses = struct {
+	sync.Mutex
+	dates   map[string]time.Time
+	pending map[string]map[string]time.Time
+}{
+	dates:   make(map[string]time.Time),
+	pending: make(map[string]map[string]time.Time),
+}
+
+// TouchToken records the last use date of a verified token. It is saved in
+// the vault on the next flush, and at most once per hour for a token.
+func TouchToken(editor *Editor, token []byte) {
+	id := tokenID(token)
+	if id == "" {
+		return
+	}
+
+	now := time.Now().UTC()
+	lastUses.Lock()
+	defer lastUses.Unlock()
+	if last, ok := lastUses.dates[id]; ok && now.Sub(last) < lastUseThrottle {
+		return
+	}
+	lastUses.dates[id] = now
+	addPendingLastUse(editor.Name(), id, now)
+}
+
+// addPendingLastUse must be called with the lock on lastUses.
+func addPendingLastUse(editorName, id string, date time.Time) {
+	dates, ok := lastUses.pending[editorName]
+	if !ok {
+		dates = make(map[string]time.Time)
+		lastUses.pending[editorName] = dates
+	}
+	if date.After(dates[id]) {
+		dates[id] = date
+	}
+}
+
+// FlushLastUses saves the last use dates recorded since the last flush in
+// the vault. The dates that cannot be saved are kept for the next flush.
+func (r *EditorRegistry) FlushLastUses() error {
+	lastUses.Lock()
+	pending := lastUses.pending
+	lastUses.pending = make(map[string]map[string]time.Time)
+	lastUses.Unlock()
+
+	var errm error
+	for name, dates := range pending {
+		if err := r.saveLastUses(name, dates); err != nil {
+			errm = multierror.Append(errm, err)
+			lastUses.Lock()
+			for id, date := range dates {
+				addPendingLastUse(name, id, date)
+			}
+			lastUses.Unlock()
+		}
+	}
+	return errm
+}
+
+// StartLastUsesFlush launches the periodic flush of the last use dates.
+func (r *EditorRegistry) StartLastUsesFlush(interval time.Duration) {
+	if interval <= 0 {
+		interval = lastUsesFlushInterval
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := r.FlushLastUses(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"nspace":    "tokens",
+					"error_msg": err,
+				}).Warn("Could not save the last use of the tokens")
+			}
+		}
+	}()
+}
+
+func (r *EditorRegistry) saveLastUses(editorName string, dates map[string]time.Time) error {
+	for i := 0; ; i++ {
+		// Reload the editor to not overwrite a concurrent change, like a
+		// revocation
+		editor, err := r.GetEditor(editorName)
+		if err == ErrEditorNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		changed := false
+		for id, date := range dates {
+			if t := editor.findToken(id); t != nil {
+				date := date
+				t.LastUsedAt = &date
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		err = r.UpdateEditor(editor)
+		if kivik.StatusCode(err) != http.StatusConflict || i >= maxLastUsesRetries {
+			return err
+		}
+	}
+}
+
+// tokenID extracts the ID of a token that has already been verified. It
+// returns an empty string for the old tokens that have no ID.
+func tokenID(token []byte) string {
+	// token = maxAge (8 bytes) + inner token + mac (32 bytes)
+	// inner token = maxAge (8 bytes) + data + mac (32 bytes)
+	if len(token) < 2*(8+32) {
+		return ""
+	}
+	data := token[16 : len(token)-64]
+	if len(data) == 0 {
+		return ""
+	}
+	var v tokenData
+	if err := json.Unmarshal(data, &v); err != nil {
+		return ""
+	}
+	return v.ID
+}
@@ -19,9 +19,12 @@ type editorForCouchdb struct {
 	Name               string         `json:"name"`
 	EditorSalt         []byte         `json:"session_secret_salt"`
 	MasterSalt         []byte         `json:"master_secret_salt"`
-	PublicKeyBytes     []byte         `json:"public_key"`
+	PublicKeyBytes     []byte         `json:"public_key,omitempty"`
+	PublicKeys         [][]byte       `json:"public_keys,omitempty"`
+	Tokens             []*TokenInfo   `json:"tokens,omitempty"`
 	AutoPublication    bool           `json:"auto_publication"`
 	RevocationCounters map[string]int `json:"revocation_counters,omitempty"`
+	Profile            *Profile       `json:"profile,omitempty"`
 }
 
 func NewCouchDBVault(db *kivik.DB) Vault {
@@ -43,6 +46,9 @@ func (r *couchdbVault) GetEditor(editorName string) (*Editor, error) {
 		masterSalt:         e.MasterSalt,
 		autoPublication:    e.AutoPublication,
 		revocationCounters: e.RevocationCounters,
+		publicKeys:         decodePublicKeys(e.PublicKeyBytes, e.PublicKeys),
+		tokens:             e.Tokens,
+		profile:            e.Profile,
 	}
 	var needUpdate bool
 	if len(editor.masterSalt) == 0 {
@@ -76,6 +82,9 @@ func (r *couchdbVault) CreateEditor(editor *Editor) error {
 		MasterSalt:         editor.masterSalt,
 		AutoPublication:    editor.autoPublication,
 		RevocationCounters: editor.revocationCounters,
+		PublicKeys:         encodePublicKeys(editor.publicKeys),
+		Tokens:             editor.tokens,
+		Profile:            editor.profile,
 	})
 	return err
 }
@@ -93,6 +102,9 @@ func (r *couchdbVault) UpdateEditor(editor *Editor) error {
 		MasterSalt:         editor.masterSalt,
 		AutoPublication:    editor.autoPublication,
 		RevocationCounters: editor.revocationCounters,
+		PublicKeys:         encodePublicKeys(editor.publicKeys),
+		Tokens:             editor.tokens,
+		Profile:            editor.profile,
 	})
 	return err
 }
@@ -129,6 +141,9 @@ func (r *couchdbVault) AllEditors() ([]*Editor, error) {
 			masterSalt:         e.MasterSalt,
 			autoPublication:    e.AutoPublication,
 			revocationCounters: e.RevocationCounters,
+			publicKeys:         decodePublicKeys(e.PublicKeyBytes, e.PublicKeys),
+			tokens:             e.Tokens,
+			profile:            e.Profile,
 		})
 	}
 	return editors, nil
@@ -31,6 +31,8 @@ type AssetStore interface {
 	Add(asset *Asset, content io.Reader, source string) error
 	// Get returns the asset content and the headers.
 	Get(shasum string) (*bytes.Buffer, map[string]string, error)
+	// Open opens the asset content, to read it as a stream.
+	Open(shasum string) (*Object, error)
 	// Remove can be used to remove an asset from the store.
 	Remove(shasum string, source string) error
 	// GetDB returns the kivik.DB objects for low-level operations.
@@ -2,6 +2,7 @@ package base
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-kivik/kivik/v3"
 )
@@ -19,6 +20,18 @@ type VirtualSpace struct {
 	Slugs []string
 }
 
+// DefaultMaxAppSize is the default for the maximal size in bytes of the
+// tarball of an application version.
+const DefaultMaxAppSize = 20 * 1024 * 1024 // 20 Mo
+
+// DefaultStatsFlushInterval is the default delay between two flushes of the
+// download statistics to CouchDB.
+const DefaultStatsFlushInterval = time.Minute
+
+// DefaultStatsTotalsInterval is the default delay between two updates of the
+// total number of downloads of the applications.
+const DefaultStatsTotalsInterval = time.Hour
+
 // ConfigParameters is a list of parameters that can be configured.
 type ConfigParameters struct {
 	// CleanEnabled specifies if the app cleaning task is enabled or not.
@@ -34,6 +47,100 @@ type ConfigParameters struct {
 	// TrustedDomains is used by the universal link to allow redirections on
 	// trusted domains.
 	TrustedDomains map[string][]string
+
+	// MaxAppSize is the maximal size in bytes of the tarball of an application
+	// version.
+	MaxAppSize int64
+	// SpaceOptions are the options specific to a space: space name -> options.
+	SpaceOptions map[string]SpaceOptions
+
+	// StatsFlushInterval is the delay between two flushes of the download
+	// statistics to CouchDB.
+	StatsFlushInterval time.Duration
+	// StatsTotalsInterval is the delay between two updates of the total
+	// number of downloads of the applications.
+	StatsTotalsInterval time.Duration
+}
+
+// SpaceOptions is a list of parameters that can be configured for a space, to
+// override the global ones.
+type SpaceOptions struct {
+	// MaxAppSize is the maximal size in bytes of the tarball of an application
+	// version (0 means that the global value is used).
+	MaxAppSize int64
+	// Webhooks is the list of URLs that are notified of the events in this
+	// space.
+	Webhooks []Webhook
+	// Mirror is set when the space mirrors the apps of a remote registry.
+	Mirror *Mirror
+}
+
+// Mirror is the configuration of a space that mirrors some applications of a
+// space of a remote registry.
+type Mirror struct {
+	// URL is the base URL of the remote registry
+	URL string
+	// Space is the name of the space on the remote registry (empty for the
+	// default space)
+	Space string
+	// Filter can be select (whitelist) or reject (blacklist), like for the
+	// virtual spaces
+	Filter string
+	// Slugs is the list of webapp/connector slugs to filter
+	Slugs []string
+	// PublicURL is the URL of this registry, used for the URL of the mirrored
+	// tarballs. When empty, the tarballs URL point to the remote registry.
+	PublicURL string
+	// Interval is the delay between two synchronizations when the registry
+	// is served (0 to synchronize only with the sync-mirror command)
+	Interval time.Duration
+}
+
+// AcceptApp returns true if the application should be mirrored.
+func (m Mirror) AcceptApp(slug string) bool {
+	return VirtualSpace{Filter: m.Filter, Slugs: m.Slugs}.AcceptApp(slug)
+}
+
+// Webhook is an URL where the registry sends the events of a space.
+type Webhook struct {
+	// URL is where the events are POSTed
+	URL string
+	// Secret is used to sign the body of the requests with HMAC-SHA256
+	Secret string
+	// Events is the list of the types of event to send (all if empty)
+	Events []string
+}
+
+// Accepts returns true if the events of the given type are sent to this
+// webhook.
+func (w Webhook) Accepts(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	return inList(eventType, w.Events)
+}
+
+// Webhooks returns the list of webhooks configured for the given space.
+func (c ConfigParameters) Webhooks(prefix Prefix) []Webhook {
+	return c.SpaceOptions[string(prefix)].Webhooks
+}
+
+// Mirror returns the mirror configuration of the given space, or nil if the
+// space is not a mirror.
+func (c ConfigParameters) Mirror(prefix Prefix) *Mirror {
+	return c.SpaceOptions[string(prefix)].Mirror
+}
+
+// MaxApplicationSize returns the maximal size in bytes of the tarball of an
+// application version for the given space.
+func (c ConfigParameters) MaxApplicationSize(prefix Prefix) int64 {
+	if opts, ok := c.SpaceOptions[string(prefix)]; ok && opts.MaxAppSize > 0 {
+		return opts.MaxAppSize
+	}
+	if c.MaxAppSize > 0 {
+		return c.MaxAppSize
+	}
+	return DefaultMaxAppSize
 }
 
 // CleanParameters regroups the parameters for cleaning the old versions.
@@ -36,6 +36,9 @@ type VirtualStorage interface {
 	Create(prefix Prefix, name, contentType string, content io.Reader) error
 	// Get fetches a file from the given container/directory.
 	Get(prefix Prefix, name string) (*bytes.Buffer, map[string]string, error)
+	// Open opens a file from the given container/directory, to read it as
+	// a stream. The caller must close it.
+	Open(prefix Prefix, name string) (*Object, error)
 	// Remove deletes a file from the given container/directory.
 	Remove(prefix Prefix, name string) error
 	// Walk is a function to iterate on all object names of a given
@@ -49,3 +52,18 @@ type VirtualStorage interface {
 // WalkFn is a function defined by the caller to iterate through all object
 // names with Walk.
 type WalkFn func(name, contentType string) error
+
+// File is the content of a file opened from the storage. It is read as a
+// stream, and Seek can be used to read only a range of the file.
+type File interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// Object is a file opened from the storage, with its metadata.
+type Object struct {
+	File
+	Size        int64
+	ContentType string
+	Etag        string
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"strings"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/metrics"
+)
+
+// instrumentedCache is a cache that counts the hits and misses of the
+// lookups in another cache.
+type instrumentedCache struct {
+	base.Cache
+	name string
+}
+
+// WithMetrics returns a cache that counts the hits and misses of the lookups
+// in the given cache, with the given name as label.
+func WithMetrics(name string, c base.Cache) base.Cache {
+	return &instrumentedCache{Cache: c, name: name}
+}
+
+func (c *instrumentedCache) Get(key base.Key) (base.Value, bool) {
+	value, ok := c.Cache.Get(key)
+	c.count(key, ok)
+	return value, ok
+}
+
+func (c *instrumentedCache) MGet(keys []base.Key) []interface{} {
+	values := c.Cache.MGet(keys)
+	for i, key := range keys {
+		c.count(key, i < len(values) && values[i] != nil)
+	}
+	return values
+}
+
+func (c *instrumentedCache) count(key base.Key, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metrics.CacheRequests.Inc(c.name, spaceOfKey(key), result)
+}
+
+// spaceOfKey returns the space, or virtual space, of a key built with
+// base.NewKey.
+func spaceOfKey(key base.Key) string {
+	space := strings.SplitN(key.String(), "/", 2)[0]
+	if space == "" {
+		return base.DefaultSpacePrefix.String()
+	}
+	return space
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/config"
 	"github.com/cozy/cozy-apps-registry/registry"
@@ -122,6 +123,11 @@ var modifyAppCmd = &cobra.Command{
 			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
 		}
 
+		before, err := registry.FindApp(nil, space, args[0], registry.Stable)
+		if err != nil {
+			return err
+		}
+
 		var opts registry.AppOptions
 		if appDUCFlag != "" {
 			opts.DataUsageCommitment = &appDUCFlag
@@ -133,6 +139,14 @@ var modifyAppCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.AppModified,
+			Space:  space.GetPrefix().String(),
+			Slug:   app.Slug,
+			Before: before.Properties(),
+			After:  app.Properties(),
+		})
 
 		b, err := json.MarshalIndent(app, "", "  ")
 		if err != nil {
@@ -157,7 +171,62 @@ var rmAppCmd = &cobra.Command{
 			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
 		}
 
-		return registry.RemoveAppFromSpace(space, args[0])
+		if err = registry.RemoveAppFromSpace(space, args[0]); err != nil {
+			return err
+		}
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.AppRemoved,
+			Space:  space.GetPrefix().String(),
+			Slug:   args[0],
+		})
+		return nil
+	},
+}
+
+var transferAppCmd = &cobra.Command{
+	Use:   "transfer-app [slug] [new-editor]",
+	Short: `Transfer an application and its versions to another editor`,
+	Long: `Transfer an application and its versions to another editor. The tokens of
+the previous editor for this application are revoked, and the transfer is
+recorded in the review history of the application.`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) != 2 {
+			return cmd.Help()
+		}
+
+		space, ok := space.GetSpace(appSpaceFlag)
+		if !ok {
+			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+		}
+
+		editor, err := auth.Editors.GetEditor(args[1])
+		if err != nil {
+			return err
+		}
+		before, err := registry.FindApp(nil, space, args[0], registry.Stable)
+		if err != nil {
+			return err
+		}
+
+		actor := audit.CLIActor()
+		app, err := registry.TransferApp(space, args[0], editor, actor)
+		if err != nil {
+			return err
+		}
+		audit.Record(&audit.Entry{
+			Actor:  actor,
+			Action: audit.AppTransferred,
+			Space:  space.GetPrefix().String(),
+			Slug:   app.Slug,
+			Editor: app.Editor,
+			Before: map[string]string{"editor": before.Editor},
+			After:  map[string]string{"editor": app.Editor},
+		})
+
+		fmt.Printf("Application %q transferred from %q to %q\n", app.Slug, before.Editor, app.Editor)
+		return nil
 	},
 }
 
@@ -239,9 +308,21 @@ var maintenanceActivateAppCmd = &cobra.Command{
 			Messages:               messages,
 		}
 		if space == nil {
-			return registry.ActivateMaintenanceVirtualSpace(appSpaceFlag, args[0], opts)
+			err = registry.ActivateMaintenanceVirtualSpace(appSpaceFlag, args[0], opts)
+		} else {
+			err = registry.ActivateMaintenanceApp(space, args[0], opts)
+		}
+		if err != nil {
+			return err
 		}
-		return registry.ActivateMaintenanceApp(space, args[0], opts)
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.MaintenanceActivated,
+			Space:  auditSpaceName(space),
+			Slug:   args[0],
+			After:  opts,
+		})
+		return nil
 	},
 }
 
@@ -259,8 +340,50 @@ var maintenanceDeactivateAppCmd = &cobra.Command{
 		}
 
 		if space == nil {
-			return registry.DeactivateMaintenanceVirtualSpace(appSpaceFlag, args[0])
+			err = registry.DeactivateMaintenanceVirtualSpace(appSpaceFlag, args[0])
+		} else {
+			err = registry.DeactivateMaintenanceApp(space, args[0])
+		}
+		if err != nil {
+			return err
+		}
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.MaintenanceDeactivated,
+			Space:  auditSpaceName(space),
+			Slug:   args[0],
+		})
+		return nil
+	},
+}
+
+var refreshAppsCmd = &cobra.Command{
+	Use:   "refresh-apps",
+	Short: `Update the filterable fields of the applications of a space`,
+	Long: `Copy again the categories, tags, locales, permissions and label from the
+manifest of the latest version to the document of each application of a space.
+It is needed for the applications published before these filters existed.`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, ok := space.GetSpace(appSpaceFlag)
+		if !ok {
+			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+		}
+		n, err := registry.RefreshAppsFacets(s)
+		if err != nil {
+			return err
 		}
-		return registry.DeactivateMaintenanceApp(space, args[0])
+		fmt.Printf("%d applications updated\n", n)
+		return nil
 	},
 }
+
+// auditSpaceName returns the name of the space for the audit log: the prefix
+// of the space, or the name of the virtual space given with the flag when the
+// space is nil.
+func auditSpaceName(s *space.Space) string {
+	if s == nil {
+		return appSpaceFlag
+	}
+	return s.GetPrefix().String()
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/spf13/cobra"
+)
+
+var auditActorFlag string
+var auditActionFlag string
+var auditSinceFlag string
+var auditUntilFlag string
+
+var auditLogCmd = &cobra.Command{
+	Use:     "audit-log",
+	Short:   `List the last administrative actions, the most recent first`,
+	PreRunE: prepareRegistry,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filters := audit.Filters{
+			Actor:  auditActorFlag,
+			Action: auditActionFlag,
+			Space:  appSpaceFlag,
+			Slug:   appNameFlag,
+			Limit:  limitFlag,
+		}
+		var err error
+		if auditSinceFlag != "" {
+			if filters.Since, err = time.Parse(time.RFC3339, auditSinceFlag); err != nil {
+				return fmt.Errorf("Invalid date %q: %w", auditSinceFlag, err)
+			}
+		}
+		if auditUntilFlag != "" {
+			if filters.Until, err = time.Parse(time.RFC3339, auditUntilFlag); err != nil {
+				return fmt.Errorf("Invalid date %q: %w", auditUntilFlag, err)
+			}
+		}
+
+		entries, err := audit.List(filters)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATE\tACTOR\tACTION\tSPACE\tAPP\tVERSION\tEDITOR\tBEFORE\tAFTER")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.CreatedAt.Local().Format("2006-01-02 15:04:05"), e.Actor, e.Action,
+				e.Space, e.Slug, e.Version, e.Editor, formatAuditValue(e.Before),
+				formatAuditValue(e.After))
+		}
+		return w.Flush()
+	},
+}
+
+func formatAuditValue(value interface{}) string {
+	if value == nil {
+		return "-"
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "?"
+	}
+	return string(b)
+}
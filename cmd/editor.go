@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/registry"
 	"github.com/spf13/cobra"
 )
 
@@ -46,7 +51,7 @@ var rmEditorCmd = &cobra.Command{
 	Use:     "rm-editor [editor]",
 	Aliases: []string{"delete-editor", "remove-editor"},
 	Short:   `Remove an editor from the registry though an interactive CLI`,
-	PreRunE: prepareRegistry,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		editor, _, err := fetchEditor(args)
 		if err != nil {
@@ -54,13 +59,88 @@ var rmEditorCmd = &cobra.Command{
 		}
 
 		fmt.Printf("Deleting editor %q...", editor.Name())
-		err = auth.Editors.DeleteEditor(editor)
+		err = registry.RemoveEditorLogo(editor)
+		if err == nil {
+			err = auth.Editors.DeleteEditor(editor)
+		}
 		if err != nil {
 			fmt.Println("failed")
 			return err
 		}
 
 		fmt.Println("ok")
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.EditorRemoved,
+			Editor: editor.Name(),
+		})
+		return nil
+	},
+}
+
+var modifyEditorCmd = &cobra.Command{
+	Use:   "modify-editor [editor]",
+	Short: `Modify the profile of an editor`,
+	Long: `Modify the profile of an editor, shown in the store next to its
+applications. Only the given flags are changed, and an empty value removes the
+field.`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		editor, _, err := fetchEditor(args)
+		if err != nil {
+			return err
+		}
+
+		var opts auth.ProfileOptions
+		flags := cmd.Flags()
+		if flags.Changed("display-name") {
+			opts.DisplayName = &editorDisplayNameFlag
+		}
+		if flags.Changed("website") {
+			opts.Website = &editorWebsiteFlag
+		}
+		if flags.Changed("support-email") {
+			opts.SupportEmail = &editorSupportEmailFlag
+		}
+		if flags.Changed("description") {
+			opts.Description = &editorDescriptionFlag
+		}
+
+		before := editor.Profile()
+		if err = auth.Editors.ModifyProfile(editor, opts); err != nil {
+			return err
+		}
+		if flags.Changed("verified") {
+			if err = auth.Editors.SetVerified(editor, editorVerifiedFlag); err != nil {
+				return err
+			}
+		}
+		if flags.Changed("logo") {
+			if editorLogoFlag == "" {
+				err = registry.RemoveEditorLogo(editor)
+			} else {
+				var content []byte
+				if content, err = ioutil.ReadFile(editorLogoFlag); err == nil {
+					err = registry.SetEditorLogo(editor, filepath.Base(editorLogoFlag), content)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.EditorModified,
+			Editor: editor.Name(),
+			Before: before,
+			After:  editor.Profile(),
+		})
+
+		b, err := json.MarshalIndent(editor, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
 		return nil
 	},
 }
@@ -82,6 +162,92 @@ var lsEditorsCmd = &cobra.Command{
 	},
 }
 
+var addEditorKeyCmd = &cobra.Command{
+	Use:     "add-editor-key [editor] [key-file]",
+	Aliases: []string{"add-public-key"},
+	Short:   `Register an Ed25519 public key used to verify the signatures of the editor versions`,
+	Long: `Register an Ed25519 public key for an editor. The key file can be in PEM
+(like the output of "openssl pkey -pubout") or contain the base64 encoding of
+the raw key. Once an editor has a public key, all its new versions must be
+signed.`,
+	PreRunE: prepareRegistry,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		editor, rest, err := fetchEditor(args)
+		if err != nil {
+			return err
+		}
+
+		var content []byte
+		if len(rest) > 0 && rest[0] != "-" {
+			content, err = ioutil.ReadFile(rest[0])
+		} else {
+			content, err = ioutil.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			return err
+		}
+		key, err := auth.ParsePublicKey(string(content))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Adding public key %s to editor %q...", auth.PublicKeyFingerprint(key), editor.Name())
+		if err = auth.Editors.AddPublicKey(editor, key); err != nil {
+			fmt.Println("failed")
+			return err
+		}
+
+		fmt.Println("ok")
+		return nil
+	},
+}
+
+var rmEditorKeyCmd = &cobra.Command{
+	Use:     "rm-editor-key [editor] [fingerprint]",
+	Aliases: []string{"remove-editor-key", "rm-public-key"},
+	Short:   `Remove a public key of an editor`,
+	PreRunE: prepareRegistry,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		editor, rest, err := fetchEditor(args)
+		if err != nil {
+			return err
+		}
+
+		var fingerprint string
+		if len(rest) > 0 {
+			fingerprint = rest[0]
+		} else {
+			fingerprint = prompt("Key fingerprint:")
+		}
+
+		fmt.Printf("Removing public key %s from editor %q...", fingerprint, editor.Name())
+		if err = auth.Editors.RemovePublicKey(editor, fingerprint); err != nil {
+			fmt.Println("failed")
+			return err
+		}
+
+		fmt.Println("ok")
+		return nil
+	},
+}
+
+var lsEditorKeysCmd = &cobra.Command{
+	Use:     "ls-editor-keys [editor]",
+	Aliases: []string{"list-editor-keys", "ls-public-keys"},
+	Short:   `List the public keys of an editor`,
+	PreRunE: prepareRegistry,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		editor, _, err := fetchEditor(args)
+		if err != nil {
+			return err
+		}
+		for _, key := range editor.PublicKeys() {
+			fmt.Printf("%s\t%s\n", auth.PublicKeyFingerprint(key), auth.EncodePublicKey(key))
+		}
+		return nil
+	},
+}
+
 func getEditorName(args []string) (editorName string, rest []string, err error) {
 	if len(args) > 0 {
 		editorName, rest = args[0], args[1:]
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/spf13/cobra"
+)
+
+var fsckRepairFlag bool
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: `Check the consistency between the databases and the storage`,
+	Long: `Check the consistency between the versions of the spaces, the global assets
+database and the files in the storage. It reports the versions whose tarball
+is missing, the references to missing assets, the assets not used anymore, and
+the files in the storage not used by any version or asset.
+
+With --repair, the orphan files and assets are deleted, and the missing
+tarballs are fetched again from the URL where their version has been
+published. It should be used when no version is being published.`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		problems, err := registry.Fsck(registry.FsckOptions{
+			Repair: fsckRepairFlag,
+			Problems: func(p *registry.FsckProblem) {
+				fmt.Println(p)
+			},
+		})
+		if err != nil {
+			return err
+		}
+		remaining := 0
+		for _, p := range problems {
+			if !p.Repaired {
+				remaining++
+			}
+		}
+		fmt.Printf("%d problems found, %d repaired\n", len(problems), len(problems)-remaining)
+		if remaining > 0 {
+			return fmt.Errorf("%d problems have not been repaired", remaining)
+		}
+		return nil
+	},
+}
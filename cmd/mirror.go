@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/mirror"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/spf13/cobra"
+)
+
+var syncMirrorCmd = &cobra.Command{
+	Use:   "sync-mirror [space]",
+	Short: `Synchronize a mirror space with its remote registry`,
+	Long: `Fetch the applications and versions of the remote registry configured
+in the space_options of the space, and create the missing ones locally. Without
+argument, all the mirror spaces are synchronized.`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return cmd.Usage()
+		}
+		names := space.GetSpacesNames()
+		if len(args) == 1 {
+			names = []string{args[0]}
+		}
+
+		failed := false
+		for _, name := range names {
+			s, ok := space.GetSpace(name)
+			if !ok {
+				return fmt.Errorf("cannot find space %q", name)
+			}
+			m := base.Config.Mirror(s.GetPrefix())
+			if m == nil {
+				if len(args) == 1 {
+					return fmt.Errorf("space %q is not a mirror", name)
+				}
+				continue
+			}
+
+			fmt.Printf("Synchronizing space %q from %s...\n", s.GetPrefix(), m.URL)
+			report, err := mirror.Sync(s, m)
+			if err != nil {
+				return err
+			}
+			for _, slug := range report.AppsCreated {
+				fmt.Printf("  app %s created\n", slug)
+			}
+			for _, version := range report.VersionsCreated {
+				fmt.Printf("  version %s created\n", version)
+			}
+			for _, err := range report.Errors {
+				fmt.Printf("  error: %s\n", err)
+				failed = true
+			}
+			fmt.Printf("  %d versions already present\n", report.Skipped)
+		}
+		if failed {
+			return fmt.Errorf("some applications could not be synchronized")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/spf13/cobra"
+)
+
+var recheckFlag bool
+
+var reviewPendingCmd = &cobra.Command{
+	Use:     "review-pending [slug]",
+	Short:   `Show the automated checks of the pending versions`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return cmd.Usage()
+		}
+		s, ok := space.GetSpace(appSpaceFlag)
+		if !ok {
+			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+		}
+
+		var versions []*registry.Version
+		var err error
+		if len(args) == 1 {
+			versions, err = registry.GetAppPendingVersions(s, args[0])
+		} else {
+			versions, err = registry.GetPendingVersions(s)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, ver := range versions {
+			if recheckFlag {
+				if err = registry.RecheckPendingVersion(s, ver); err != nil {
+					return fmt.Errorf("%s@%s: %w", ver.Slug, ver.Version, err)
+				}
+			}
+			fmt.Printf("%s@%s (%s)", ver.Slug, ver.Version, ver.State())
+			if ver.Checks == nil {
+				fmt.Println(": not checked, use --recheck")
+				continue
+			}
+			if ver.Checks.ComparedTo != "" {
+				fmt.Printf(" compared to %s", ver.Checks.ComparedTo)
+			} else {
+				fmt.Printf(" without previous stable version")
+			}
+			if len(ver.Checks.Warnings) == 0 {
+				fmt.Println(": no warning")
+				continue
+			}
+			fmt.Println(":")
+			for _, w := range ver.Checks.Warnings {
+				fmt.Printf("  - [%s] %s\n", w.Check, w.Message)
+			}
+		}
+		return nil
+	},
+}
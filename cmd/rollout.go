@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/spf13/cobra"
+)
+
+var rolloutStartFlag string
+
+var rolloutCmd = &cobra.Command{
+	Use:   "rollout <cmd>",
+	Short: `Manage the staged rollouts of the stable versions`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Help()
+	},
+}
+
+var rolloutAdvanceCmd = &cobra.Command{
+	Use:     "advance <slug> <version> <percentage>",
+	Short:   `Serve a stable version to the given percentage of the instances`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 3 {
+			return cmd.Help()
+		}
+		percentage, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("Invalid percentage %q: %w", args[2], err)
+		}
+		var startAt time.Time
+		if rolloutStartFlag != "" {
+			startAt, err = time.Parse(time.RFC3339, rolloutStartFlag)
+			if err != nil {
+				return fmt.Errorf("Invalid start date %q: %w", rolloutStartFlag, err)
+			}
+		}
+		return runRollout(args[0], args[1], func(s *space.Space) (*registry.Version, error) {
+			return registry.AdvanceRollout(s, args[0], args[1], percentage, startAt)
+		})
+	},
+}
+
+var rolloutPauseCmd = &cobra.Command{
+	Use:     "pause <slug> <version>",
+	Short:   `Pause the rollout of a stable version at its current percentage`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Help()
+		}
+		return runRollout(args[0], args[1], func(s *space.Space) (*registry.Version, error) {
+			return registry.PauseRollout(s, args[0], args[1])
+		})
+	},
+}
+
+var rolloutResumeCmd = &cobra.Command{
+	Use:     "resume <slug> <version>",
+	Short:   `Resume a paused rollout`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Help()
+		}
+		return runRollout(args[0], args[1], func(s *space.Space) (*registry.Version, error) {
+			return registry.ResumeRollout(s, args[0], args[1])
+		})
+	},
+}
+
+var rolloutRollbackCmd = &cobra.Command{
+	Use:     "rollback <slug> <version>",
+	Short:   `Stop serving a stable version, the instances get the previous one again`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Help()
+		}
+		return runRollout(args[0], args[1], func(s *space.Space) (*registry.Version, error) {
+			return registry.RollbackRollout(s, args[0], args[1])
+		})
+	},
+}
+
+func runRollout(slug, version string, update func(*space.Space) (*registry.Version, error)) error {
+	s, ok := space.GetSpace(appSpaceFlag)
+	if !ok {
+		return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+	}
+	ver, err := update(s)
+	if err != nil {
+		return err
+	}
+	recordVersionAction(audit.RolloutUpdated, s, slug, version, ver.Rollout)
+	switch r := ver.Rollout; {
+	case r == nil:
+		fmt.Printf("%s@%s is served to all the instances\n", slug, version)
+	case r.RolledBack:
+		fmt.Printf("%s@%s has been rolled back\n", slug, version)
+	default:
+		fmt.Printf("%s@%s is served to %d%% of the instances from %s", slug, version,
+			r.Percentage, r.StartedAt.Format(time.RFC3339))
+		if r.Paused {
+			fmt.Print(" (paused)")
+		}
+		fmt.Println()
+	}
+	return nil
+}
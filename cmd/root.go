@@ -15,7 +15,12 @@ import (
 	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/config"
+	"github.com/cozy/cozy-apps-registry/mirror"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/schema"
+	"github.com/cozy/cozy-apps-registry/storage"
 	"github.com/cozy/cozy-apps-registry/web"
+	"github.com/cozy/cozy-apps-registry/webhook"
 	"github.com/howeyc/gopass"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -26,6 +31,10 @@ const envSessionPass = "REGISTRY_SESSION_PASS"
 var cfgFileFlag string
 var tokenMaxAgeFlag string
 var tokenMasterFlag bool
+var tokenDescriptionFlag string
+var tokenSpacesFlag []string
+var tokenChannelsFlag []string
+var tokenOperationsFlag []string
 var passphraseFlag *bool
 var appEditorFlag string
 var appTypeFlag string
@@ -39,10 +48,17 @@ var durationFlag int
 var forceFlag bool
 var noDryRunFlag bool
 var editorAutoPublicationFlag bool
+var editorDisplayNameFlag string
+var editorWebsiteFlag string
+var editorSupportEmailFlag string
+var editorDescriptionFlag string
+var editorLogoFlag string
+var editorVerifiedFlag bool
 var importDropFlag bool
 var infraMaintenanceFlag bool
 var shortMaintenanceFlag bool
 var disallowManualExecFlag bool
+var limitFlag int
 
 // Root returns the main command to execute, with all the subcommands and flags
 // ready to be used.
@@ -85,33 +101,61 @@ func Root() *cobra.Command {
 	rootCmd.AddCommand(genTokenCmd)
 	rootCmd.AddCommand(verifyTokenCmd)
 	rootCmd.AddCommand(revokeTokensCmd)
+	rootCmd.AddCommand(lsTokensCmd)
+	rootCmd.AddCommand(revokeTokenCmd)
 	rootCmd.AddCommand(genSessionSecret)
 	rootCmd.AddCommand(addEditorCmd)
 	rootCmd.AddCommand(rmEditorCmd)
+	rootCmd.AddCommand(modifyEditorCmd)
 	rootCmd.AddCommand(lsEditorsCmd)
+	rootCmd.AddCommand(addEditorKeyCmd)
+	rootCmd.AddCommand(rmEditorKeyCmd)
+	rootCmd.AddCommand(lsEditorKeysCmd)
 	rootCmd.AddCommand(lsAppsCmd)
 	rootCmd.AddCommand(addAppCmd)
 	rootCmd.AddCommand(modifyAppCmd)
 	rootCmd.AddCommand(rmAppCmd)
+	rootCmd.AddCommand(transferAppCmd)
+	rootCmd.AddCommand(refreshAppsCmd)
 	rootCmd.AddCommand(overwriteAppNameCmd)
 	rootCmd.AddCommand(overwriteAppIconCmd)
 	rootCmd.AddCommand(maintenanceCmd)
 	rootCmd.AddCommand(rmAppVersionCmd)
+	rootCmd.AddCommand(yankVersionCmd)
+	rootCmd.AddCommand(unyankVersionCmd)
+	rootCmd.AddCommand(promoteVersionCmd)
 	rootCmd.AddCommand(rmSpaceCmd)
 	maintenanceCmd.AddCommand(maintenanceActivateAppCmd)
 	maintenanceCmd.AddCommand(maintenanceDeactivateAppCmd)
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(migrateStorageCmd)
+	rootCmd.AddCommand(fsckCmd)
 	rootCmd.AddCommand(oldVersionsCmd)
 	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(webhookDeliveriesCmd)
+	rootCmd.AddCommand(syncMirrorCmd)
+	rootCmd.AddCommand(validateTarballCmd)
+	rootCmd.AddCommand(reviewPendingCmd)
+	rootCmd.AddCommand(rolloutCmd)
+	rolloutCmd.AddCommand(rolloutAdvanceCmd)
+	rolloutCmd.AddCommand(rolloutPauseCmd)
+	rolloutCmd.AddCommand(rolloutResumeCmd)
+	rolloutCmd.AddCommand(rolloutRollbackCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(auditLogCmd)
 
 	passphraseFlag = genSessionSecret.Flags().Bool("passphrase", false, "enforce or dismiss the session secret encryption")
 
 	genTokenCmd.Flags().StringVar(&tokenMaxAgeFlag, "max-age", "", "validity duration of the token")
 
 	genTokenCmd.Flags().BoolVar(&tokenMasterFlag, "master", false, "generate a master token to create applications")
+	genTokenCmd.Flags().StringVar(&tokenDescriptionFlag, "description", "", "description of the token, to recognize it in ls-tokens")
 	genTokenCmd.Flags().StringVar(&appNameFlag, "app", "", "application name allowed for the generated token")
 	genTokenCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	genTokenCmd.Flags().StringSliceVar(&tokenSpacesFlag, "allowed-spaces", nil, "restrict the token to these spaces (__default__ for the default space)")
+	genTokenCmd.Flags().StringSliceVar(&tokenChannelsFlag, "allowed-channels", nil, "restrict the token to publish on these channels (stable, beta, dev)")
+	genTokenCmd.Flags().StringSliceVar(&tokenOperationsFlag, "allowed-operations", nil, "operations allowed for the token (publish, maintenance, patch, stats), publish only by default")
 	revokeTokensCmd.Flags().BoolVar(&tokenMasterFlag, "master", false, "revoke a master tokens")
 	verifyTokenCmd.Flags().BoolVar(&tokenMasterFlag, "master", false, "verify a master tokens")
 	verifyTokenCmd.Flags().StringVar(&appNameFlag, "app", "", "application name allowed for the generated token")
@@ -129,10 +173,17 @@ func Root() *cobra.Command {
 		fmt.Printf("Error on marking type flag as required: %s", err)
 	}
 	lsAppsCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	refreshAppsCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the applications space")
 	rmAppCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	transferAppCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
 	overwriteAppNameCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
 	overwriteAppIconCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
 	rmAppVersionCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	yankVersionCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	yankVersionCmd.Flags().StringVar(&yankReasonFlag, "reason", "", "why the version should not be installed anymore")
+	unyankVersionCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	promoteVersionCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	promoteVersionCmd.Flags().StringVar(&promoteAsFlag, "as", "", "version number in the new channel (defaults to the version without its pre-release part for stable)")
 
 	oldVersionsCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
 	oldVersionsCmd.Flags().IntVar(&minorFlag, "minor", 2, "specify the maximum number of major versions to keep")
@@ -154,7 +205,41 @@ func Root() *cobra.Command {
 
 	addEditorCmd.Flags().BoolVar(&editorAutoPublicationFlag, "auto-publication", false, "activate auto-publication of version for this editor")
 
+	modifyEditorCmd.Flags().StringVar(&editorDisplayNameFlag, "display-name", "", "name of the editor shown in the store")
+	modifyEditorCmd.Flags().StringVar(&editorWebsiteFlag, "website", "", "URL of the website of the editor")
+	modifyEditorCmd.Flags().StringVar(&editorSupportEmailFlag, "support-email", "", "email address of the support of the editor")
+	modifyEditorCmd.Flags().StringVar(&editorDescriptionFlag, "description", "", "short description of the editor")
+	modifyEditorCmd.Flags().StringVar(&editorLogoFlag, "logo", "", "image file of the logo of the editor")
+	modifyEditorCmd.Flags().BoolVar(&editorVerifiedFlag, "verified", false, "mark the editor as verified by the registry")
+
 	importCmd.Flags().BoolVarP(&importDropFlag, "drop", "d", false, "drop couchdb database & swift container before import")
+	migrateStorageCmd.Flags().StringVar(&migrateFromFlag, "from", "", "configuration file of the storage to copy the files from")
+	migrateStorageCmd.Flags().StringVar(&migrateToFlag, "to", "", "configuration file of the storage to copy the files to")
+	migrateStorageCmd.Flags().IntVar(&migrateConcurrencyFlag, "concurrency", storage.DefaultMigrateConcurrency, "number of files copied at the same time")
+	fsckCmd.Flags().BoolVar(&fsckRepairFlag, "repair", false, "delete the orphans and fetch again the missing tarballs")
+
+	webhookDeliveriesCmd.Flags().StringVar(&appSpaceFlag, "space", "", "only show the deliveries for this space")
+	webhookDeliveriesCmd.Flags().IntVar(&limitFlag, "limit", 50, "maximal number of deliveries to show")
+
+	validateTarballCmd.Flags().IntVar(&schemaVersionFlag, "schema-version", schema.CurrentVersion, "version of the manifest schemas to use")
+
+	reviewPendingCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	reviewPendingCmd.Flags().BoolVar(&recheckFlag, "recheck", false, "run the checks again and save the new reports")
+
+	rolloutCmd.PersistentFlags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	rolloutAdvanceCmd.Flags().StringVar(&rolloutStartFlag, "start", "", "date from which the percentage is applied (RFC 3339)")
+
+	statsCmd.Flags().StringVar(&appSpaceFlag, "space", "", "specify the application space")
+	statsCmd.Flags().StringVar(&statsSinceFlag, "since", "", "first day of the period (YYYY-MM-DD), 30 days before the last one by default")
+	statsCmd.Flags().StringVar(&statsUntilFlag, "until", "", "last day of the period (YYYY-MM-DD), today by default")
+
+	auditLogCmd.Flags().StringVar(&auditActorFlag, "actor", "", "only show the actions of this actor")
+	auditLogCmd.Flags().StringVar(&auditActionFlag, "action", "", "only show this action (app.removed, version.yanked, etc.)")
+	auditLogCmd.Flags().StringVar(&appSpaceFlag, "space", "", "only show the actions on this space (__default__ for the default space)")
+	auditLogCmd.Flags().StringVar(&appNameFlag, "app", "", "only show the actions on this application")
+	auditLogCmd.Flags().StringVar(&auditSinceFlag, "since", "", "only show the actions from this date (RFC 3339)")
+	auditLogCmd.Flags().StringVar(&auditUntilFlag, "until", "", "only show the actions before this date (RFC 3339)")
+	auditLogCmd.Flags().IntVar(&limitFlag, "limit", 50, "maximal number of actions to show")
 
 	return rootCmd
 }
@@ -171,6 +256,10 @@ var rootCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return cmd.Help()
 	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		// Let the webhooks be notified of the changes made by the command
+		webhook.Wait()
+	},
 }
 
 var serveCmd = &cobra.Command{
@@ -186,6 +275,22 @@ var serveCmd = &cobra.Command{
 		go func() {
 			errc <- router.Start(address)
 		}()
+		mirror.Start()
+		registry.StartDownloadsFlush(base.Config.StatsFlushInterval, base.Config.StatsTotalsInterval)
+		auth.Editors.StartLastUsesFlush(0)
+		// Save the downloads counted and the last uses of the tokens since
+		// the last flush before exiting
+		defer func() {
+			if errf := registry.FlushDownloads(); errf != nil && err == nil {
+				err = errf
+			}
+			if errf := registry.FlushAppDownloads(); errf != nil && err == nil {
+				err = errf
+			}
+			if errf := auth.Editors.FlushLastUses(); errf != nil && err == nil {
+				err = errf
+			}
+		}()
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
 		select {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/config"
 	"github.com/cozy/cozy-apps-registry/registry"
 	"github.com/cozy/cozy-apps-registry/space"
@@ -44,6 +45,14 @@ var rmSpaceCmd = &cobra.Command{
 		}
 
 		// Removing the space
-		return registry.RemoveSpace(s)
+		if err := registry.RemoveSpace(s); err != nil {
+			return err
+		}
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.SpaceRemoved,
+			Space:  s.GetPrefix().String(),
+		})
+		return nil
 	},
 }
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/spf13/cobra"
+)
+
+var statsSinceFlag string
+var statsUntilFlag string
+
+var statsCmd = &cobra.Command{
+	Use:     "stats <slug>",
+	Short:   `Show the number of downloads of an application, by version and by day`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		s, ok := space.GetSpace(appSpaceFlag)
+		if !ok {
+			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+		}
+		if _, err := registry.FindApp(nil, s, args[0], registry.Stable); err != nil {
+			return err
+		}
+		stats, err := registry.GetDownloadStats(s, args[0], statsSinceFlag, statsUntilFlag)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: %d downloads from %s to %s\n", stats.Slug, stats.Total, stats.Since, stats.Until)
+		if stats.Total == 0 {
+			return nil
+		}
+		versions := make([]string, 0, len(stats.Versions))
+		for version := range stats.Versions {
+			versions = append(versions, version)
+		}
+		sort.Strings(versions)
+		fmt.Println("\nBy version:")
+		for _, version := range versions {
+			fmt.Printf("  %-20s %d\n", version, stats.Versions[version])
+		}
+		fmt.Println("\nBy day:")
+		for _, day := range stats.Days {
+			fmt.Printf("  %-20s %d\n", day.Day, day.Count)
+		}
+		return nil
+	},
+}
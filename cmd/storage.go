@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cozy/cozy-apps-registry/config"
+	"github.com/cozy/cozy-apps-registry/storage"
+	"github.com/spf13/cobra"
+)
+
+var migrateFromFlag string
+var migrateToFlag string
+var migrateConcurrencyFlag int
+
+var migrateStorageCmd = &cobra.Command{
+	Use:   "migrate-storage",
+	Short: `Copy the files of the registry from a storage to another`,
+	Long: `Copy the files of the registry (tarballs, assets) from a storage to another.
+
+The --from and --to flags are the paths to configuration files, where only the
+storage (fs, swift or s3) and the spaces are read. The files are listed in the
+spaces of the source configuration. The files already copied with the same
+content are skipped, so the command can be run again after an interruption.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if migrateFromFlag == "" || migrateToFlag == "" {
+			return errors.New("Both --from and --to must be given")
+		}
+		from, err := config.LoadStorage(migrateFromFlag)
+		if err != nil {
+			return err
+		}
+		to, err := config.LoadStorage(migrateToFlag)
+		if err != nil {
+			return err
+		}
+		stats, err := storage.Migrate(from.Storage, to.Storage, from.Prefixes, storage.MigrateOptions{
+			Concurrency: migrateConcurrencyFlag,
+			Progress:    os.Stdout,
+		})
+		if stats != nil {
+			fmt.Printf("%d files copied, %d files already migrated\n", stats.Copied, stats.Skipped)
+		}
+		return err
+	},
+}
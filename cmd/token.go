@@ -8,8 +8,10 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"text/tabwriter"
 	"time"
 
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/registry"
@@ -34,8 +36,9 @@ var genTokenCmd = &cobra.Command{
 		}
 
 		var token []byte
+		var info *auth.TokenInfo
 		if tokenMasterFlag {
-			token, err = editor.GenerateMasterToken(base.SessionSecret, maxAge)
+			token, info, err = editor.GenerateMasterToken(base.SessionSecret, maxAge)
 		} else if appNameFlag != "" {
 			space, ok := space.GetSpace(appSpaceFlag)
 			if !ok {
@@ -43,18 +46,26 @@ var genTokenCmd = &cobra.Command{
 			} else {
 				var app *registry.App
 				app, err = registry.FindApp(nil, space, appNameFlag, registry.Stable)
+				var claims *auth.Claims
 				if err == nil {
-					token, err = editor.GenerateEditorToken(base.SessionSecret, maxAge, app.Slug)
+					claims, err = auth.NewClaims(tokenSpacesFlag, tokenChannelsFlag, tokenOperationsFlag)
+				}
+				if err == nil {
+					token, info, err = editor.GenerateEditorToken(base.SessionSecret, maxAge, app.Slug, claims)
 				}
 			}
 		} else {
 			err = fmt.Errorf("Should use either --app flag or --master flag")
 		}
+		if err == nil {
+			err = auth.Editors.AddToken(editor, info, tokenDescriptionFlag)
+		}
 		if err != nil {
 			return fmt.Errorf("Could not generate editor token for %q: %s",
 				editor.Name(), err)
 		}
 
+		fmt.Fprintf(os.Stderr, "Token ID: %s\n", info.ID)
 		fmt.Println(base64.StdEncoding.EncodeToString(token))
 		return nil
 	},
@@ -125,6 +136,7 @@ var verifyTokenCmd = &cobra.Command{
 		}
 
 		var ok bool
+		var claims *auth.Claims
 		if tokenMasterFlag {
 			ok = editor.VerifyMasterToken(base.SessionSecret, token)
 		} else if appNameFlag == "" {
@@ -139,12 +151,15 @@ var verifyTokenCmd = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			ok = editor.VerifyEditorToken(base.SessionSecret, token, app.Slug)
+			claims, ok = editor.EditorTokenClaims(base.SessionSecret, token, app.Slug)
 		}
 		if !ok {
 			return fmt.Errorf("token is **not** valid")
 		}
 		fmt.Println("token is valid")
+		if !tokenMasterFlag {
+			fmt.Println(claims.String())
+		}
 		return nil
 	},
 }
@@ -172,6 +187,88 @@ var revokeTokensCmd = &cobra.Command{
 		} else {
 			err = auth.Editors.RevokeEditorTokens(editor)
 		}
-		return err
+		if err != nil {
+			return err
+		}
+		kind := "editor"
+		if tokenMasterFlag {
+			kind = "master"
+		}
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.TokensRevoked,
+			Editor: editor.Name(),
+			After:  map[string]string{"type": kind},
+		})
+		return nil
+	},
+}
+
+var lsTokensCmd = &cobra.Command{
+	Use:     "ls-tokens [editor]",
+	Aliases: []string{"list-tokens"},
+	Short:   `List the tokens that have been generated for the specified editor`,
+	PreRunE: prepareRegistry,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		editor, _, err := fetchEditor(args)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTYPE\tAPP\tCREATED\tEXPIRES\tLAST USE\tSTATUS\tDESCRIPTION")
+		for _, t := range editor.Tokens() {
+			kind := "editor"
+			if t.Master {
+				kind = "master"
+			}
+			status := "valid"
+			if t.RevokedAt != nil {
+				status = "revoked"
+			} else if t.IsExpired() {
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				t.ID, kind, t.App, formatTokenDate(&t.CreatedAt), formatTokenDate(t.ExpiresAt),
+				formatTokenDate(t.LastUsedAt), status, t.Description)
+		}
+		return w.Flush()
 	},
 }
+
+var revokeTokenCmd = &cobra.Command{
+	Use:     "revoke-token [editor] [id]",
+	Short:   `Revoke a single token of the specified editor, with its ID`,
+	PreRunE: prepareRegistry,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		editor, rest, err := fetchEditor(args)
+		if err != nil {
+			return err
+		}
+		var id string
+		if len(rest) > 0 {
+			id = rest[0]
+		} else {
+			id = prompt("Token ID:")
+		}
+		fmt.Printf("Revoking token %s of editor %q...", id, editor.Name())
+		if err = auth.Editors.RevokeToken(editor, id); err != nil {
+			fmt.Println("failed")
+			return err
+		}
+		fmt.Println("ok")
+		audit.Record(&audit.Entry{
+			Actor:  audit.CLIActor(),
+			Action: audit.TokenRevoked,
+			Editor: editor.Name(),
+			After:  map[string]string{"token": id},
+		})
+		return nil
+	},
+}
+
+func formatTokenDate(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
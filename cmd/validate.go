@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/schema"
+	"github.com/spf13/cobra"
+)
+
+var schemaVersionFlag int
+
+var validateTarballCmd = &cobra.Command{
+	Use:   "validate-tarball <file>",
+	Short: `Check the manifest of an application tarball before publishing it`,
+	Long: `Check the manifest of an application tarball with the same rules as the
+registry when a version is published: the editor, slug and version fields, and
+the JSON schema of the manifest.webapp or manifest.konnector.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// The tarball can be compressed or not
+		contentType := "application/x-tar"
+		if head, err := bufio.NewReader(f).Peek(2); err == nil && head[0] == 0x1f && head[1] == 0x8b {
+			contentType = "application/gzip"
+		}
+		if _, err = f.Seek(0, 0); err != nil {
+			return err
+		}
+		tarball, err := registry.ReadTarballVersion(f, contentType, args[0])
+		if err != nil {
+			return err
+		}
+
+		var problems []string
+		if _, err = tarball.CheckEditor(); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if _, err = tarball.CheckSlug(); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if _, err = tarball.CheckVersion(tarball.Manifest.Version); err != nil {
+			problems = append(problems, err.Error())
+		}
+		err = schema.ValidateManifestWithVersion(tarball.AppType, tarball.ManifestContent, schemaVersionFlag)
+		var schemaErr *schema.Error
+		if errors.As(err, &schemaErr) {
+			for _, v := range schemaErr.Violations {
+				problems = append(problems, v.String())
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if len(problems) > 0 {
+			fmt.Printf("The manifest.%s of %s is invalid:\n", tarball.AppType, args[0])
+			for _, p := range problems {
+				fmt.Printf("  - %s\n", p)
+			}
+			return fmt.Errorf("%d problem(s) found", len(problems))
+		}
+		fmt.Printf("The manifest.%s of %s is valid (schema v%d)\n",
+			tarball.AppType, args[0], schemaVersionFlag)
+		return nil
+	},
+}
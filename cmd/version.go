@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/registry"
 	"github.com/cozy/cozy-apps-registry/space"
@@ -56,6 +57,96 @@ var rmAppVersionCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		return ver.Delete(space)
+		if err = ver.Delete(space); err != nil {
+			return err
+		}
+		recordVersionAction(audit.VersionDeleted, space, slug, version, nil)
+		return nil
+	},
+}
+
+var yankReasonFlag string
+
+var yankVersionCmd = &cobra.Command{
+	Use:     "yank-version <slug> <version>",
+	Short:   `Yank an app version: it is not served anymore, but its tarball is kept`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) != 2 {
+			return cmd.Help()
+		}
+		space, ok := space.GetSpace(appSpaceFlag)
+		if !ok {
+			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+		}
+		ver, err := registry.YankVersion(space, args[0], args[1], yankReasonFlag)
+		if err != nil {
+			return err
+		}
+		recordVersionAction(audit.VersionYanked, space, args[0], args[1], ver.Yanked)
+		return nil
+	},
+}
+
+var unyankVersionCmd = &cobra.Command{
+	Use:     "unyank-version <slug> <version>",
+	Short:   `Make a yanked app version available again`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) != 2 {
+			return cmd.Help()
+		}
+		space, ok := space.GetSpace(appSpaceFlag)
+		if !ok {
+			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+		}
+		if _, err = registry.UnyankVersion(space, args[0], args[1]); err != nil {
+			return err
+		}
+		recordVersionAction(audit.VersionUnyanked, space, args[0], args[1], nil)
+		return nil
+	},
+}
+
+var promoteAsFlag string
+
+var promoteVersionCmd = &cobra.Command{
+	Use:     "promote-version <slug> <version> <channel>",
+	Short:   `Publish an app version in a more stable channel, with the same tarball`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) != 3 {
+			return cmd.Help()
+		}
+		space, ok := space.GetSpace(appSpaceFlag)
+		if !ok {
+			return fmt.Errorf("Space %q does not exist", appSpaceFlag)
+		}
+		channel, err := registry.StrToChannel(args[2])
+		if err != nil {
+			return err
+		}
+		ver, err := registry.PromoteVersion(space, args[0], args[1], channel, promoteAsFlag)
+		if err != nil {
+			return err
+		}
+		recordVersionAction(audit.VersionPromoted, space, args[0], args[1],
+			map[string]string{"channel": args[2], "version": ver.Version})
+		fmt.Printf("%s@%s has been published in the %s channel as %s\n",
+			ver.Slug, ver.PromotedFrom, args[2], ver.Version)
+		return nil
 	},
 }
+
+// recordVersionAction adds an action made on a version from the command line
+// to the audit log.
+func recordVersionAction(action string, s *space.Space, slug, version string, after interface{}) {
+	audit.Record(&audit.Entry{
+		Actor:   audit.CLIActor(),
+		Action:  action,
+		Space:   s.GetPrefix().String(),
+		Slug:    slug,
+		Version: version,
+		After:   after,
+	})
+}
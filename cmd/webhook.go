@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cozy/cozy-apps-registry/webhook"
+	"github.com/spf13/cobra"
+)
+
+var webhookDeliveriesCmd = &cobra.Command{
+	Use:     "webhook-deliveries",
+	Aliases: []string{"ls-webhook-deliveries"},
+	Short:   `List the last deliveries of events to the webhooks`,
+	PreRunE: compose(prepareRegistry, prepareSpaces),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		deliveries, err := webhook.ListDeliveries(appSpaceFlag, limitFlag)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATE\tEVENT\tSPACE\tAPP\tVERSION\tURL\tSTATUS\tATTEMPTS\tERROR")
+		for _, d := range deliveries {
+			status := "ok"
+			if !d.Success {
+				status = "failed"
+			}
+			if d.StatusCode != 0 {
+				status = fmt.Sprintf("%s (%d)", status, d.StatusCode)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				d.CreatedAt.Local().Format("2006-01-02 15:04:05"), d.Event, d.Space,
+				d.Slug, d.Version, d.URL, status, d.Attempts, d.Error)
+		}
+		return w.Flush()
+	},
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/spf13/viper"
 )
 
@@ -26,6 +27,9 @@ func SetDefaults() {
 	viper.SetDefault("conservation.major", 2)
 	viper.SetDefault("conservation.minor", 2)
 	viper.SetDefault("conservation.month", 2)
+	viper.SetDefault("max_app_size", base.DefaultMaxAppSize)
+	viper.SetDefault("stats.flush_interval", base.DefaultStatsFlushInterval)
+	viper.SetDefault("stats.totals_interval", base.DefaultStatsTotalsInterval)
 }
 
 // ReadFile reads the config file, parses it, and loads the values in viper.
@@ -37,7 +41,12 @@ func ReadFile(file, defaultFile string) error {
 			return nil
 		}
 	}
+	return readFileInto(viper.GetViper(), file)
+}
 
+// readFileInto reads the config file, parses it, and loads the values in the
+// given viper instance.
+func readFileInto(v *viper.Viper, file string) error {
 	parser := template.New(filepath.Base(file))
 	parser = parser.Option("missingkey=zero")
 	tmpl, err := parser.ParseFiles(file)
@@ -55,10 +64,10 @@ func ReadFile(file, defaultFile string) error {
 	}
 
 	if ext := filepath.Ext(file); len(ext) > 0 {
-		viper.SetConfigType(ext[1:])
+		v.SetConfigType(ext[1:])
 	}
 
-	if err = viper.ReadConfig(dest); err != nil {
+	if err = v.ReadConfig(dest); err != nil {
 		return fmt.Errorf("Failed to read cozy-apps-registry configuration %q: %w",
 			file, err)
 	}
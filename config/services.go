@@ -3,15 +3,22 @@ package config
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/cozy/cozy-apps-registry/asset"
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/cache"
+	"github.com/cozy/cozy-apps-registry/metrics"
 	"github.com/cozy/cozy-apps-registry/space"
 	"github.com/cozy/cozy-apps-registry/storage"
+	"github.com/cozy/cozy-apps-registry/webhook"
+	"github.com/go-kivik/couchdb/v3"
 	"github.com/go-kivik/couchdb/v3/chttp"
 	"github.com/go-kivik/kivik/v3"
 	"github.com/go-redis/redis/v7"
@@ -42,16 +49,93 @@ func SetupServices() error {
 		}
 	}
 
-	if dir := viper.GetString("fs"); dir != "" {
-		base.Storage = storage.NewFS(dir)
-	} else {
-		sc, err := initSwiftConnection()
+	store, err := newStorage(viper.GetViper())
+	if err != nil {
+		return err
+	}
+	base.Storage = storage.WithMetrics(store)
+	return nil
+}
+
+// newStorage returns the storage configured in the given viper instance: the
+// local file system, S3 or Swift.
+func newStorage(v *viper.Viper) (base.VirtualStorage, error) {
+	if dir := v.GetString("fs"); dir != "" {
+		return storage.NewFS(dir), nil
+	}
+	if endpoint := v.GetString("s3.endpoint"); endpoint != "" {
+		s3, err := storage.NewS3(storage.S3Options{
+			Endpoint:     endpoint,
+			Region:       v.GetString("s3.region"),
+			AccessKey:    v.GetString("s3.access_key"),
+			SecretKey:    v.GetString("s3.secret_key"),
+			Bucket:       v.GetString("s3.bucket"),
+			BucketPrefix: v.GetString("s3.bucket_prefix"),
+			PartSize:     v.GetInt64("s3.part_size"),
+		})
 		if err != nil {
-			return fmt.Errorf("Cannot access to swift: %s", err)
+			return nil, fmt.Errorf("Cannot configure S3: %s", err)
 		}
-		base.Storage = storage.NewSwift(sc)
+		return s3, nil
 	}
-	return nil
+	sc, err := initSwiftConnection(v)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot access to swift: %s", err)
+	}
+	return storage.NewSwift(sc), nil
+}
+
+// StorageConfig is the storage described by a configuration file, with the
+// prefixes used by the registry in it.
+type StorageConfig struct {
+	Storage  base.VirtualStorage
+	Prefixes []base.Prefix
+}
+
+// LoadStorage reads a configuration file, and connects to its storage. Only
+// the storage and the spaces are used from this file, which allows to work
+// with several storages at once (to migrate the files from one to the other
+// for example).
+func LoadStorage(file string) (*StorageConfig, error) {
+	v := viper.New()
+	if err := readFileInto(v, file); err != nil {
+		return nil, err
+	}
+	store, err := newStorage(v)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageConfig{
+		Storage:  store,
+		Prefixes: storagePrefixes(v),
+	}, nil
+}
+
+// storagePrefixes returns the prefixes of the assets, of the spaces, and of
+// the virtual spaces (for their overwritten tarballs).
+func storagePrefixes(v *viper.Viper) []base.Prefix {
+	prefixes := []base.Prefix{asset.AssetContainerName}
+	spaceNames := v.GetStringSlice("spaces")
+	if len(spaceNames) == 0 {
+		spaceNames = []string{""}
+	}
+	for _, name := range spaceNames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			prefixes = append(prefixes, base.DefaultSpacePrefix)
+		} else {
+			prefixes = append(prefixes, base.Prefix(name))
+		}
+	}
+	virtuals := make([]string, 0)
+	for name := range v.GetStringMap("virtual_spaces") {
+		virtuals = append(virtuals, name)
+	}
+	sort.Strings(virtuals)
+	for _, name := range virtuals {
+		prefixes = append(prefixes, base.Prefix(name))
+	}
+	return prefixes
 }
 
 // SetupForTests can be used to setup the services with in-memory implementations
@@ -93,6 +177,14 @@ func CleanupTests() error {
 	}
 
 	for _, s := range space.Spaces {
+		if err := base.DBClient.DestroyDB(ctx, s.StatsDB().Name()); err != nil {
+			fmt.Printf("Error while cleaning database %q: %s\n", s.StatsDB().Name(), err)
+		}
+
+		if err := base.DBClient.DestroyDB(ctx, s.ReviewsDB().Name()); err != nil {
+			fmt.Printf("Error while cleaning database %q: %s\n", s.ReviewsDB().Name(), err)
+		}
+
 		if err := base.DBClient.DestroyDB(ctx, s.PendingVersDB().Name()); err != nil {
 			fmt.Printf("Error while cleaning database %q: %s\n", s.PendingVersDB().Name(), err)
 		}
@@ -120,6 +212,14 @@ func CleanupTests() error {
 	}
 	base.GlobalAssetStore = nil
 
+	if err := base.DBClient.DestroyDB(ctx, webhook.DB().Name()); err != nil {
+		fmt.Printf("Error while cleaning database %q: %s\n", webhook.DB().Name(), err)
+	}
+
+	if err := base.DBClient.DestroyDB(ctx, audit.DB().Name()); err != nil {
+		fmt.Printf("Error while cleaning database %q: %s\n", audit.DB().Name(), err)
+	}
+
 	base.Storage = nil
 	return nil
 }
@@ -129,6 +229,10 @@ func configureParameters() error {
 	if err != nil {
 		return err
 	}
+	spaceOptions, err := getSpaceOptions()
+	if err != nil {
+		return err
+	}
 	base.Config = base.ConfigParameters{
 		CleanEnabled: viper.GetBool("conservation.enable_background_cleaning"),
 		CleanParameters: base.CleanParameters{
@@ -139,22 +243,27 @@ func configureParameters() error {
 		VirtualSpaces:  virtuals,
 		DomainSpaces:   viper.GetStringMapString("domain_space"),
 		TrustedDomains: viper.GetStringMapStringSlice("trusted_domains"),
+		MaxAppSize:     viper.GetInt64("max_app_size"),
+		SpaceOptions:   spaceOptions,
+
+		StatsFlushInterval:  viper.GetDuration("stats.flush_interval"),
+		StatsTotalsInterval: viper.GetDuration("stats.totals_interval"),
 	}
 
 	return nil
 }
 
-func initSwiftConnection() (*swift.Connection, error) {
-	endpointType := viper.GetString("swift.endpoint_type")
+func initSwiftConnection(v *viper.Viper) (*swift.Connection, error) {
+	endpointType := v.GetString("swift.endpoint_type")
 
 	// Create the swift connection
 	swiftConnection := swift.Connection{
-		UserName:     viper.GetString("swift.username"),
-		ApiKey:       viper.GetString("swift.api_key"), // Password
-		AuthUrl:      viper.GetString("swift.auth_url"),
+		UserName:     v.GetString("swift.username"),
+		ApiKey:       v.GetString("swift.api_key"), // Password
+		AuthUrl:      v.GetString("swift.auth_url"),
 		EndpointType: swift.EndpointType(endpointType),
-		Tenant:       viper.GetString("swift.tenant"), // Projet name
-		Domain:       viper.GetString("swift.domain"),
+		Tenant:       v.GetString("swift.tenant"), // Projet name
+		Domain:       v.GetString("swift.domain"),
 	}
 
 	// Authenticate to swift
@@ -227,14 +336,18 @@ func configureCache() error {
 	if err := res.Err(); err != nil {
 		return err
 	}
-	base.LatestVersionsCache = cache.NewRedisCache(base.DefaultCacheTTL, redisCacheVersionsLatest)
-	base.ListVersionsCache = cache.NewRedisCache(base.DefaultCacheTTL, redisCacheVersionsList)
+	base.LatestVersionsCache = cache.WithMetrics("latest_versions",
+		cache.NewRedisCache(base.DefaultCacheTTL, redisCacheVersionsLatest))
+	base.ListVersionsCache = cache.WithMetrics("list_versions",
+		cache.NewRedisCache(base.DefaultCacheTTL, redisCacheVersionsList))
 	return nil
 }
 
 func configureLRUCache() {
-	base.LatestVersionsCache = cache.NewLRUCache(256, base.DefaultCacheTTL)
-	base.ListVersionsCache = cache.NewLRUCache(256, base.DefaultCacheTTL)
+	base.LatestVersionsCache = cache.WithMetrics("latest_versions",
+		cache.NewLRUCache(256, base.DefaultCacheTTL))
+	base.ListVersionsCache = cache.WithMetrics("list_versions",
+		cache.NewLRUCache(256, base.DefaultCacheTTL))
 }
 
 func configureCouch(purge bool) error {
@@ -282,6 +395,10 @@ func configureCouch(purge bool) error {
 	vault := auth.NewCouchDBVault(editorsDB)
 	auth.Editors = auth.NewEditorRegistry(vault)
 
+	if err := audit.Prepare(); err != nil {
+		return fmt.Errorf("Cannot prepare the audit log database: %w", err)
+	}
+
 	base.GlobalAssetStore = asset.NewStore(client)
 	return nil
 }
@@ -298,6 +415,11 @@ func newClient(addr, user, pass string) (*kivik.Client, error) {
 		return nil, err
 	}
 
+	xport := couchdb.SetTransport(&couchTransport{http.DefaultTransport})
+	if err := client.Authenticate(context.Background(), xport); err != nil {
+		return nil, err
+	}
+
 	if pass != "" {
 		auth := &chttp.BasicAuth{
 			Username: user,
@@ -311,6 +433,28 @@ func newClient(addr, user, pass string) (*kivik.Client, error) {
 	return client, nil
 }
 
+// couchTransport is an HTTP transport that measures the latency of the
+// requests made to CouchDB.
+type couchTransport struct {
+	next http.RoundTripper
+}
+
+func (t *couchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	defer metrics.CouchDBDurations.ObserveSince(time.Now(), req.Method, couchDatabase(req.URL.Path))
+	return t.next.RoundTrip(req)
+}
+
+// couchDatabase returns the name of the database from the path of a request
+// to CouchDB, or the path itself for the requests on the server (like
+// /_all_dbs).
+func couchDatabase(path string) string {
+	name := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name
+}
+
 // PrepareSpaces makes sure that the CouchDB databases and Swift containers for
 // the spaces exist and have their index/views.
 func PrepareSpaces() error {
@@ -342,5 +486,9 @@ func PrepareSpaces() error {
 		}
 	}
 
+	if err := webhook.Prepare(); err != nil {
+		return fmt.Errorf("Cannot prepare the webhooks database: %w", err)
+	}
+
 	return base.GlobalAssetStore.Prepare()
 }
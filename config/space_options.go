@@ -0,0 +1,156 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/webhook"
+	"github.com/spf13/viper"
+)
+
+func getSpaceOptions() (map[string]base.SpaceOptions, error) {
+	options := make(map[string]base.SpaceOptions)
+	for name, value := range viper.GetStringMap("space_options") {
+		opts, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid options for the space %s", name)
+		}
+		var spaceOptions base.SpaceOptions
+		if size, ok := opts["max_app_size"]; ok {
+			maxSize, err := toSize(size)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid max_app_size for the space %s: %s", name, err)
+			}
+			spaceOptions.MaxAppSize = maxSize
+		}
+		if hooks, ok := opts["webhooks"]; ok {
+			webhooks, err := toWebhooks(hooks)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid webhooks for the space %s: %s", name, err)
+			}
+			spaceOptions.Webhooks = webhooks
+		}
+		if m, ok := opts["mirror"]; ok {
+			mirror, err := toMirror(m)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid mirror for the space %s: %s", name, err)
+			}
+			spaceOptions.Mirror = mirror
+		}
+		options[name] = spaceOptions
+	}
+	return options, nil
+}
+
+// toSize converts a size in bytes from the configuration file to an int64.
+func toSize(value interface{}) (int64, error) {
+	var size int64
+	switch v := value.(type) {
+	case int:
+		size = int64(v)
+	case int64:
+		size = v
+	case float64:
+		size = int64(v)
+	default:
+		return 0, errors.New("it should be a number of bytes")
+	}
+	if size < 0 {
+		return 0, errors.New("it should be a positive number of bytes")
+	}
+	return size, nil
+}
+
+func toWebhooks(value interface{}) ([]base.Webhook, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("it should be a list")
+	}
+	webhooks := make([]base.Webhook, 0, len(list))
+	for _, item := range list {
+		hook, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("a webhook should have an url and a secret")
+		}
+		u, _ := hook["url"].(string)
+		if !isHTTPURL(u) {
+			return nil, fmt.Errorf("invalid url %q", u)
+		}
+		secret, _ := hook["secret"].(string)
+		if secret == "" {
+			return nil, fmt.Errorf("missing secret for %s", u)
+		}
+		var events []string
+		if evs, ok := hook["events"].([]interface{}); ok {
+			for _, ev := range evs {
+				e, ok := ev.(string)
+				if !ok || e == "" {
+					return nil, fmt.Errorf("invalid event for %s", u)
+				}
+				if !webhook.IsValidEvent(e) {
+					return nil, fmt.Errorf("unknown event %q for %s: it should be one of %s",
+						e, u, strings.Join(webhook.Events, ", "))
+				}
+				events = append(events, e)
+			}
+		}
+		webhooks = append(webhooks, base.Webhook{
+			URL:    u,
+			Secret: secret,
+			Events: events,
+		})
+	}
+	return webhooks, nil
+}
+
+func toMirror(value interface{}) (*base.Mirror, error) {
+	opts, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("it should have at least an url")
+	}
+	u, _ := opts["url"].(string)
+	if !isHTTPURL(u) {
+		return nil, fmt.Errorf("invalid url %q", u)
+	}
+	mirror := &base.Mirror{URL: strings.TrimSuffix(u, "/")}
+	mirror.Space, _ = opts["space"].(string)
+	if publicURL, ok := opts["public_url"].(string); ok {
+		if !isHTTPURL(publicURL) {
+			return nil, fmt.Errorf("invalid public_url %q", publicURL)
+		}
+		mirror.PublicURL = strings.TrimSuffix(publicURL, "/")
+	}
+	if filter, ok := opts["filter"]; ok {
+		mirror.Filter, _ = filter.(string)
+		if mirror.Filter != "select" && mirror.Filter != "reject" {
+			return nil, errors.New("filter should be select or reject")
+		}
+	}
+	if slugs, ok := opts["slugs"].([]interface{}); ok {
+		for _, slug := range slugs {
+			s, ok := slug.(string)
+			if !ok {
+				return nil, errors.New("slugs should be a list of strings")
+			}
+			mirror.Slugs = append(mirror.Slugs, s)
+		}
+	}
+	if interval, ok := opts["interval"]; ok {
+		str, _ := interval.(string)
+		d, err := time.ParseDuration(str)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid interval %v", interval)
+		}
+		mirror.Interval = d
+	}
+	return mirror, nil
+}
+
+func isHTTPURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return u != "" && err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https")
+}
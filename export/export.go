@@ -2,12 +2,12 @@ package export
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"path"
 	"strings"
 	"time"
@@ -26,18 +26,22 @@ const documentSuffix = ".json"
 const contentTypeAttr = "COZY.content-type"
 
 func writeFile(writer *tar.Writer, path string, content []byte, attrs map[string]string) error {
+	return writeStream(writer, path, int64(len(content)), bytes.NewReader(content), attrs)
+}
+
+func writeStream(writer *tar.Writer, path string, size int64, content io.Reader, attrs map[string]string) error {
 	header := &tar.Header{
 		Typeflag:   tar.TypeReg,
 		Name:       path,
 		Mode:       0640,
 		ModTime:    time.Now(),
-		Size:       int64(len(content)),
+		Size:       size,
 		PAXRecords: attrs,
 	}
 	if err := writer.WriteHeader(header); err != nil {
 		return err
 	}
-	_, err := writer.Write(content)
+	_, err := io.Copy(writer, content)
 	return err
 }
 
@@ -128,7 +132,9 @@ func exportAllCouchDbs(writer *tar.Writer, prefix string) error {
 func exportSwiftContainer(writer *tar.Writer, prefix string, container base.Prefix) error {
 	fmt.Printf("    Exporting container %s\n", container)
 	dir := path.Join(prefix, container.String())
-	g, ctx := errgroup.WithContext(context.Background())
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, ctx := errgroup.WithContext(cancelCtx)
 
 	toRead := make(chan entry)
 	g.Go(func() error {
@@ -144,22 +150,23 @@ func exportSwiftContainer(writer *tar.Writer, prefix string, container base.Pref
 		})
 	})
 
-	// Start a fixed number of goroutines to read files.
-	toWrite := make(chan entry)
+	// Start a fixed number of goroutines to open the files. Their content is
+	// streamed to the tarball, so that only a few files are read at once.
+	toWrite := make(chan openedFile)
 	const numReaders = 10
 	for i := 0; i < numReaders; i++ {
 		g.Go(func() error {
 			for entry := range toRead {
-				reader, _, err := base.Storage.Get(container, entry.name)
+				obj, err := base.Storage.Open(container, entry.name)
 				if err != nil {
 					return err
 				}
-				content, err := ioutil.ReadAll(reader)
-				if err != nil {
-					return err
+				select {
+				case toWrite <- openedFile{entry, obj}:
+				case <-ctx.Done():
+					obj.Close()
+					return ctx.Err()
 				}
-				entry.content = content
-				toWrite <- entry
 			}
 			return nil
 		})
@@ -169,19 +176,32 @@ func exportSwiftContainer(writer *tar.Writer, prefix string, container base.Pref
 		close(toWrite)
 	}()
 
-	for entry := range toWrite {
-		file := path.Join(dir, entry.name)
-		metadata := map[string]string{
-			contentTypeAttr: entry.contentType,
-		}
-		if err := writeFile(writer, file, entry.content, metadata); err != nil {
-			return err
+	var err error
+	for opened := range toWrite {
+		if err == nil {
+			file := path.Join(dir, opened.name)
+			metadata := map[string]string{
+				contentTypeAttr: opened.contentType,
+			}
+			if err = writeStream(writer, file, opened.obj.Size, opened.obj, metadata); err != nil {
+				cancel()
+			}
 		}
+		// After an error, the remaining files are only closed
+		opened.obj.Close()
+	}
+	if err != nil {
+		return err
 	}
 
 	return g.Wait()
 }
 
+type openedFile struct {
+	entry
+	obj *base.Object
+}
+
 func swiftContainers() []base.Prefix {
 	containers := []base.Prefix{asset.AssetContainerName}
 	for _, space := range space.Spaces {
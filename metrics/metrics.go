@@ -0,0 +1,254 @@
+// Package metrics collects the metrics of the registry, and exposes them in
+// the text format of Prometheus. Only the counters and histograms are
+// implemented, as they are the only kinds of metrics used by the registry.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the buckets used for the histograms
+// of durations, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+var (
+	collectorsMu sync.Mutex
+	collectors   []collector
+)
+
+func register(c collector) {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+	collectors = append(collectors, c)
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+}
+
+// Write writes all the metrics in the Prometheus text format.
+func Write(w io.Writer) error {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	return buf.Flush()
+}
+
+// Handler returns an HTTP handler that serves the metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = Write(w)
+	})
+}
+
+// vec has the parts common to the counters and histograms: a name, a help
+// text, and the samples by label values.
+type vec struct {
+	metricName string
+	help       string
+	labels     []string
+	mu         sync.Mutex
+	keys       []string
+}
+
+func (v *vec) name() string { return v.metricName }
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d",
+			v.metricName, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// addKey keeps the keys sorted, so that the samples are always written in
+// the same order. It must be called with the mutex held.
+func (v *vec) addKey(key string) {
+	i := sort.SearchStrings(v.keys, key)
+	v.keys = append(v.keys, "")
+	copy(v.keys[i+1:], v.keys[i:])
+	v.keys[i] = key
+}
+
+func (v *vec) writeHeader(w *bufio.Writer, kind string) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(v.help)
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, kind)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// formatLabels returns the labels of a sample, like {space="foo",le="1"}.
+func (v *vec) formatLabels(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+`="`+labelValueEscaper.Replace(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+extra[i+1]+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec is a counter, with a value for each combination of its labels.
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounterVec creates and registers a counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		vec:    vec{metricName: name, help: help, labels: labels},
+		values: make(map[string]float64),
+	}
+	register(c)
+	return c
+}
+
+// Add adds the given value, that must not be negative, to the counter for
+// the label values.
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("metric %s: counters cannot decrease", c.metricName))
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[key]; !ok {
+		c.addKey(key)
+	}
+	c.values[key] += value
+}
+
+// Inc increments the counter for the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the value of the counter for the label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, key := range c.keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.formatLabels(key), formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram, with a distribution of the observed values
+// for each combination of its labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram. The buckets are the
+// upper bounds of the buckets, in increasing order.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec:     vec{metricName: name, help: help, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	register(h)
+	return h
+}
+
+// Observe adds a value to the histogram for the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+		h.addKey(key)
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+// ObserveSince adds the duration since start, in seconds, to the histogram.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of values observed for the label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hist, ok := h.values[key]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, key := range h.keys {
+		hist := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName,
+				h.formatLabels(key, "le", formatFloat(bound)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.formatLabels(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.formatLabels(key), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.formatLabels(key), hist.count)
+	}
+}
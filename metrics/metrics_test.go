@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "A counter\\for tests.", "space", "result")
+	c.Inc("foo", "hit")
+	c.Inc("foo", "hit")
+	c.Add(3, "bar", "miss")
+	c.Inc(`b"az`, "hit")
+
+	assert.EqualValues(t, 2, c.Value("foo", "hit"))
+	assert.EqualValues(t, 3, c.Value("bar", "miss"))
+	assert.EqualValues(t, 0, c.Value("foo", "miss"))
+	assert.Panics(t, func() { c.Inc("foo") })
+	assert.Panics(t, func() { c.Add(-1, "foo", "hit") })
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf))
+	expected := `# HELP test_counter_total A counter\\for tests.
+# TYPE test_counter_total counter
+test_counter_total{space="b\"az",result="hit"} 1
+test_counter_total{space="bar",result="miss"} 3
+test_counter_total{space="foo",result="hit"} 2
+`
+	assert.Contains(t, buf.String(), expected)
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "A histogram for tests.", []float64{0.1, 1}, "operation")
+	h.Observe(0.05, "get")
+	h.Observe(0.5, "get")
+	h.Observe(2, "get")
+
+	assert.EqualValues(t, 3, h.Count("get"))
+	assert.EqualValues(t, 0, h.Count("put"))
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf))
+	expected := `# HELP test_duration_seconds A histogram for tests.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{operation="get",le="0.1"} 1
+test_duration_seconds_bucket{operation="get",le="1"} 2
+test_duration_seconds_bucket{operation="get",le="+Inf"} 3
+test_duration_seconds_sum{operation="get"} 2.55
+test_duration_seconds_count{operation="get"} 3
+`
+	assert.Contains(t, buf.String(), expected)
+}
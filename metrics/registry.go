@@ -0,0 +1,68 @@
+package metrics
+
+// The metrics of the registry. The space label is the prefix of the space
+// (__default__ for the default space), and the virtual_space label is empty
+// when the request is not made on a virtual space.
+var (
+	// HTTPRequests counts the HTTP requests, by route and status code.
+	HTTPRequests = NewCounterVec(
+		"registry_http_requests_total",
+		"Number of HTTP requests, by route and status code.",
+		"method", "route", "code", "space", "virtual_space")
+	// HTTPDurations is the latency of the HTTP requests, by route.
+	HTTPDurations = NewHistogramVec(
+		"registry_http_request_duration_seconds",
+		"Latency of the HTTP requests, by route.",
+		DefaultBuckets,
+		"method", "route", "space", "virtual_space")
+
+	// CacheRequests counts the lookups in the caches, with hit or miss as
+	// result.
+	CacheRequests = NewCounterVec(
+		"registry_cache_requests_total",
+		"Number of lookups in the caches, by result (hit or miss).",
+		"cache", "space", "result")
+
+	// VersionEvents counts the events on the versions: published, approved,
+	// rejected, yanked, etc.
+	VersionEvents = NewCounterVec(
+		"registry_version_events_total",
+		"Number of events on the versions (published, approved, rejected, etc.).",
+		"space", "event")
+
+	// TarballDownloads counts the tarballs downloaded.
+	TarballDownloads = NewCounterVec(
+		"registry_tarball_downloads_total",
+		"Number of tarballs downloaded.",
+		"space", "virtual_space")
+	// TarballDownloadBytes counts the bytes sent for the tarballs.
+	TarballDownloadBytes = NewCounterVec(
+		"registry_tarball_download_bytes_total",
+		"Number of bytes sent for the tarballs.",
+		"space", "virtual_space")
+
+	// CouchDBDurations is the latency of the requests made to CouchDB.
+	CouchDBDurations = NewHistogramVec(
+		"registry_couchdb_request_duration_seconds",
+		"Latency of the requests made to CouchDB, by method and database.",
+		DefaultBuckets,
+		"method", "database")
+	// StorageDurations is the latency of the operations on the storage.
+	StorageDurations = NewHistogramVec(
+		"registry_storage_operation_duration_seconds",
+		"Latency of the operations on the storage, by operation and prefix.",
+		DefaultBuckets,
+		"operation", "prefix")
+
+	// CleanJobs counts the jobs that clean the old versions, by result
+	// (success or failure).
+	CleanJobs = NewCounterVec(
+		"registry_clean_jobs_total",
+		"Number of jobs cleaning the old versions, by result (success or failure).",
+		"space", "result")
+	// CleanedVersions counts the versions removed by the clean jobs.
+	CleanedVersions = NewCounterVec(
+		"registry_cleaned_versions_total",
+		"Number of versions removed by the clean jobs.",
+		"space")
+)
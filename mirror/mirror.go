@@ -0,0 +1,337 @@
+// Package mirror is used to copy the applications of a space of a remote
+// registry in a local space. The metadata are fetched from the public API of
+// the remote registry, and the versions are created locally like if they had
+// been published on this registry: the tarballs are downloaded and checked
+// with their sha256, and so are their assets (icon, screenshots).
+package mirror
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/go-kivik/kivik/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// pageSize is the number of applications fetched by request on the remote
+// registry.
+const pageSize = 200
+
+// maxAssetSize is the maximal size of an asset fetched on the remote registry.
+const maxAssetSize = 10 << 20
+
+var mirrorClient = http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// Report is the summary of a synchronization.
+type Report struct {
+	AppsCreated     []string
+	VersionsCreated []string
+	Skipped         int
+	Errors          []error
+}
+
+func (r *Report) fail(err error) {
+	r.Errors = append(r.Errors, err)
+}
+
+// Sync fetches the applications and versions of the remote registry that are
+// accepted by the mirror configuration, and creates the missing ones in the
+// local space. It can be called several times: the applications and versions
+// already present are skipped. An error for an application doesn't stop the
+// synchronization of the other ones, it is added to the report.
+func Sync(s *space.Space, m *base.Mirror) (*Report, error) {
+	apps, err := listRemoteApps(m)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	for _, remote := range apps {
+		if !m.AcceptApp(remote.Slug) {
+			continue
+		}
+		if err := syncApp(s, m, remote, report); err != nil {
+			report.fail(fmt.Errorf("%s: %w", remote.Slug, err))
+		}
+	}
+	return report, nil
+}
+
+func syncApp(s *space.Space, m *base.Mirror, remote *registry.App, report *Report) error {
+	editor, err := ensureEditor(m, remote.Editor)
+	if err != nil {
+		return err
+	}
+
+	app, err := registry.FindApp(nil, s, remote.Slug, registry.Dev)
+	if err == registry.ErrAppNotFound {
+		opts := &registry.AppOptions{
+			Slug:   remote.Slug,
+			Editor: remote.Editor,
+			Type:   remote.Type,
+		}
+		if remote.DataUsageCommitment != "" {
+			opts.DataUsageCommitment = &remote.DataUsageCommitment
+		}
+		if remote.DataUsageCommitmentBy != "" {
+			opts.DataUsageCommitmentBy = &remote.DataUsageCommitmentBy
+		}
+		app, err = registry.CreateApp(s, opts, editor)
+		if err == nil {
+			report.AppsCreated = append(report.AppsCreated, remote.Slug)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if remote.Versions == nil {
+		return nil
+	}
+	for _, version := range remote.Versions.GetAll() {
+		_, err := registry.FindPublishedVersion(s, remote.Slug, version)
+		if err == nil {
+			report.Skipped++
+			continue
+		}
+		if err != registry.ErrVersionNotFound {
+			return err
+		}
+		if err = syncVersion(s, m, app, editor, version); err != nil {
+			report.fail(fmt.Errorf("%s@%s: %w", remote.Slug, version, err))
+			continue
+		}
+		report.VersionsCreated = append(report.VersionsCreated, remote.Slug+"@"+version)
+	}
+	return nil
+}
+
+func syncVersion(s *space.Space, m *base.Mirror, app *registry.App, editor *auth.Editor, version string) error {
+	var remote registry.Version
+	if err := getJSON(remoteURL(m, app.Slug, version), &remote); err != nil {
+		return err
+	}
+	if remote.Sha256 == "" {
+		return fmt.Errorf("no sha256 for the version on %s", m.URL)
+	}
+
+	opts := &registry.VersionOptions{
+		Version:     version,
+		URL:         remote.URL,
+		Sha256:      remote.Sha256,
+		Signature:   remote.Signature,
+		SpacePrefix: s.GetPrefix(),
+		Editor:      editor,
+		RegistryURL: tarballURL(m, s, &remote),
+	}
+	if !editor.HasPublicKeys() {
+		// The signature can't be checked without the keys of the editor
+		opts.Signature = ""
+	}
+	ver, attachments, err := registry.DownloadVersion(opts)
+	if err != nil {
+		return err
+	}
+	ver.CreatedAt = remote.CreatedAt
+	if len(remote.AttachmentReferences) > 0 {
+		// The icon and screenshots may have been given in the options when
+		// the version was published upstream, so they are fetched from the
+		// remote registry instead of being extracted from the tarball
+		if attachments, err = fetchAssets(m, &remote); err != nil {
+			return err
+		}
+	}
+	return registry.CreateReleaseVersion(s, ver, attachments, app, true)
+}
+
+// fetchAssets downloads the assets of a remote version (icon, partnership
+// icon, screenshots), and checks them with their sha256.
+func fetchAssets(m *base.Mirror, remote *registry.Version) ([]*kivik.Attachment, error) {
+	attachments := make([]*kivik.Attachment, 0, len(remote.AttachmentReferences))
+	for filename, shasum := range remote.AttachmentReferences {
+		parts := append([]string{remote.Slug, remote.Version}, strings.Split(filename, "/")...)
+		data, contentType, err := getAsset(remoteURL(m, parts...))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != shasum {
+			return nil, fmt.Errorf("sha256 mismatch for the asset %s", filename)
+		}
+		attachments = append(attachments, &kivik.Attachment{
+			Content:     ioutil.NopCloser(bytes.NewReader(data)),
+			Size:        int64(len(data)),
+			Filename:    filename,
+			ContentType: contentType,
+		})
+	}
+	return attachments, nil
+}
+
+// tarballURL returns the URL where the tarball of a mirrored version can be
+// downloaded: on this registry if its public URL is known, or else on the
+// remote registry.
+func tarballURL(m *base.Mirror, s *space.Space, remote *registry.Version) *url.URL {
+	u, err := url.Parse(remote.URL)
+	if m.PublicURL == "" && err == nil {
+		return u
+	}
+	filename := filepath.Base(remote.URL)
+	if err != nil || filename == "" || filename == "." || filename == "/" {
+		filename = fmt.Sprintf("%s-%s.tar.gz", remote.Slug, remote.Version)
+	}
+	u, _ = url.Parse(m.PublicURL)
+	u.Path = path.Join(u.Path, s.Name, "registry", remote.Slug, remote.Version, "tarball", filename)
+	return u
+}
+
+// ensureEditor returns the local editor with the given name. If it doesn't
+// exist yet, it is created with the public keys of the remote editor, so that
+// the signatures of the versions can be checked.
+func ensureEditor(m *base.Mirror, name string) (*auth.Editor, error) {
+	editor, err := auth.Editors.GetEditor(name)
+	if err != auth.ErrEditorNotFound {
+		return editor, err
+	}
+
+	var remote struct {
+		PublicKeys []string `json:"public_keys"`
+	}
+	if err = getJSON(m.URL+"/editors/"+url.PathEscape(name), &remote); err != nil {
+		return nil, err
+	}
+	editor, err = auth.Editors.CreateEditorWithoutPublicKey(name, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, encoded := range remote.PublicKeys {
+		key, err := auth.ParsePublicKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if err = auth.Editors.AddPublicKey(editor, key); err != nil {
+			return nil, err
+		}
+	}
+	return editor, nil
+}
+
+func listRemoteApps(m *base.Mirror) ([]*registry.App, error) {
+	var apps []*registry.App
+	cursor := "0"
+	for cursor != "" {
+		var page struct {
+			Data []*registry.App `json:"data"`
+			Meta struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"meta"`
+		}
+		u := remoteURL(m) + "?versionsChannel=dev&limit=" + strconv.Itoa(pageSize) + "&cursor=" + url.QueryEscape(cursor)
+		if err := getJSON(u, &page); err != nil {
+			return nil, err
+		}
+		apps = append(apps, page.Data...)
+		cursor = page.Meta.NextCursor
+	}
+	return apps, nil
+}
+
+func remoteURL(m *base.Mirror, parts ...string) string {
+	u := m.URL
+	if m.Space != "" && m.Space != base.DefaultSpacePrefix.String() {
+		u += "/" + url.PathEscape(m.Space)
+	}
+	u += "/registry"
+	for _, part := range parts {
+		u += "/" + url.PathEscape(part)
+	}
+	return u
+}
+
+func getJSON(u string, doc interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := mirrorClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d for %s", res.StatusCode, u)
+	}
+	return json.NewDecoder(res.Body).Decode(doc)
+}
+
+func getAsset(u string) ([]byte, string, error) {
+	res, err := mirrorClient.Get(u)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status code %d for %s", res.StatusCode, u)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxAssetSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxAssetSize {
+		return nil, "", fmt.Errorf("asset too big for %s", u)
+	}
+	return data, res.Header.Get("Content-Type"), nil
+}
+
+// Start launches the periodic synchronization of the spaces that are mirrors
+// with an interval.
+func Start() {
+	for _, name := range space.GetSpacesNames() {
+		s, _ := space.GetSpace(name)
+		m := base.Config.Mirror(s.GetPrefix())
+		if m == nil || m.Interval <= 0 {
+			continue
+		}
+		go loop(s, m)
+	}
+}
+
+func loop(s *space.Space, m *base.Mirror) {
+	log := logrus.WithFields(logrus.Fields{
+		"nspace": "mirror",
+		"space":  s.Name,
+		"url":    m.URL,
+	})
+	for {
+		report, err := Sync(s, m)
+		if err != nil {
+			log.Errorf("Cannot synchronize the mirror: %s", err)
+		} else {
+			for _, err := range report.Errors {
+				log.Warnf("Cannot synchronize: %s", err)
+			}
+			log.Infof("Mirror synchronized: %d apps and %d versions created",
+				len(report.AppsCreated), len(report.VersionsCreated))
+		}
+		time.Sleep(m.Interval)
+	}
+}
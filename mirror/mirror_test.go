@@ -0,0 +1,127 @@
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteURL(t *testing.T) {
+	m := &base.Mirror{URL: "https://apps-registry.cozycloud.cc"}
+	assert.Equal(t, "https://apps-registry.cozycloud.cc/registry", remoteURL(m))
+	m.Space = "__default__"
+	assert.Equal(t, "https://apps-registry.cozycloud.cc/registry/drive", remoteURL(m, "drive"))
+	m.Space = "selfhosted"
+	assert.Equal(t, "https://apps-registry.cozycloud.cc/selfhosted/registry/drive/1.2.3",
+		remoteURL(m, "drive", "1.2.3"))
+}
+
+func TestTarballURL(t *testing.T) {
+	remote := &registry.Version{
+		Slug:    "drive",
+		Version: "1.2.3",
+		URL:     "https://apps-registry.cozycloud.cc/registry/drive/1.2.3/tarball/drive.tar.gz",
+	}
+	m := &base.Mirror{URL: "https://apps-registry.cozycloud.cc"}
+	s := space.NewSpace("mirror")
+	assert.Equal(t, remote.URL, tarballURL(m, s, remote).String())
+
+	m.PublicURL = "https://registry.example.org"
+	assert.Equal(t, "https://registry.example.org/mirror/registry/drive/1.2.3/tarball/drive.tar.gz",
+		tarballURL(m, s, remote).String())
+}
+
+func TestListRemoteApps(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/registry", r.URL.Path)
+		assert.Equal(t, "dev", r.URL.Query().Get("versionsChannel"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("cursor") {
+		case "0":
+			fmt.Fprint(w, `{"data":[{"slug":"drive"},{"slug":"photos"}],"meta":{"count":2,"next_cursor":"2"}}`)
+		case "2":
+			fmt.Fprint(w, `{"data":[{"slug":"banks"}],"meta":{"count":1}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	apps, err := listRemoteApps(&base.Mirror{URL: ts.URL})
+	assert.NoError(t, err)
+	if assert.Len(t, apps, 3) {
+		assert.Equal(t, "drive", apps[0].Slug)
+		assert.Equal(t, "photos", apps[1].Slug)
+		assert.Equal(t, "banks", apps[2].Slug)
+	}
+}
+
+func TestMirrorAcceptApp(t *testing.T) {
+	all := base.Mirror{}
+	assert.True(t, all.AcceptApp("drive"))
+	selected := base.Mirror{Filter: "select", Slugs: []string{"drive"}}
+	assert.True(t, selected.AcceptApp("drive"))
+	assert.False(t, selected.AcceptApp("banks"))
+	rejected := base.Mirror{Filter: "reject", Slugs: []string{"drive"}}
+	assert.False(t, rejected.AcceptApp("drive"))
+	assert.True(t, rejected.AcceptApp("banks"))
+}
+
+func TestFetchAssets(t *testing.T) {
+	icon, shot := []byte("<svg></svg>"), []byte("PNG")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/registry/drive/1.2.3/icon":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			_, _ = w.Write(icon)
+		case "/registry/drive/1.2.3/screenshots/img/home.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(shot)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	iconSum, shotSum := sha256.Sum256(icon), sha256.Sum256(shot)
+	remote := &registry.Version{
+		Slug:    "drive",
+		Version: "1.2.3",
+		AttachmentReferences: map[string]string{
+			"icon":                     hex.EncodeToString(iconSum[:]),
+			"screenshots/img/home.png": hex.EncodeToString(shotSum[:]),
+		},
+	}
+	m := &base.Mirror{URL: ts.URL}
+	attachments, err := fetchAssets(m, remote)
+	assert.NoError(t, err)
+	if assert.Len(t, attachments, 2) {
+		for _, att := range attachments {
+			switch att.Filename {
+			case "icon":
+				assert.Equal(t, "image/svg+xml", att.ContentType)
+				assert.EqualValues(t, len(icon), att.Size)
+			case "screenshots/img/home.png":
+				assert.Equal(t, "image/png", att.ContentType)
+				assert.EqualValues(t, len(shot), att.Size)
+			default:
+				t.Errorf("unexpected attachment %s", att.Filename)
+			}
+		}
+	}
+
+	remote.AttachmentReferences["icon"] = hex.EncodeToString(shotSum[:])
+	_, err = fetchAssets(m, remote)
+	assert.Error(t, err)
+	remote.AttachmentReferences = map[string]string{"partnership_icon": "abc"}
+	_, err = fetchAssets(m, remote)
+	assert.Error(t, err)
+}
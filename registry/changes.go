@@ -0,0 +1,286 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/go-kivik/kivik/v3"
+)
+
+// ErrChangesSinceInvalid is used when the since parameter of the changes feed
+// is not a token returned by the registry.
+var ErrChangesSinceInvalid = errshttp.NewError(http.StatusBadRequest, "Invalid since parameter")
+
+// The types of the documents in the changes feed.
+const (
+	ChangeApp     = "app"
+	ChangeVersion = "version"
+)
+
+// Change is an event of the changes feed of a space: an application or a
+// version has been created, updated or deleted.
+type Change struct {
+	Type    string `json:"type"`
+	Slug    string `json:"slug"`
+	Version string `json:"version,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	// Doc is the *App or *Version, and is empty for a deleted document
+	Doc interface{} `json:"doc,omitempty"`
+}
+
+// ChangesOptions are the options for GetChanges.
+type ChangesOptions struct {
+	// Since is the token returned by a previous call, "now" to start from the
+	// current state, or empty to start from the beginning.
+	Since string
+	// Limit is the maximal number of changes taken from the applications feed
+	// and from the versions feed.
+	Limit int
+	// LongPoll can be used to wait for a change if there are none yet.
+	LongPoll bool
+	// Timeout is the maximal duration of the wait in long-poll mode.
+	Timeout time.Duration
+}
+
+// changesSeq is the position in the changes feeds of the two databases of a
+// space. It is sent to the clients as an opaque token.
+type changesSeq struct {
+	Apps     string `json:"a,omitempty"`
+	Versions string `json:"v,omitempty"`
+}
+
+func parseChangesSeq(since string) (*changesSeq, error) {
+	if since == "" {
+		return &changesSeq{}, nil
+	}
+	if since == "now" {
+		return &changesSeq{Apps: "now", Versions: "now"}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(since)
+	if err != nil {
+		return nil, ErrChangesSinceInvalid
+	}
+	var seq changesSeq
+	if err = json.Unmarshal(raw, &seq); err != nil {
+		return nil, ErrChangesSinceInvalid
+	}
+	return &seq, nil
+}
+
+func (s *changesSeq) String() string {
+	raw, _ := json.Marshal(s)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// GetChanges returns the changes of the applications and versions of a
+// space, since the given token, and the token to use for the next call. In
+// a virtual space, only the changes of the applications accepted by the
+// virtual space are returned, with the overwrites of the virtual space.
+func GetChanges(ctx context.Context, virtual *base.VirtualSpace, c *space.Space, opts ChangesOptions) ([]*Change, string, error) {
+	seq, err := parseChangesSeq(opts.Since)
+	if err != nil {
+		return nil, "", err
+	}
+
+	appsFeed := &changesFeed{db: c.AppsDB(), kind: ChangeApp, since: seq.Apps}
+	versFeed := &changesFeed{db: c.VersDB(), kind: ChangeVersion, since: seq.Versions}
+	feeds := []*changesFeed{appsFeed, versFeed}
+	for _, feed := range feeds {
+		if err := feed.fetch(ctx, opts.Limit, nil); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if opts.LongPoll && appsFeed.empty() && versFeed.empty() {
+		if err := waitForChanges(ctx, feeds, opts); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var changes []*Change
+	for _, feed := range feeds {
+		for _, change := range feed.changes {
+			if virtual != nil {
+				if !virtual.AcceptApp(change.Slug) {
+					continue
+				}
+				if err := overrideChange(virtual, change); err != nil {
+					return nil, "", err
+				}
+			}
+			changes = append(changes, change)
+		}
+	}
+	next := &changesSeq{Apps: appsFeed.since, Versions: versFeed.since}
+	return changes, next.String(), nil
+}
+
+// overrideChange replaces the document of a change by the one seen in the
+// virtual space, like for the lists and the show routes: the versions with
+// another name or icon have their own manifest, sha256 and tarball, and the
+// applications can be in maintenance only in the virtual space.
+func overrideChange(virtual *base.VirtualSpace, change *Change) error {
+	switch doc := change.Doc.(type) {
+	case *Version:
+		overwritten, err := FindOverwrittenVersion(virtual, doc)
+		if err == ErrVersionNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		change.Doc = overwritten
+	case *App:
+		return overrideAppMaintenance(virtual, doc)
+	}
+	return nil
+}
+
+// waitForChanges listens on the changes feeds of the databases, and returns
+// as soon as one of them has a change, or when the timeout is reached.
+func waitForChanges(ctx context.Context, feeds []*changesFeed, opts ChangesOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	params := map[string]interface{}{
+		"feed":    "longpoll",
+		"timeout": opts.Timeout.Milliseconds(),
+	}
+	errs := make(chan error, len(feeds))
+	for _, feed := range feeds {
+		go func(feed *changesFeed) {
+			err := feed.fetch(ctx, opts.Limit, params)
+			if err == nil && !feed.empty() {
+				cancel()
+			}
+			errs <- err
+		}(feed)
+	}
+
+	var firstErr error
+	for range feeds {
+		err := <-errs
+		// The feeds cancelled because another one has a change keep their
+		// previous position
+		if err != nil && !errors.Is(err, context.Canceled) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil && ctx.Err() == nil {
+		return firstErr
+	}
+	return nil
+}
+
+type changesFeed struct {
+	db      *kivik.DB
+	kind    string
+	since   string
+	changes []*Change
+}
+
+func (f *changesFeed) empty() bool {
+	return len(f.changes) == 0
+}
+
+func (f *changesFeed) fetch(ctx context.Context, limit int, params map[string]interface{}) error {
+	options := map[string]interface{}{
+		"include_docs": true,
+	}
+	if f.since != "" {
+		options["since"] = f.since
+	}
+	if limit > 0 {
+		options["limit"] = limit
+	}
+	for k, v := range params {
+		options[k] = v
+	}
+
+	rows, err := f.db.Changes(ctx, options)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var changes []*Change
+	for rows.Next() {
+		if strings.HasPrefix(rows.ID(), "_design") {
+			continue
+		}
+		change, err := f.parseChange(rows)
+		if err != nil {
+			return err
+		}
+		if change != nil {
+			changes = append(changes, change)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	f.changes = changes
+	if last := rows.LastSeq(); last != "" {
+		f.since = last
+	}
+	return nil
+}
+
+func (f *changesFeed) parseChange(rows *kivik.Changes) (*Change, error) {
+	change := &Change{Type: f.kind, Deleted: rows.Deleted()}
+	if change.Deleted {
+		// Only the identifier is kept for a deleted document
+		if f.kind == ChangeApp {
+			change.Slug = rows.ID()
+		} else {
+			var ok bool
+			change.Slug, change.Version, ok = splitVersionID(rows.ID())
+			if !ok {
+				return nil, nil
+			}
+		}
+		return change, nil
+	}
+
+	if f.kind == ChangeApp {
+		var app App
+		if err := rows.ScanDoc(&app); err != nil {
+			return nil, err
+		}
+		change.Slug = app.Slug
+		change.Doc = &app
+	} else {
+		var version Version
+		if err := rows.ScanDoc(&version); err != nil {
+			return nil, err
+		}
+		change.Slug = version.Slug
+		change.Version = version.Version
+		change.Doc = &version
+	}
+	return change, nil
+}
+
+// splitVersionID returns the slug and the version for the identifier of a
+// version document. As the slug can contain dashes, the first split that
+// gives a valid version is used.
+func splitVersionID(id string) (string, string, bool) {
+	for i := 0; i < len(id); i++ {
+		if id[i] != '-' {
+			continue
+		}
+		slug, version := id[:i], id[i+1:]
+		if validSlugReg.MatchString(slug) && validVersionReg.MatchString(version) {
+			return slug, version, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,207 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/space"
+)
+
+// sizeIncreaseThreshold is the ratio of the size of the tarball of the
+// previous stable version above which the size increase is reported.
+const sizeIncreaseThreshold = 0.2
+
+// The checks run on the pending versions, used in the warnings.
+const (
+	WarningPermissions    = "permissions"
+	WarningRemoteDoctypes = "remote_doctypes"
+	WarningLabel          = "label"
+	WarningSize           = "size"
+	WarningEditor         = "editor"
+	WarningSlug           = "slug"
+	WarningSha256         = "sha256"
+	WarningError          = "error"
+)
+
+// CheckReport is the result of the automated checks run on a pending
+// version, to help the reviewers. The version is compared to the latest
+// stable version of the application.
+type CheckReport struct {
+	ComparedTo string          `json:"compared_to,omitempty"`
+	CheckedAt  time.Time       `json:"checked_at"`
+	Warnings   []*CheckWarning `json:"warnings"`
+}
+
+// CheckWarning is a change that a reviewer should look at.
+type CheckWarning struct {
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+func (r *CheckReport) warn(check, format string, a ...interface{}) {
+	r.Warnings = append(r.Warnings, &CheckWarning{
+		Check:   check,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// manifestPermission is a permission of a manifest, as used by the checks.
+type manifestPermission struct {
+	Type     string   `json:"type"`
+	Verbs    []string `json:"verbs,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	Remote   bool     `json:"remote,omitempty"`
+}
+
+func (p manifestPermission) String() string {
+	verbs := "ALL"
+	if len(p.Verbs) > 0 {
+		verbs = strings.Join(p.Verbs, ", ")
+	}
+	s := fmt.Sprintf("%s (%s)", p.Type, verbs)
+	if p.Remote {
+		s += " remote"
+	}
+	return s
+}
+
+type checkedManifest struct {
+	Slug        string                        `json:"slug"`
+	Editor      string                        `json:"editor"`
+	Permissions map[string]manifestPermission `json:"permissions"`
+}
+
+// CheckPendingVersion runs the automated checks on a pending version and
+// returns the report. The version is not saved.
+func CheckPendingVersion(c *space.Space, ver *Version, app *App) *CheckReport {
+	report := &CheckReport{
+		CheckedAt: time.Now().UTC(),
+		Warnings:  []*CheckWarning{},
+	}
+
+	var man checkedManifest
+	if err := json.Unmarshal(ver.Manifest, &man); err != nil {
+		report.warn(WarningError, "The manifest cannot be read: %s", err)
+		return report
+	}
+	if ver.Slug != app.Slug || (man.Slug != "" && man.Slug != app.Slug) {
+		report.warn(WarningSlug, "The slug %q does not match the application %q", man.Slug, app.Slug)
+	}
+	if ver.Editor != app.Editor {
+		report.warn(WarningEditor, "The editor %q does not match the editor of the application %q", ver.Editor, app.Editor)
+	}
+	checkTarballSha256(c, ver, report)
+
+	stable, err := FindLatestVersion(c, app.Slug, Stable)
+	if err != nil && err != ErrVersionNotFound {
+		report.warn(WarningError, "The latest stable version cannot be loaded: %s", err)
+		return report
+	}
+	var previous checkedManifest
+	if stable != nil {
+		report.ComparedTo = stable.Version
+		if err = json.Unmarshal(stable.Manifest, &previous); err != nil {
+			report.warn(WarningError, "The manifest of the version %s cannot be read: %s", stable.Version, err)
+			return report
+		}
+		if stable.Editor != ver.Editor {
+			report.warn(WarningEditor, "The editor has changed from %q to %q", stable.Editor, ver.Editor)
+		}
+		if previous.Slug != "" && man.Slug != previous.Slug {
+			report.warn(WarningSlug, "The slug has changed from %q to %q", previous.Slug, man.Slug)
+		}
+		if stable.Size > 0 && float64(ver.Size) > float64(stable.Size)*(1+sizeIncreaseThreshold) {
+			report.warn(WarningSize, "The tarball size has increased by %d%% (%d to %d bytes)",
+				(ver.Size-stable.Size)*100/stable.Size, stable.Size, ver.Size)
+		}
+	}
+
+	comparePermissions(previous.Permissions, man.Permissions, report)
+	before := calculateAppLabel(app, stable)
+	if after := calculateAppLabel(app, ver); after != before {
+		report.warn(WarningLabel, "The label of the application changes from %s to %s", before, after)
+	}
+	return report
+}
+
+// comparePermissions reports the permissions that are added, removed or
+// modified, and the new remote doctypes.
+func comparePermissions(before, after map[string]manifestPermission, report *CheckReport) {
+	names := make([]string, 0, len(before)+len(after))
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		old, hadIt := before[name]
+		perm, hasIt := after[name]
+		switch {
+		case !hasIt:
+			report.warn(WarningPermissions, "Permission %q removed: %s", name, old)
+		case !hadIt:
+			report.warn(WarningPermissions, "Permission %q added: %s", name, perm)
+		case !reflect.DeepEqual(old, perm):
+			report.warn(WarningPermissions, "Permission %q changed: %s -> %s", name, old, perm)
+		}
+		if hasIt && perm.Remote && (!hadIt || !old.Remote) {
+			report.warn(WarningRemoteDoctypes, "New remote doctype %s", perm.Type)
+		}
+	}
+}
+
+// checkTarballSha256 verifies that the tarball kept in the storage still
+// matches the sha256 of the version.
+func checkTarballSha256(c *space.Space, ver *Version, report *CheckReport) {
+	filename := path.Base(ver.URL)
+	if ver.URL == "" || filename == "." || filename == "/" {
+		report.warn(WarningSha256, "The tarball of the version is unknown")
+		return
+	}
+	obj, err := base.Storage.Open(c.GetPrefix(), path.Join(ver.Slug, ver.Version, filename))
+	if err != nil {
+		report.warn(WarningSha256, "The tarball cannot be read: %s", err)
+		return
+	}
+	defer obj.File.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, obj.File); err != nil {
+		report.warn(WarningSha256, "The tarball cannot be read: %s", err)
+		return
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != ver.Sha256 {
+		report.warn(WarningSha256, "The sha256 of the tarball (%s) does not match the version (%s)", actual, ver.Sha256)
+	}
+}
+
+// RecheckPendingVersion runs the checks again on a pending version, and saves
+// the new report.
+func RecheckPendingVersion(c *space.Space, ver *Version) error {
+	app, err := findApp(c, ver.Slug)
+	if err != nil {
+		return err
+	}
+	ver.Checks = CheckPendingVersion(c, ver, app)
+	rev, err := c.PendingVersDB().Put(context.Background(), ver.ID, ver)
+	if err != nil {
+		return err
+	}
+	ver.Rev = rev
+	return nil
+}
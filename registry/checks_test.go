@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComparePermissions(t *testing.T) {
+	before := map[string]manifestPermission{
+		"files":    {Type: "io.cozy.files", Verbs: []string{"GET"}},
+		"contacts": {Type: "io.cozy.contacts"},
+		"bills":    {Type: "io.cozy.bills", Verbs: []string{"GET", "POST"}},
+	}
+	after := map[string]manifestPermission{
+		"files":   {Type: "io.cozy.files", Verbs: []string{"GET", "PUT"}},
+		"bills":   {Type: "io.cozy.bills", Verbs: []string{"GET", "POST"}},
+		"weather": {Type: "org.example.weather", Remote: true},
+	}
+
+	report := &CheckReport{}
+	comparePermissions(before, after, report)
+	assert.Equal(t, []*CheckWarning{
+		{Check: WarningPermissions, Message: `Permission "contacts" removed: io.cozy.contacts (ALL)`},
+		{Check: WarningPermissions, Message: `Permission "files" changed: io.cozy.files (GET) -> io.cozy.files (GET, PUT)`},
+		{Check: WarningPermissions, Message: `Permission "weather" added: org.example.weather (ALL) remote`},
+		{Check: WarningRemoteDoctypes, Message: "New remote doctype org.example.weather"},
+	}, report.Warnings)
+
+	report = &CheckReport{}
+	comparePermissions(after, after, report)
+	assert.Empty(t, report.Warnings)
+}
@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+)
+
+// MaxEditorLogoSize is the maximal size in bytes of the logo of an editor.
+const MaxEditorLogoSize = 1 << 20
+
+var (
+	ErrEditorLogoNotFound = errshttp.NewError(http.StatusNotFound, "Editor has no logo")
+	ErrEditorLogoInvalid  = errshttp.NewError(http.StatusBadRequest, "Editor logo should be an image")
+	ErrEditorLogoTooLarge = errshttp.NewError(http.StatusRequestEntityTooLarge, "Editor logo should not be larger than 1MB")
+)
+
+// editorLogoSource is the source of the logo of an editor in the global asset
+// store. It has no slash, so it is not mistaken for an application version.
+func editorLogoSource(editor *auth.Editor) string {
+	return "editor:" + strings.ToLower(editor.Name())
+}
+
+// SetEditorLogo saves the logo of the editor in the global asset store, and
+// removes the previous one.
+func SetEditorLogo(editor *auth.Editor, filename string, content []byte) error {
+	if len(content) > MaxEditorLogoSize {
+		return ErrEditorLogoTooLarge
+	}
+	contentType := getMIMEType(filename, content)
+	if !strings.HasPrefix(contentType, "image/") {
+		return ErrEditorLogoInvalid
+	}
+
+	source := editorLogoSource(editor)
+	a := &base.Asset{
+		Name:        filename,
+		ContentType: contentType,
+	}
+	if err := base.GlobalAssetStore.Add(a, bytes.NewReader(content), source); err != nil {
+		return err
+	}
+	previous := editor.Profile().Logo
+	if err := auth.Editors.SetLogo(editor, a.Shasum); err != nil {
+		return err
+	}
+	if previous != "" && previous != a.Shasum {
+		return base.GlobalAssetStore.Remove(previous, source)
+	}
+	return nil
+}
+
+// RemoveEditorLogo removes the logo of the editor from its profile and from
+// the global asset store.
+func RemoveEditorLogo(editor *auth.Editor) error {
+	previous := editor.Profile().Logo
+	if previous == "" {
+		return nil
+	}
+	if err := auth.Editors.SetLogo(editor, ""); err != nil {
+		return err
+	}
+	return base.GlobalAssetStore.Remove(previous, editorLogoSource(editor))
+}
+
+// FindEditorLogo returns the logo of the editor.
+func FindEditorLogo(editor *auth.Editor) (*Attachment, error) {
+	shasum := editor.Profile().Logo
+	if shasum == "" {
+		return nil, ErrEditorLogoNotFound
+	}
+	obj, err := base.GlobalAssetStore.Open(shasum)
+	if err != nil {
+		return nil, err
+	}
+	return newAttachment(obj), nil
+}
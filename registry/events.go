@@ -0,0 +1,28 @@
+package registry
+
+import (
+	"github.com/cozy/cozy-apps-registry/metrics"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/cozy/cozy-apps-registry/webhook"
+)
+
+// sendVersionEvent notifies the webhooks of the space of an event about a
+// version.
+func sendVersionEvent(eventType string, c *space.Space, ver *Version) {
+	metrics.VersionEvents.Inc(c.GetPrefix().String(), eventType)
+	event := webhook.NewEvent(eventType, c.GetPrefix(), ver.Slug)
+	event.Version = ver.Version
+	data := ver.Clone()
+	data.ID = ""
+	data.Rev = ""
+	event.Data = data
+	webhook.Send(event)
+}
+
+// sendAppEvent notifies the webhooks of the space of an event about an
+// application.
+func sendAppEvent(eventType string, c *space.Space, appSlug string, data interface{}) {
+	event := webhook.NewEvent(eventType, c.GetPrefix(), appSlug)
+	event.Data = data
+	webhook.Send(event)
+}
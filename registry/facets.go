@@ -0,0 +1,463 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/sirupsen/logrus"
+)
+
+// remotePermission is the value of filter[permission] for the applications
+// that request remote doctypes.
+const remotePermission = "remote"
+
+// ValidFacets is the list of the facets that can be counted for an apps list.
+var ValidFacets = []string{
+	"category",
+	"tag",
+	"locale",
+	"label",
+	"permission",
+}
+
+var labelNames = []string{"A", "B", "C", "D", "E", "F"}
+
+// String returns the letter of the label.
+func (l Label) String() string {
+	if l < 0 || int(l) >= len(labelNames) {
+		return "F"
+	}
+	return labelNames[l]
+}
+
+func parseLabel(name string) (Label, bool) {
+	for i, n := range labelNames {
+		if strings.EqualFold(n, name) {
+			return Label(i), true
+		}
+	}
+	return LabelF, false
+}
+
+// setFacets copies on the application the fields of the manifest of its
+// latest version that can be used as filters.
+func (app *App) setFacets(ver *Version) {
+	app.Categories, app.Tags, app.Locales, app.Permissions = nil, nil, nil, nil
+	app.RemoteDoctypes = false
+	if ver != nil {
+		var man struct {
+			Categories  []string                   `json:"categories"`
+			Tags        []string                   `json:"tags"`
+			Langs       []string                   `json:"langs"`
+			Locales     map[string]json.RawMessage `json:"locales"`
+			Permissions map[string]struct {
+				Type   string `json:"type"`
+				Remote bool   `json:"remote"`
+			} `json:"permissions"`
+		}
+		if err := json.Unmarshal(ver.Manifest, &man); err == nil {
+			app.Categories = uniqueStrings(man.Categories)
+			app.Tags = uniqueStrings(man.Tags)
+			locales := append([]string{}, man.Langs...)
+			for locale := range man.Locales {
+				locales = append(locales, locale)
+			}
+			app.Locales = uniqueStrings(locales)
+			var doctypes []string
+			for _, p := range man.Permissions {
+				if p.Type != "" {
+					doctypes = append(doctypes, p.Type)
+				}
+				if p.Remote {
+					app.RemoteDoctypes = true
+				}
+			}
+			app.Permissions = uniqueStrings(doctypes)
+		}
+	}
+	app.Label = calculateAppLabel(app, ver)
+}
+
+func uniqueStrings(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(list))
+	res := make([]string, 0, len(list))
+	for _, s := range list {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// facetsVersion returns the version used for the facets of an application:
+// the latest stable version, or the latest version of any channel if it has
+// no stable version.
+func facetsVersion(c *space.Space, appSlug string) (*Version, error) {
+	ver, err := FindLatestVersion(c, appSlug, Stable)
+	if err == ErrVersionNotFound {
+		ver, err = FindLatestVersion(c, appSlug, Dev)
+	}
+	if err == ErrVersionNotFound {
+		return nil, nil
+	}
+	return ver, err
+}
+
+// refreshAppFacets updates the fields of the application document that are
+// copied from the manifest of its latest version.
+func refreshAppFacets(c *space.Space, appSlug string) error {
+	app, err := findApp(c, appSlug)
+	if err != nil {
+		return err
+	}
+	ver, err := facetsVersion(c, appSlug)
+	if err != nil {
+		return err
+	}
+	app.setFacets(ver)
+	_, err = c.AppsDB().Put(context.Background(), app.ID, app)
+	return err
+}
+
+// updateAppFacets is like refreshAppFacets, but the errors are only logged:
+// the facets are derived data, and they must not make a change fail once it
+// has been saved.
+func updateAppFacets(c *space.Space, appSlug string) {
+	err := refreshAppFacets(c, appSlug)
+	if err != nil && err != ErrAppNotFound {
+		logrus.WithFields(logrus.Fields{
+			"nspace":    "facets",
+			"space":     c.Name,
+			"slug":      appSlug,
+			"error_msg": err,
+		}).Error("Cannot refresh the facets of the application")
+	}
+}
+
+// RefreshAppsFacets updates the fields copied from the manifests for all the
+// applications of a space. It can be used for the applications published
+// before these fields were added.
+func RefreshAppsFacets(c *space.Space) (int, error) {
+	rows, err := c.AppsDB().AllDocs(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		if !strings.HasPrefix(rows.ID(), "_design") {
+			slugs = append(slugs, rows.ID())
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, slug := range slugs {
+		if err = refreshAppFacets(c, slug); err != nil {
+			return 0, fmt.Errorf("%s: %w", slug, err)
+		}
+	}
+	return len(slugs), nil
+}
+
+// splitFilter returns the values of a filter of an apps list, separated by
+// commas. The spaces around the values and the empty values are ignored.
+func splitFilter(val string) []string {
+	parts := strings.Split(val, ",")
+	values := make([]string, 0, len(parts))
+	for _, v := range parts {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// facetArrayFields are the fields of the application document for the facets
+// with several values per application.
+var facetArrayFields = map[string]string{
+	"category": "categories",
+	"tag":      "tags",
+	"locale":   "locales",
+}
+
+// facetSelector returns the mango selector for a facet filter. For all the
+// facets, the values are separated by commas: an application must have one
+// of the values for the category, tag, locale and label, and all of them for
+// the permission, as each one is a requirement.
+func facetSelector(name, val string) (string, bool) {
+	values := splitFilter(val)
+	switch name {
+	case "category", "tag", "locale":
+		field := facetArrayFields[name]
+		return string(base.SprintfJSON(`%s: {"$elemMatch": {"$in": %s}}`, field, values)), true
+	case "label":
+		labels := make([]Label, 0, len(values))
+		for _, v := range values {
+			if l, ok := parseLabel(v); ok {
+				labels = append(labels, l)
+			}
+		}
+		return string(base.SprintfJSON(`"label": {"$in": %s}`, labels)), true
+	case "permission":
+		conds := make([]string, 0, len(values))
+		for _, v := range values {
+			negated := strings.HasPrefix(v, "!")
+			v = strings.TrimPrefix(v, "!")
+			var cond string
+			if v == remotePermission {
+				cond = `{"remote_doctypes": true}`
+				if negated {
+					cond = `{"remote_doctypes": {"$ne": true}}`
+				}
+			} else {
+				cond = string(base.SprintfJSON(`{"permissions": {"$elemMatch": {"$eq": %s}}}`, v))
+				if negated {
+					cond = string(base.SprintfJSON(`{"permissions": {"$not": {"$elemMatch": {"$eq": %s}}}}`, v))
+				}
+			}
+			conds = append(conds, cond)
+		}
+		return `"$and": [` + strings.Join(conds, ",") + `]`, true
+	}
+	return "", false
+}
+
+// matchFilters returns true if the application matches the filters of an
+// apps list. It is the equivalent of the mango selector, for the applications
+// found with the search index.
+func (app *App) matchFilters(filters map[string]string) bool {
+	for name, val := range filters {
+		values := splitFilter(val)
+		switch name {
+		case "type":
+			if app.Type != val {
+				return false
+			}
+		case "editor":
+			if app.Editor != val {
+				return false
+			}
+		case "select":
+			if !stringInArray(app.Slug, values) {
+				return false
+			}
+		case "reject":
+			if stringInArray(app.Slug, values) {
+				return false
+			}
+		case "category":
+			if !anyInArray(values, app.Categories) {
+				return false
+			}
+		case "tag":
+			if !anyInArray(values, app.Tags) {
+				return false
+			}
+		case "locale":
+			if !anyInArray(values, app.Locales) {
+				return false
+			}
+		case "label":
+			found := false
+			for _, v := range values {
+				if l, ok := parseLabel(v); ok && l == app.Label {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		case "permission":
+			for _, v := range values {
+				negated := strings.HasPrefix(v, "!")
+				v = strings.TrimPrefix(v, "!")
+				var has bool
+				if v == remotePermission {
+					has = app.RemoteDoctypes
+				} else {
+					has = stringInArray(v, app.Permissions)
+				}
+				if has == negated {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func anyInArray(values, array []string) bool {
+	for _, v := range values {
+		if stringInArray(v, array) {
+			return true
+		}
+	}
+	return false
+}
+
+// Facets are the number of applications for each value of the facets: facet
+// name -> value -> count.
+type Facets map[string]map[string]int
+
+func (f Facets) add(app *App, names []string) {
+	for _, name := range names {
+		counts, ok := f[name]
+		if !ok {
+			counts = make(map[string]int)
+			f[name] = counts
+		}
+		var values []string
+		switch name {
+		case "category":
+			values = app.Categories
+		case "tag":
+			values = app.Tags
+		case "locale":
+			values = app.Locales
+		case "label":
+			values = []string{app.Label.String()}
+		case "permission":
+			values = app.Permissions
+			if app.RemoteDoctypes {
+				values = append(values, remotePermission)
+			}
+		}
+		for _, v := range values {
+			counts[v]++
+		}
+	}
+}
+
+// GetAppsFacets returns the facet counts for the applications that match the
+// filters (and query) of the list options, ignoring the pagination.
+func GetAppsFacets(c *space.Space, opts *AppsListOptions, names []string) (Facets, error) {
+	for _, name := range names {
+		if !stringInArray(name, ValidFacets) {
+			return nil, errshttp.NewError(http.StatusBadRequest,
+				`Query param "facets" is invalid: unknown facet %q`, name)
+		}
+	}
+	facets := make(Facets)
+	if len(names) == 0 {
+		return facets, nil
+	}
+
+	var apps []*App
+	var err error
+	if opts.Query != "" {
+		var slugs []string
+		slugs, err = searchApps(c, opts.Query, listFilters(opts))
+		if err == nil {
+			apps, err = findAppsBySlugs(c, slugs)
+		}
+	} else {
+		apps, err = findFilteredApps(c, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		facets.add(app, names)
+	}
+	return facets, nil
+}
+
+// findFilteredAppsPerPage is the number of applications fetched per request
+// by findFilteredApps.
+const findFilteredAppsPerPage = 1000
+
+// findFilteredApps returns all the applications of the space that match the
+// filters of the list options. They are fetched by pages, with the bookmarks
+// of CouchDB.
+func findFilteredApps(c *space.Space, opts *AppsListOptions) ([]*App, error) {
+	selector := listSelector(opts.Filters)
+	if selector == "" {
+		selector = `"slug": {"$gt": null}`
+	}
+
+	var apps []*App
+	bookmark := ""
+	for {
+		page, next, err := findFilteredAppsPage(c, selector, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, page...)
+		if len(page) < findFilteredAppsPerPage || next == "" || next == bookmark {
+			return apps, nil
+		}
+		bookmark = next
+	}
+}
+
+func findFilteredAppsPage(c *space.Space, selector, bookmark string) ([]*App, string, error) {
+	req := `{"selector": {` + selector + `}, "limit": ` + strconv.Itoa(findFilteredAppsPerPage)
+	if bookmark != "" {
+		req += string(base.SprintfJSON(`, "bookmark": %s`, bookmark))
+	}
+	req += `}`
+	rows, err := c.AppsDB().Find(context.Background(), req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var apps []*App
+	for rows.Next() {
+		var app App
+		if err = rows.ScanDoc(&app); err != nil {
+			return nil, "", err
+		}
+		apps = append(apps, &app)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+	return apps, rows.Bookmark(), nil
+}
+
+// findAppsBySlugs returns the applications with the given slugs, in the same
+// order. The slugs that don't match an application are ignored.
+func findAppsBySlugs(c *space.Space, slugs []string) ([]*App, error) {
+	res := make([]*App, 0, len(slugs))
+	if len(slugs) == 0 {
+		return res, nil
+	}
+	keys := make([]string, len(slugs))
+	for i, slug := range slugs {
+		keys[i] = getAppID(slug)
+	}
+	rows, err := c.AppsDB().AllDocs(context.Background(), map[string]interface{}{
+		"include_docs": true,
+		"keys":         keys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var doc *App
+		if err = rows.ScanDoc(&doc); err != nil || doc == nil {
+			// The application has been removed since the index was built
+			continue
+		}
+		res = append(res, doc)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppFacets(t *testing.T) {
+	app := &App{
+		Slug:                  "banks",
+		Type:                  "konnector",
+		DataUsageCommitment:   DUCUserReserved,
+		DataUsageCommitmentBy: DUCByCozy,
+	}
+	ver := &Version{Manifest: json.RawMessage(`{
+		"categories": ["banking", "finance", "banking"],
+		"tags": ["money"],
+		"langs": ["en", "fr"],
+		"locales": {"fr": {}, "de": {}},
+		"permissions": {
+			"accounts": {"type": "io.cozy.bank.accounts"},
+			"files": {"type": "io.cozy.files"}
+		}
+	}`)}
+	app.setFacets(ver)
+
+	assert.Equal(t, []string{"banking", "finance"}, app.Categories)
+	assert.Equal(t, []string{"money"}, app.Tags)
+	assert.Equal(t, []string{"de", "en", "fr"}, app.Locales)
+	assert.Equal(t, []string{"io.cozy.bank.accounts", "io.cozy.files"}, app.Permissions)
+	assert.False(t, app.RemoteDoctypes)
+	assert.Equal(t, Label(LabelB), app.Label)
+
+	assert.True(t, app.matchFilters(map[string]string{"category": "banking", "locale": "de"}))
+	assert.False(t, app.matchFilters(map[string]string{"category": "photos"}))
+	assert.True(t, app.matchFilters(map[string]string{"category": "photos, banking", "tag": "money,"}))
+	assert.True(t, app.matchFilters(map[string]string{"label": "a,b"}))
+	assert.False(t, app.matchFilters(map[string]string{"label": "C"}))
+	assert.True(t, app.matchFilters(map[string]string{"permission": "io.cozy.files,!remote"}))
+	assert.False(t, app.matchFilters(map[string]string{"permission": "!io.cozy.files"}))
+
+	facets := make(Facets)
+	facets.add(app, []string{"category", "label"})
+	assert.Equal(t, 1, facets["category"]["banking"])
+	assert.Equal(t, 1, facets["label"]["B"])
+}
+
+func TestFacetSelector(t *testing.T) {
+	sel, ok := facetSelector("label", "A,b")
+	assert.True(t, ok)
+	assert.Equal(t, `"label": {"$in": [0,1]}`, sel)
+
+	sel, ok = facetSelector("permission", "!remote")
+	assert.True(t, ok)
+	assert.Equal(t, `"$and": [{"remote_doctypes": {"$ne": true}}]`, sel)
+
+	sel, ok = facetSelector("category", "banking, energy")
+	assert.True(t, ok)
+	assert.Equal(t, `"categories": {"$elemMatch": {"$in": ["banking","energy"]}}`, sel)
+
+	_, ok = facetSelector("type", "webapp")
+	assert.False(t, ok)
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"path/filepath"
 	"sort"
@@ -17,7 +16,6 @@ import (
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/space"
 	"github.com/go-kivik/kivik/v3"
-	"github.com/ncw/swift"
 	"github.com/sirupsen/logrus"
 )
 
@@ -26,6 +24,11 @@ var validFilters = []string{
 	"editor",
 	"select",
 	"reject",
+	"category",
+	"tag",
+	"locale",
+	"label",
+	"permission",
 }
 
 var validSorts = []string{
@@ -33,6 +36,8 @@ var validSorts = []string{
 	"type",
 	"editor",
 	"created_at",
+	"label",
+	"downloads",
 }
 
 // ConcatChannels type
@@ -93,11 +98,22 @@ func FindApp(v *base.VirtualSpace, c *space.Space, appSlug string, channel Chann
 	return doc, nil
 }
 
+// Attachment is a file of a version, read as a stream from the storage. The
+// caller must close its content.
 type Attachment struct {
-	ContentType   string
-	Content       io.Reader
-	Etag          string
-	ContentLength string
+	ContentType string
+	Content     base.File
+	Etag        string
+	Size        int64
+}
+
+func newAttachment(obj *base.Object) *Attachment {
+	return &Attachment{
+		ContentType: obj.ContentType,
+		Content:     obj.File,
+		Etag:        obj.Etag,
+		Size:        obj.Size,
+	}
 }
 
 func FindAppAttachment(c *space.Space, appSlug, filename string, channel Channel) (*Attachment, error) {
@@ -114,41 +130,37 @@ func FindAppAttachment(c *space.Space, appSlug, filename string, channel Channel
 }
 
 func FindVersionAttachment(c *space.Space, version *Version, filename string) (*Attachment, error) {
-	var headers swift.Headers
-	var shasum, contentType string
-	var fileContent []byte
-
-	var contentBuffer *bytes.Buffer
 	slug := version.Slug
 	ver := version.Version
 	fp := filepath.Join(slug, ver, filename)
+	prefix := c.GetPrefix()
 
 	// Checks if the asset from the global database is referenced in the Version
 	// document
 	shasum, ok := version.AttachmentReferences[filename]
 
+	var obj *base.Object
 	var err error
 	if ok {
-		if contentBuffer, headers, err = base.GlobalAssetStore.Get(shasum); err != nil {
+		if obj, err = base.GlobalAssetStore.Open(shasum); err != nil {
 			return nil, err
 		}
 	} else {
 		// If we cannot find it, we try from the app swift container as a fallback
-		prefix := c.GetPrefix()
-		if contentBuffer, headers, err = base.Storage.Get(prefix, fp); err != nil {
+		if obj, err = base.Storage.Open(prefix, fp); err != nil {
 			return nil, err
 		}
 	}
 
-	fileContent = contentBuffer.Bytes()
-	contentType = headers["Content-Type"]
-
 	// If the asset was not found in the global database, move it for the next
 	// time (except when the ID is missing on the version, which is the case
 	// when the version was loaded via FindLatestVersion).
 	if !ok && version.ID != "" {
 		go func() {
-			err := MoveAssetToGlobalDatabase(c, version, fileContent, filename, contentType)
+			content, headers, err := base.Storage.Get(prefix, fp)
+			if err == nil {
+				err = MoveAssetToGlobalDatabase(c, version, content.Bytes(), filename, headers["Content-Type"])
+			}
 			if err != nil {
 				log := logrus.WithFields(logrus.Fields{
 					"nspace":    "move_asset",
@@ -163,15 +175,7 @@ func FindVersionAttachment(c *space.Space, version *Version, filename string) (*
 		}()
 	}
 
-	content := bytes.NewReader(fileContent)
-
-	att := &Attachment{
-		ContentType:   contentType,
-		Content:       content,
-		Etag:          headers["Etag"],
-		ContentLength: headers["Content-Length"],
-	}
-	return att, nil
+	return newAttachment(obj), nil
 }
 
 // MoveAssetToGlobalDatabase moves an asset located in the "local" container in
@@ -293,8 +297,9 @@ func FindLastsVersionsSince(c *space.Space, appSlug, channel string, date time.T
 		if err := rows.ScanDoc(&version); err != nil {
 			return nil, err
 		}
-		// Filter by version
-		if version.Slug == appSlug {
+		// Filter by version, the yanked ones are excluded like in the channel
+		// views
+		if version.Slug == appSlug && version.Yanked == nil {
 			versions = append(versions, version)
 		}
 	}
@@ -606,10 +611,13 @@ func FindAppVersionsCacheMiss(c *space.Space, appSlug string, channel Channel, c
 }
 
 type AppsListOptions struct {
-	Limit                int
-	Cursor               int
-	Sort                 string
-	Filters              map[string]string
+	Limit   int
+	Cursor  int
+	Sort    string
+	Filters map[string]string
+	// Query is a full-text search on the manifests of the applications. When
+	// it is set, the applications are sorted by relevance.
+	Query                string
 	LatestVersionChannel Channel
 	VersionsChannel      Channel
 }
@@ -669,6 +677,15 @@ func GetAppChannelVersions(c *space.Space, appSlug string, channel Channel) ([]*
 }
 
 func GetAppsList(v *base.VirtualSpace, c *space.Space, opts *AppsListOptions) (int, []*App, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 50
+	} else if opts.Limit > maxLimit {
+		opts.Limit = maxLimit
+	}
+	if opts.Query != "" {
+		return searchAppsList(v, c, opts)
+	}
+
 	db := c.AppsDB()
 	order := "asc"
 
@@ -691,26 +708,7 @@ func GetAppsList(v *base.VirtualSpace, c *space.Space, opts *AppsListOptions) (i
 		sort += fmt.Sprintf(`{"%s": "%s"}`, field, order)
 	}
 
-	selector := ``
-	for name, val := range opts.Filters {
-		if !stringInArray(name, validFilters) {
-			continue
-		}
-		if selector != "" {
-			selector += ","
-		}
-
-		switch name {
-		case "select":
-			slugs := strings.Split(val, ",")
-			selector += string(base.SprintfJSON(`"slug": {"$in": %s}`, slugs))
-		case "reject":
-			slugs := strings.Split(val, ",")
-			selector += string(base.SprintfJSON(`"slug": {"$nin": %s}`, slugs))
-		default:
-			selector += string(base.SprintfJSON("%s: %s", name, val))
-		}
-	}
+	selector := listSelector(opts.Filters)
 	if selector == "" {
 		selector = string(base.SprintfJSON(`%s: {"$gt": null}`, sortField))
 	}
@@ -718,12 +716,6 @@ func GetAppsList(v *base.VirtualSpace, c *space.Space, opts *AppsListOptions) (i
 	// Note: we can ignore design docs below as we always have a selector that
 	// will reject them.
 
-	if opts.Limit == 0 {
-		opts.Limit = 50
-	} else if opts.Limit > maxLimit {
-		opts.Limit = maxLimit
-	}
-
 	limit := opts.Limit + 1
 	cursor := opts.Cursor
 	req := base.SprintfJSON(`{
@@ -761,6 +753,85 @@ func GetAppsList(v *base.VirtualSpace, c *space.Space, opts *AppsListOptions) (i
 		cursor = -1
 	}
 
+	if err = fillAppsVersions(v, c, opts, res); err != nil {
+		return 0, nil, err
+	}
+	return cursor, res, nil
+}
+
+// searchAppsList is GetAppsList for a full-text search: the applications are
+// found with the search index, and the cursor is the position in the results.
+func searchAppsList(v *base.VirtualSpace, c *space.Space, opts *AppsListOptions) (int, []*App, error) {
+	slugs, err := searchApps(c, opts.Query, listFilters(opts))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	cursor := opts.Cursor
+	if cursor < 0 || cursor >= len(slugs) {
+		return -1, make([]*App, 0), nil
+	}
+	slugs = slugs[cursor:]
+	if len(slugs) > opts.Limit {
+		slugs = slugs[:opts.Limit]
+		cursor += len(slugs)
+	} else {
+		cursor = -1
+	}
+
+	res, err := findAppsBySlugs(c, slugs)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = fillAppsVersions(v, c, opts, res); err != nil {
+		return 0, nil, err
+	}
+	return cursor, res, nil
+}
+
+// listFilters returns the valid filters of the list options.
+func listFilters(opts *AppsListOptions) map[string]string {
+	filters := make(map[string]string)
+	for name, val := range opts.Filters {
+		if stringInArray(name, validFilters) {
+			filters[name] = val
+		}
+	}
+	return filters
+}
+
+// listSelector returns the mango selector for the filters of an apps list.
+func listSelector(filters map[string]string) string {
+	selector := ``
+	for name, val := range filters {
+		if !stringInArray(name, validFilters) {
+			continue
+		}
+		if selector != "" {
+			selector += ","
+		}
+
+		switch name {
+		case "select":
+			selector += string(base.SprintfJSON(`"slug": {"$in": %s}`, splitFilter(val)))
+		case "reject":
+			selector += string(base.SprintfJSON(`"slug": {"$nin": %s}`, splitFilter(val)))
+		default:
+			if facet, ok := facetSelector(name, val); ok {
+				selector += facet
+			} else {
+				selector += string(base.SprintfJSON("%s: %s", name, val))
+			}
+		}
+	}
+	return selector
+}
+
+// fillAppsVersions adds the versions and the latest version to the apps of a
+// list.
+func fillAppsVersions(v *base.VirtualSpace, c *space.Space, opts *AppsListOptions, res []*App) error {
+	var err error
+
 	// We are doing a lot of requests to cache or couchdb to fetch the data
 	// about the versions of each app. It would be better to avoid the n+1
 	// requests, but as a quick hack to limit the latency, we are using a pool
@@ -805,11 +876,7 @@ func GetAppsList(v *base.VirtualSpace, c *space.Space, opts *AppsListOptions) (i
 		stop <- struct{}{}
 	}
 
-	if err != nil {
-		return 0, nil, err
-	}
-
-	return cursor, res, nil
+	return err
 }
 
 type appVersionEntry struct {
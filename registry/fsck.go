@@ -0,0 +1,477 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cozy/cozy-apps-registry/asset"
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/go-kivik/kivik/v3"
+)
+
+// universalLinkFolder is the folder of the storage with the files for the
+// universal links, see web/universal_links.go.
+const universalLinkFolder = "universallink"
+
+// FsckKind is the kind of an inconsistency found by Fsck.
+type FsckKind string
+
+const (
+	// FsckMissingTarball is a version whose tarball is not in the storage.
+	FsckMissingTarball FsckKind = "missing-tarball"
+	// FsckMissingAsset is a reference to an asset whose document or content
+	// is missing.
+	FsckMissingAsset FsckKind = "missing-asset"
+	// FsckOrphanObject is a file in the storage that is not used by any
+	// version or asset.
+	FsckOrphanObject FsckKind = "orphan-object"
+	// FsckOrphanAsset is an asset that is not used anymore.
+	FsckOrphanAsset FsckKind = "orphan-asset"
+	// FsckStaleAssetUsage is an asset still used, but also by versions that
+	// don't exist anymore.
+	FsckStaleAssetUsage FsckKind = "stale-asset-usage"
+)
+
+// FsckProblem is an inconsistency between the CouchDB databases and the
+// storage.
+type FsckProblem struct {
+	Kind     FsckKind
+	Prefix   base.Prefix
+	Name     string
+	Details  string
+	Repaired bool
+}
+
+func (p *FsckProblem) String() string {
+	s := fmt.Sprintf("%s %s/%s", p.Kind, p.Prefix, p.Name)
+	if p.Details != "" {
+		s += ": " + p.Details
+	}
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// FsckOptions are the options for Fsck.
+type FsckOptions struct {
+	// Repair deletes the orphan files and assets, and fetches again the
+	// missing tarballs from the URL where their version has been published.
+	// The tarballs that have been uploaded can't be fetched again.
+	Repair bool
+	// Problems, if not nil, is called for each problem when it is found.
+	Problems func(*FsckProblem)
+}
+
+// Fsck cross-checks the versions of the spaces and virtual spaces, the
+// global assets database, and the content of the storage. It returns the
+// problems found, and repairs them if asked. The repairs should be made when
+// no version is being published, as a tarball is saved in the storage a bit
+// before its version is created.
+func Fsck(opts FsckOptions) ([]*FsckProblem, error) {
+	f := &fsck{
+		opts:    opts,
+		sources: make(map[string]bool),
+		refs:    make(map[string][]string),
+	}
+
+	names := make([]string, 0, len(space.Spaces))
+	for name := range space.Spaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := f.checkSpace(space.Spaces[name]); err != nil {
+			return f.problems, err
+		}
+	}
+
+	virtuals := make([]string, 0, len(base.Config.VirtualSpaces))
+	for name := range base.Config.VirtualSpaces {
+		virtuals = append(virtuals, name)
+	}
+	sort.Strings(virtuals)
+	for _, name := range virtuals {
+		if err := f.checkVirtualSpace(base.Config.VirtualSpaces[name]); err != nil {
+			return f.problems, err
+		}
+	}
+
+	err := f.checkAssets()
+	return f.problems, err
+}
+
+type fsck struct {
+	opts     FsckOptions
+	problems []*FsckProblem
+	// sources are the values of UsedBy for the assets that are still valid
+	sources map[string]bool
+	// refs are the places where the assets are referenced, by shasum
+	refs map[string][]string
+}
+
+func (f *fsck) report(p *FsckProblem) {
+	f.problems = append(f.problems, p)
+	if f.opts.Problems != nil {
+		f.opts.Problems(p)
+	}
+}
+
+func (f *fsck) addRefs(source string, refs map[string]string) {
+	for filename, shasum := range refs {
+		f.refs[shasum] = append(f.refs[shasum], path.Join(source, filename))
+	}
+}
+
+func (f *fsck) checkSpace(c *space.Space) error {
+	prefix := c.GetPrefix()
+	var versions []*Version
+	for _, db := range []*kivik.DB{c.VersDB(), c.PendingVersDB()} {
+		err := fsckAllDocs(db, func(rows *kivik.Rows) error {
+			var ver *Version
+			if err := rows.ScanDoc(&ver); err != nil {
+				return err
+			}
+			versions = append(versions, ver)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	objects, err := fsckObjects(prefix)
+	if err != nil {
+		return err
+	}
+
+	// The files of a version are in its directory: the tarball, and the
+	// assets saved before the global assets database
+	dirs := make(map[string]bool)
+	for _, ver := range versions {
+		dir := path.Join(ver.Slug, ver.Version)
+		dirs[dir] = true
+		source := asset.ComputeSource(prefix, ver.Slug, ver.Version)
+		f.sources[source] = true
+		f.addRefs(source, ver.AttachmentReferences)
+
+		if ver.URL == "" {
+			continue
+		}
+		filename, err := tarballFilename(ver)
+		if err != nil {
+			return err
+		}
+		name := path.Join(dir, filename)
+		if objects[name] {
+			continue
+		}
+		p := &FsckProblem{Kind: FsckMissingTarball, Prefix: prefix, Name: name}
+		if f.opts.Repair {
+			if ver.SourceURL == "" {
+				p.Details = "no source URL to fetch it again"
+			} else if err := refetchTarball(c, ver, name); err != nil {
+				p.Details = fmt.Sprintf("cannot fetch %s: %s", ver.SourceURL, err)
+			} else {
+				p.Repaired = true
+			}
+		}
+		f.report(p)
+	}
+
+	for _, name := range sortedKeys(objects) {
+		parts := strings.SplitN(name, "/", 3)
+		if len(parts) == 3 && dirs[parts[0]+"/"+parts[1]] {
+			continue
+		}
+		// The universal link files are uploaded by the administrators, and
+		// are not related to a version
+		if parts[0] == universalLinkFolder {
+			continue
+		}
+		f.reportOrphanObject(prefix, name)
+	}
+	return nil
+}
+
+func (f *fsck) checkVirtualSpace(v base.VirtualSpace) error {
+	prefix := base.Prefix(v.Name)
+	tarballs := make(map[string]bool)
+	err := fsckAllDocs(v.VersionDB(), func(rows *kivik.Rows) error {
+		var ver *Version
+		if err := rows.ScanDoc(&ver); err != nil {
+			return err
+		}
+		refs := make(map[string]string)
+		for filename, shasum := range ver.AttachmentReferences {
+			if filename == "tarball" {
+				tarballs[shasum] = true
+			} else {
+				refs[filename] = shasum
+			}
+		}
+		f.addRefs(path.Join(v.Name, ver.Slug, ver.Version), refs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The icons of the overwrites are assets used by the whole application
+	err = fsckAllDocs(v.OverrideDb(), func(rows *kivik.Rows) error {
+		var overwrite map[string]interface{}
+		if err := rows.ScanDoc(&overwrite); err != nil {
+			return err
+		}
+		if icon, ok := overwrite["icon"].(string); ok {
+			source := asset.ComputeSource(prefix, rows.ID(), "*")
+			f.sources[source] = true
+			f.addRefs(source, map[string]string{"icon": icon})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	objects, err := fsckObjects(prefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(tarballs) {
+		if !objects[name] {
+			f.report(&FsckProblem{
+				Kind:    FsckMissingTarball,
+				Prefix:  prefix,
+				Name:    name,
+				Details: "overwritten tarball",
+			})
+		}
+	}
+	for _, name := range sortedKeys(objects) {
+		if !tarballs[name] && !strings.HasPrefix(name, universalLinkFolder+"/") {
+			f.reportOrphanObject(prefix, name)
+		}
+	}
+	return nil
+}
+
+func (f *fsck) checkAssets() error {
+	prefix := asset.AssetContainerName
+	assets := make(map[string]*base.Asset)
+	err := fsckAllDocs(base.GlobalAssetStore.GetDB(), func(rows *kivik.Rows) error {
+		var doc *base.Asset
+		if err := rows.ScanDoc(&doc); err != nil {
+			return err
+		}
+		assets[doc.ID] = doc
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	objects, err := fsckObjects(prefix)
+	if err != nil {
+		return err
+	}
+
+	shasums := make([]string, 0, len(f.refs))
+	for shasum := range f.refs {
+		shasums = append(shasums, shasum)
+	}
+	sort.Strings(shasums)
+	for _, shasum := range shasums {
+		if assets[shasum] != nil && objects[shasum] {
+			continue
+		}
+		f.report(&FsckProblem{
+			Kind:    FsckMissingAsset,
+			Prefix:  prefix,
+			Name:    shasum,
+			Details: "used by " + strings.Join(f.refs[shasum], ", "),
+		})
+	}
+
+	shasums = shasums[:0]
+	for shasum := range assets {
+		shasums = append(shasums, shasum)
+	}
+	sort.Strings(shasums)
+	for _, shasum := range shasums {
+		doc := assets[shasum]
+		var stale []string
+		used := false
+		for _, source := range doc.UsedBy {
+			if f.sources[source] || !f.isCheckedSource(source) {
+				used = true
+			} else {
+				stale = append(stale, source)
+			}
+		}
+		if used && len(stale) == 0 {
+			if !objects[shasum] && len(f.refs[shasum]) == 0 {
+				f.report(&FsckProblem{Kind: FsckMissingAsset, Prefix: prefix, Name: shasum})
+			}
+			continue
+		}
+
+		p := &FsckProblem{Kind: FsckStaleAssetUsage, Prefix: prefix, Name: shasum}
+		if !used {
+			p.Kind = FsckOrphanAsset
+		}
+		if len(stale) > 0 {
+			p.Details = "used by " + strings.Join(stale, ", ")
+		}
+		if f.opts.Repair {
+			p.Repaired = true
+			// The store deletes the asset when it is not used anymore
+			if len(stale) == 0 {
+				stale = []string{""}
+			}
+			for _, source := range stale {
+				if err := base.GlobalAssetStore.Remove(shasum, source); err != nil {
+					p.Details = fmt.Sprintf("cannot remove: %s", err)
+					p.Repaired = false
+					break
+				}
+			}
+		}
+		f.report(p)
+	}
+
+	for _, name := range sortedKeys(objects) {
+		if assets[name] == nil {
+			f.reportOrphanObject(prefix, name)
+		}
+	}
+	return nil
+}
+
+// isCheckedSource returns true if the asset source is for a space or virtual
+// space that has been checked. The assets used by other spaces are kept, as
+// their versions are not known.
+func (f *fsck) isCheckedSource(source string) bool {
+	parts := strings.Split(source, "/")
+	switch len(parts) {
+	case 2:
+		_, ok := space.Spaces[""]
+		return ok
+	case 3:
+		if _, ok := space.Spaces[parts[0]]; ok {
+			return true
+		}
+		_, ok := base.Config.VirtualSpaces[parts[0]]
+		return ok
+	}
+	return false
+}
+
+func (f *fsck) reportOrphanObject(prefix base.Prefix, name string) {
+	p := &FsckProblem{Kind: FsckOrphanObject, Prefix: prefix, Name: name}
+	if f.opts.Repair {
+		if err := base.Storage.Remove(prefix, name); err != nil {
+			p.Details = fmt.Sprintf("cannot remove: %s", err)
+		} else {
+			p.Repaired = true
+		}
+	}
+	f.report(p)
+}
+
+// refetchTarball downloads the tarball of a version from the URL where it
+// has been published, and saves it in the storage if its sha256 matches the
+// one of the version.
+func refetchTarball(c *space.Space, ver *Version, name string) error {
+	prefix := c.GetPrefix()
+	maxSize := base.Config.MaxApplicationSize(prefix)
+	spooled, contentType, err := downloadRequest(ver.SourceURL, ver.Sha256, maxSize)
+	if err != nil {
+		return err
+	}
+	defer spooled.Close()
+	if contentType == "" || contentType == "application/octet-stream" {
+		if detected := spooled.detectContentType(); detected != "" {
+			contentType = detected
+		}
+	}
+	return base.Storage.Create(prefix, name, contentType, spooled.file)
+}
+
+func tarballFilename(ver *Version) (string, error) {
+	u, err := url.Parse(ver.URL)
+	if err != nil {
+		return "", err
+	}
+	return path.Base(u.Path), nil
+}
+
+// fsckAllDocs calls fn for each document of the database, except the design
+// docs. A database that doesn't exist has no documents.
+func fsckAllDocs(db *kivik.DB, fn func(rows *kivik.Rows) error) error {
+	startKey, perPage := "", 1000
+	for {
+		rows, err := db.AllDocs(context.Background(), map[string]interface{}{
+			"include_docs": true,
+			"limit":        perPage + 1,
+			"start_key":    startKey,
+		})
+		if err != nil {
+			if kivik.StatusCode(err) == http.StatusNotFound {
+				return nil
+			}
+			return err
+		}
+
+		startKey = ""
+		i := 0
+		for rows.Next() {
+			if i == perPage {
+				startKey = rows.ID()
+				break
+			}
+			i++
+			if strings.HasPrefix(rows.ID(), "_design") {
+				continue
+			}
+			if err := fn(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+		if startKey == "" {
+			return nil
+		}
+	}
+}
+
+// fsckObjects returns the names of the files of a prefix in the storage.
+func fsckObjects(prefix base.Prefix) (map[string]bool, error) {
+	objects := make(map[string]bool)
+	err := base.Storage.Walk(prefix, func(name, _ string) error {
+		objects[name] = true
+		return nil
+	})
+	if e, ok := err.(base.Error); ok && e.Code == http.StatusNotFound {
+		err = nil
+	}
+	return objects, err
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/metrics"
 	"github.com/cozy/cozy-apps-registry/space"
 )
 
@@ -20,7 +21,17 @@ const (
 )
 
 // CleanOldVersions removes a specific app version of a space
-func CleanOldVersions(space *space.Space, appSlug, channel string, params base.CleanParameters, run RunType) error {
+func CleanOldVersions(space *space.Space, appSlug, channel string, params base.CleanParameters, run RunType) (err error) {
+	if run == RealRun {
+		defer func() {
+			result := "success"
+			if err != nil {
+				result = "failure"
+			}
+			metrics.CleanJobs.Inc(space.GetPrefix().String(), result)
+		}()
+	}
+
 	// Finding last versions of the app
 	versionsToKeepFromN, err := FindLastNVersions(space, appSlug, channel, params.NbMajor, params.NbMinor)
 	if err != nil {
@@ -78,6 +89,7 @@ func CleanOldVersions(space *space.Space, appSlug, channel string, params base.C
 			if err != nil {
 				return err
 			}
+			metrics.CleanedVersions.Inc(space.GetPrefix().String())
 		}
 	}
 
@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/go-kivik/kivik/v3"
+)
+
+var (
+	ErrPromotionChannel = errshttp.NewError(http.StatusBadRequest, "A version can only be promoted to a more stable channel")
+	ErrPromotionYanked  = errshttp.NewError(http.StatusConflict, "A yanked version cannot be promoted")
+)
+
+// promotedVersion returns the version number of a version promoted to the
+// given channel. When it is not given, the version for the stable channel is
+// the version without its pre-release part, e.g. 1.2.0 for 1.2.0-beta.3.
+func promotedVersion(version, target string, channel Channel) (string, error) {
+	if target == "" {
+		if channel != Stable {
+			return "", errshttp.NewError(http.StatusBadRequest,
+				"The version number is required to promote a version to the %s channel", ChannelToStr(channel))
+		}
+		v := splitVersion(version)
+		target = strings.Join(v[:], ".")
+	}
+	if !validVersionReg.MatchString(target) || GetVersionChannel(target) != channel {
+		return "", errshttp.NewError(http.StatusBadRequest,
+			"The version %s is not a valid version for the %s channel", target, ChannelToStr(channel))
+	}
+	return target, nil
+}
+
+// promotedManifest returns the manifest of a version promoted to the target
+// version number. The manifest in the tarball keeps the number of the
+// original version, as the tarball is not modified.
+func promotedManifest(manifest json.RawMessage, target string) (json.RawMessage, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(manifest, &doc); err != nil {
+		return nil, err
+	}
+	doc["version"] = target
+	return json.Marshal(doc)
+}
+
+// PromoteVersion publishes a version in a more stable channel, without
+// downloading its tarball again: the new version uses the same tarball and
+// assets, and is linked to the original version with its promoted_from
+// field. The target version number is optional for the stable channel.
+func PromoteVersion(c *space.Space, appSlug, version string, channel Channel, target string) (*Version, error) {
+	origin, err := FindPublishedVersion(c, appSlug, version)
+	if err != nil {
+		return nil, err
+	}
+	if origin.Yanked != nil {
+		return nil, ErrPromotionYanked
+	}
+	if channel >= GetVersionChannel(origin.Version) {
+		return nil, ErrPromotionChannel
+	}
+	if target, err = promotedVersion(origin.Version, target, channel); err != nil {
+		return nil, err
+	}
+	app, err := findApp(c, appSlug)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = FindVersion(c, appSlug, target); err == nil {
+		return nil, ErrVersionAlreadyExists
+	} else if err != ErrVersionNotFound {
+		return nil, err
+	}
+
+	filename := path.Base(origin.URL)
+	if origin.URL == "" || filename == "." || filename == "/" {
+		return nil, errshttp.NewError(http.StatusUnprocessableEntity,
+			"The tarball of the version %s is unknown", origin.Version)
+	}
+	manifest, err := promotedManifest(origin.Manifest, target)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := c.GetPrefix()
+	tarball, err := base.Storage.Open(prefix, path.Join(appSlug, origin.Version, filename))
+	if err != nil {
+		return nil, err
+	}
+	defer tarball.Close()
+	err = base.Storage.Create(prefix, path.Join(appSlug, target, filename), tarball.ContentType, tarball)
+	if err != nil {
+		return nil, err
+	}
+
+	// The assets are already in the global asset store, they only need to
+	// be referenced by the new version too
+	attachments := make([]*kivik.Attachment, 0, len(origin.AttachmentReferences))
+	defer func() {
+		for _, att := range attachments {
+			att.Content.Close()
+		}
+	}()
+	for name, shasum := range origin.AttachmentReferences {
+		asset, err := base.GlobalAssetStore.Open(shasum)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, &kivik.Attachment{
+			Content:     asset,
+			Size:        asset.Size,
+			Filename:    name,
+			ContentType: asset.ContentType,
+		})
+	}
+
+	ver := &Version{
+		ID:           getVersionID(appSlug, target),
+		Slug:         appSlug,
+		Version:      target,
+		Channel:      ChannelToStr(channel),
+		PromotedFrom: origin.Version,
+		Manifest:     manifest,
+		CreatedAt:    time.Now().UTC(),
+		URL:          strings.Replace(origin.URL, "/"+origin.Version+"/tarball/", "/"+target+"/tarball/", 1),
+		Size:         origin.Size,
+		Sha256:       origin.Sha256,
+		TarPrefix:    origin.TarPrefix,
+		Signature:    origin.Signature,
+	}
+	if err = CreateReleaseVersion(c, ver, attachments, app, true); err != nil {
+		return nil, err
+	}
+	return ver, nil
+}
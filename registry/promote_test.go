@@ -0,0 +1,34 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromotedVersion(t *testing.T) {
+	v, err := promotedVersion("1.2.0-beta.3", "", Stable)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.0", v)
+
+	v, err = promotedVersion("1.2.0-dev.abcdef", "", Stable)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.0", v)
+
+	v, err = promotedVersion("1.2.0-dev.abcdef", "1.2.0-beta.1", Beta)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.0-beta.1", v)
+
+	_, err = promotedVersion("1.2.0-dev.abcdef", "", Beta)
+	assert.Error(t, err)
+	_, err = promotedVersion("1.2.0-beta.3", "1.2.0-beta.4", Stable)
+	assert.Error(t, err)
+}
+
+func TestPromotedManifest(t *testing.T) {
+	manifest := json.RawMessage(`{"slug": "drive", "version": "1.2.0-beta.3", "name": "Drive"}`)
+	promoted, err := promotedManifest(manifest, "1.2.0")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"slug": "drive", "version": "1.2.0", "name": "Drive"}`, string(promoted))
+}
@@ -5,7 +5,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -25,16 +25,15 @@ import (
 	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/schema"
 	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/cozy/cozy-apps-registry/webhook"
 	_ "github.com/go-kivik/couchdb/v3" // for couchdb
 	"github.com/go-kivik/kivik/v3"
-	"github.com/h2non/filetype"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/sirupsen/logrus"
 )
 
-const maxApplicationSize = 20 * 1024 * 1024 // 20 Mo
-
 var (
 	validSlugReg    = regexp.MustCompile(`^[a-z0-9\-]*$`)
 	validVersionReg = regexp.MustCompile(`^(0|[1-9][0-9]{0,4})\.(0|[1-9][0-9]{0,4})\.(0|[1-9][0-9]{0,4})(-dev\.[a-f0-9]{1,40}|-beta.(0|[1-9][0-9]{0,4}))?$`)
@@ -54,6 +53,10 @@ var (
 	ErrVersionNotFound      = errshttp.NewError(http.StatusNotFound, "Version was not found")
 	ErrVersionInvalid       = errshttp.NewError(http.StatusBadRequest, "Invalid version value")
 	ErrChannelInvalid       = errshttp.NewError(http.StatusBadRequest, `Invalid version channel: should be "stable", "beta" or "dev"`)
+
+	ErrSignatureMissing    = errshttp.NewError(http.StatusUnprocessableEntity, "Version must be signed: the editor has registered public keys")
+	ErrSignatureInvalid    = errshttp.NewError(http.StatusUnprocessableEntity, "Signature of the version is invalid")
+	ErrSignatureWithoutKey = errshttp.NewError(http.StatusUnprocessableEntity, "Version is signed, but the editor has no registered public key")
 )
 
 var versionClient = http.Client{
@@ -84,9 +87,22 @@ type App struct {
 	DataUsageCommitment   string `json:"data_usage_commitment"`
 	DataUsageCommitmentBy string `json:"data_usage_commitment_by"`
 
+	// Fields copied from the manifest of the latest version, to be used as
+	// filters. The label is also stored, but it is calculated again with the
+	// latest version of the requested channel for the responses.
+	Categories     []string `json:"categories,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	Locales        []string `json:"locales,omitempty"`
+	Permissions    []string `json:"permissions,omitempty"`
+	RemoteDoctypes bool     `json:"remote_doctypes,omitempty"`
+	Label          Label    `json:"label"`
+
+	// Downloads is the total number of downloads of the tarballs of the
+	// application, updated periodically by FlushAppDownloads.
+	Downloads int64 `json:"downloads"`
+
 	// Calculated fields, not present in the database
 	Versions      *AppVersions `json:"versions,omitempty"`
-	Label         Label        `json:"label"`
 	LatestVersion *Version     `json:"latest_version,omitempty"`
 }
 
@@ -129,8 +145,17 @@ type VersionOptions struct {
 	Icon        string          `json:"icon"`
 	Partnership Partnership     `json:"partnership"`
 	Screenshots []string        `json:"screenshots"`
+	// Signature is the base64-encoded Ed25519 signature of the raw sha256
+	// digest of the tarball.
+	Signature string `json:"signature"`
+	// Rollout is the percentage of instances that are served the version
+	// when it is the latest stable version. It defaults to 100.
+	Rollout     *int `json:"rollout"`
 	SpacePrefix base.Prefix
 	RegistryURL *url.URL
+	// Editor is the editor of the application, whose public keys are used
+	// to verify the signature.
+	Editor *auth.Editor `json:"-"`
 }
 
 type Version struct {
@@ -145,9 +170,24 @@ type Version struct {
 	Manifest             json.RawMessage   `json:"manifest"`
 	CreatedAt            time.Time         `json:"created_at"`
 	URL                  string            `json:"url"`
-	Size                 int64             `json:"size,string"`
-	Sha256               string            `json:"sha256"`
-	TarPrefix            string            `json:"tar_prefix"`
+	// SourceURL is the URL from which the tarball has been downloaded at
+	// publication, empty if it has been uploaded.
+	SourceURL string   `json:"source_url,omitempty"`
+	Size      int64    `json:"size,string"`
+	Sha256    string   `json:"sha256"`
+	TarPrefix string   `json:"tar_prefix"`
+	Signature string   `json:"signature,omitempty"`
+	Rollout   *Rollout `json:"rollout,omitempty"`
+	Yanked    *Yank    `json:"yanked,omitempty"`
+	// Channel is set on the promoted versions, and PromotedFrom is the version
+	// from which they have been promoted.
+	Channel      string `json:"channel,omitempty"`
+	PromotedFrom string `json:"promoted_from,omitempty"`
+	// ReviewComments, Rejection and Checks are only used for the pending
+	// versions
+	ReviewComments []*ReviewComment `json:"review_comments,omitempty"`
+	Rejection      *Rejection       `json:"rejection,omitempty"`
+	Checks         *CheckReport     `json:"checks,omitempty"`
 }
 
 type Partnership struct {
@@ -181,7 +221,7 @@ type Tarball struct {
 	TarPrefix       string
 	ContentType     string
 	AppType         string
-	Content         []byte
+	Content         io.ReadSeeker
 	URL             string
 	Size            int64
 }
@@ -222,6 +262,32 @@ func IsValidVersion(ver *VersionOptions) error {
 	if h, err := hex.DecodeString(ver.Sha256); err != nil || len(h) != 32 {
 		fields = append(fields, "sha256")
 	}
+	if isValidRollout(ver.Version, ver.Rollout) != nil {
+		fields = append(fields, "rollout")
+	}
+	return invalidVersionFields(fields)
+}
+
+// IsValidUploadedVersion is like IsValidVersion, but for a version where the
+// tarball is sent in the request: there is no URL, and the sha256 is
+// optional.
+func IsValidUploadedVersion(ver *VersionOptions) error {
+	var fields []string
+	if !validVersionReg.MatchString(ver.Version) {
+		fields = append(fields, "version")
+	}
+	if ver.Sha256 != "" {
+		if h, err := hex.DecodeString(ver.Sha256); err != nil || len(h) != 32 {
+			fields = append(fields, "sha256")
+		}
+	}
+	if isValidRollout(ver.Version, ver.Rollout) != nil {
+		fields = append(fields, "rollout")
+	}
+	return invalidVersionFields(fields)
+}
+
+func invalidVersionFields(fields []string) error {
 	if len(fields) > 0 {
 		return fmt.Errorf("Invalid version: "+
 			"the following fields are missing or erroneous: %s", strings.Join(fields, ", "))
@@ -260,6 +326,7 @@ func CreateApp(c *space.Space, opts *AppOptions, editor *auth.Editor) (*App, err
 	app.Editor = editor.Name()
 	app.CreatedAt = now
 	app.DataUsageCommitment, app.DataUsageCommitmentBy = defaultDataUserCommitment(app, opts)
+	app.Label = calculateAppLabel(app, nil)
 	_, app.Rev, err = db.CreateDoc(context.Background(), app)
 	if err != nil {
 		return nil, err
@@ -269,10 +336,19 @@ func CreateApp(c *space.Space, opts *AppOptions, editor *auth.Editor) (*App, err
 		Beta:   make([]string, 0),
 		Dev:    make([]string, 0),
 	}
-	app.Label = calculateAppLabel(app, nil)
+	reindexApp(c, app.Slug)
 	return app, nil
 }
 
+// Properties returns the properties of the application that can be changed
+// with ModifyApp.
+func (app *App) Properties() map[string]string {
+	return map[string]string{
+		"data_usage_commitment":    app.DataUsageCommitment,
+		"data_usage_commitment_by": app.DataUsageCommitmentBy,
+	}
+}
+
 func ModifyApp(c *space.Space, appSlug string, opts AppOptions) (*App, error) {
 	app, err := findApp(c, appSlug)
 	if err != nil {
@@ -284,6 +360,11 @@ func ModifyApp(c *space.Space, appSlug string, opts AppOptions) (*App, error) {
 	if opts.DataUsageCommitmentBy != nil {
 		app.DataUsageCommitmentBy = *opts.DataUsageCommitmentBy
 	}
+	ver, err := facetsVersion(c, appSlug)
+	if err != nil {
+		return nil, err
+	}
+	app.Label = calculateAppLabel(app, ver)
 	_, err = c.AppsDB().Put(context.Background(), app.ID, app)
 	if err != nil {
 		return nil, err
@@ -301,8 +382,11 @@ func ActivateMaintenanceApp(c *space.Space, appSlug string, opts MaintenanceOpti
 	}
 	app.MaintenanceActivated = true
 	app.MaintenanceOptions = &opts
-	_, err = c.AppsDB().Put(context.Background(), app.ID, app)
-	return err
+	if _, err = c.AppsDB().Put(context.Background(), app.ID, app); err != nil {
+		return err
+	}
+	sendAppEvent(webhook.AppMaintenanceActivated, c, appSlug, app.MaintenanceOptions)
+	return nil
 }
 
 func DeactivateMaintenanceApp(c *space.Space, appSlug string) error {
@@ -312,14 +396,43 @@ func DeactivateMaintenanceApp(c *space.Space, appSlug string) error {
 	}
 	app.MaintenanceActivated = false
 	app.MaintenanceOptions = nil
-	_, err = c.AppsDB().Put(context.Background(), app.ID, app)
-	return err
+	if _, err = c.AppsDB().Put(context.Background(), app.ID, app); err != nil {
+		return err
+	}
+	sendAppEvent(webhook.AppMaintenanceDeactivated, c, appSlug, nil)
+	return nil
 }
 
 func DownloadVersion(opts *VersionOptions) (*Version, []*kivik.Attachment, error) {
 	return downloadVersion(opts)
 }
 
+// UploadVersion is like DownloadVersion, but the tarball is sent by the
+// client in the request instead of being downloaded from an URL. The sha256
+// of the options is optional: if it is given, it is checked against the
+// content, else it is computed.
+func UploadVersion(opts *VersionOptions, filename string, content io.Reader, contentType string) (*Version, []*kivik.Attachment, error) {
+	maxSize := base.Config.MaxApplicationSize(opts.SpacePrefix)
+	spooled, err := spoolTarball(content, maxSize, opts.Sha256)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer spooled.Close()
+	opts.Sha256 = spooled.sha256
+
+	if contentType == "" || contentType == "application/octet-stream" {
+		if detected := spooled.detectContentType(); detected != "" {
+			contentType = detected
+		}
+	}
+
+	tarball, err := readTarball(spooled, contentType, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newVersionFromTarball(opts, tarball, filename)
+}
+
 func createVersion(c *space.Space, db *kivik.DB, ver *Version, attachments []*kivik.Attachment, app *App, ensureVersion bool) (err error) {
 	if ver.Slug != app.Slug {
 		return ErrVersionSlugMismatch
@@ -383,11 +496,22 @@ func createVersion(c *space.Space, db *kivik.DB, ver *Version, attachments []*ki
 	return err
 }
 
+// CreatePendingVersion saves a version that must be approved by a reviewer
+// before being published, with the report of the automated checks.
 func CreatePendingVersion(c *space.Space, ver *Version, attachments []*kivik.Attachment, app *App) error {
+	ver.Checks = CheckPendingVersion(c, ver, app)
 	return createVersion(c, c.PendingVersDB(), ver, attachments, app, true)
 }
 
-func CreateReleaseVersion(c *space.Space, ver *Version, attachments []*kivik.Attachment, app *App, ensureVersion bool) (err error) {
+func CreateReleaseVersion(c *space.Space, ver *Version, attachments []*kivik.Attachment, app *App, ensureVersion bool) error {
+	if err := createReleaseVersion(c, ver, attachments, app, ensureVersion); err != nil {
+		return err
+	}
+	sendVersionEvent(webhook.VersionPublished, c, ver)
+	return nil
+}
+
+func createReleaseVersion(c *space.Space, ver *Version, attachments []*kivik.Attachment, app *App, ensureVersion bool) (err error) {
 	if err := createVersion(c, c.VersDB(), ver, attachments, app, ensureVersion); err != nil {
 		return err
 	}
@@ -403,7 +527,9 @@ func CreateReleaseVersion(c *space.Space, ver *Version, attachments []*kivik.Att
 			}
 		}
 	}
-	return err
+	updateAppFacets(c, ver.Slug)
+	reindexApp(c, ver.Slug)
+	return nil
 }
 
 func (version *Version) Clone() *Version {
@@ -412,20 +538,34 @@ func (version *Version) Clone() *Version {
 	for k, v := range version.AttachmentReferences {
 		clone.AttachmentReferences[k] = v
 	}
+	if version.Rollout != nil {
+		rollout := *version.Rollout
+		clone.Rollout = &rollout
+	}
 	return &clone
 }
 
-func ApprovePendingVersion(c *space.Space, pending *Version, app *App) (*Version, error) {
+// ApprovePendingVersion publishes a pending version, and records the
+// approval in the review history of the application.
+func ApprovePendingVersion(c *space.Space, pending *Version, app *App, reviewer string) (*Version, error) {
 	db := c.PendingVersDB()
 	release := pending.Clone()
 	release.Rev = ""
+	release.ReviewComments = nil
+	release.Rejection = nil
+	release.Checks = nil
+	if release.Rollout != nil {
+		// The rollout starts when the version is published
+		release.Rollout.StartedAt = time.Now().UTC()
+		release.Rollout.UpdatedAt = release.Rollout.StartedAt
+	}
 
 	// Attachments are already created, skipping them
 	var attachments = []*kivik.Attachment{}
 
 	// We need to skip version check, because we don't drop pending
 	// version until the end to avoid data loss in case of error
-	err := CreateReleaseVersion(c, release, attachments, app, false)
+	err := createReleaseVersion(c, release, attachments, app, false)
 	if err != nil {
 		return nil, err
 	}
@@ -434,6 +574,10 @@ func ApprovePendingVersion(c *space.Space, pending *Version, app *App) (*Version
 	if _, err := db.Delete(context.Background(), pending.ID, pending.Rev); err != nil {
 		return nil, err
 	}
+	if err := saveReviewEvent(c, newReviewEvent(pending, ReviewApproved, reviewer)); err != nil {
+		return nil, err
+	}
+	sendVersionEvent(webhook.VersionApproved, c, release)
 
 	// Get version channel
 	channel := GetVersionChannel(release.Version)
@@ -461,27 +605,27 @@ func ApprovePendingVersion(c *space.Space, pending *Version, app *App) (*Version
 	return release, nil
 }
 
-func downloadRequest(rawURL string, shasum string) (reader *bytes.Reader, contentType string, err error) {
+// downloadRequest downloads the tarball from the given URL to a temporary
+// file, and checks its size and checksum.
+func downloadRequest(rawURL string, shasum string, maxSize int64) (spooled *spooledTarball, contentType string, err error) {
 	url, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, "", err
 	}
 
-	buf := new(bytes.Buffer)
-
 	if url.Scheme == "file" {
 		f, err := os.Open(url.EscapedPath())
 		if err != nil {
 			return nil, "", err
 		}
-		_, err = io.Copy(buf, io.LimitReader(f, maxApplicationSize))
+		defer f.Close()
+		spooled, err = spoolTarball(f, maxSize, shasum)
 		if err != nil {
 			return nil, "", err
 		}
 
 		// Find the mimetype
-		kind, _ := filetype.Match(buf.Bytes())
-		contentType = kind.MIME.Value
+		contentType = spooled.detectContentType()
 	} else {
 		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
 		if err != nil {
@@ -505,28 +649,20 @@ func downloadRequest(rawURL string, shasum string) (reader *bytes.Reader, conten
 			return nil, "", err
 		}
 
-		_, err = io.Copy(buf, io.LimitReader(resp.Body, maxApplicationSize))
+		spooled, err = spoolTarball(resp.Body, maxSize, shasum)
 		if err != nil {
-			err = errshttp.NewError(http.StatusUnprocessableEntity,
-				"Could not reach version on specified url %s: %s",
-				rawURL, err)
+			if _, ok := err.(*errshttp.Error); !ok {
+				err = errshttp.NewError(http.StatusUnprocessableEntity,
+					"Could not reach version on specified url %s: %s",
+					rawURL, err)
+			}
 			return nil, "", err
 		}
 
 		contentType = resp.Header.Get("content-type")
 	}
-	h := sha256.New()
-	if _, err = h.Write(buf.Bytes()); err != nil {
-		return
-	}
-	e, _ := hex.DecodeString(shasum)
-	if !bytes.Equal(e, h.Sum(nil)) {
-		err = errshttp.NewError(http.StatusUnprocessableEntity,
-			"Checksum does not match the calculated one (expecting %q, got %q)", shasum, hex.EncodeToString(h.Sum(nil)))
-		return
-	}
 
-	return bytes.NewReader(buf.Bytes()), contentType, nil
+	return spooled, contentType, nil
 }
 
 func tarReader(reader io.Reader, contentType string) (*tar.Reader, error) {
@@ -608,39 +744,47 @@ func (t *Tarball) CheckSlug() (bool, error) {
 	return true, nil
 }
 
-func downloadTarball(opts *VersionOptions, url string) (*Tarball, error) {
-	var buf *bytes.Reader
+func downloadTarball(opts *VersionOptions, url string) (*spooledTarball, *Tarball, error) {
+	var spooled *spooledTarball
 	var err error
 	var contentType string
 
 	// Downloading the file
+	maxSize := base.Config.MaxApplicationSize(opts.SpacePrefix)
 	tryCount := 0
 	for {
 		tryCount++
-		buf, contentType, err = downloadRequest(url, opts.Sha256)
+		spooled, contentType, err = downloadRequest(url, opts.Sha256, maxSize)
 		if err == nil {
 			break
 		} else if tryCount <= 3 {
 			continue
 		} else {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
-	// Reader for filesize
-	counter := &bytesCounter{}
-	var reader io.Reader = buf
-	reader = io.TeeReader(reader, counter)
+	tarball, err := readTarball(spooled, contentType, url)
+	if err != nil {
+		spooled.Close()
+		return nil, nil, err
+	}
+	return spooled, tarball, nil
+}
 
+// readTarball reads the content of a spooled tarball and adds the metadata
+// about the file (content-type, size) to the returned Tarball.
+func readTarball(spooled *spooledTarball, contentType, url string) (*Tarball, error) {
 	// Reading the tarball content
-	tarball, err := ReadTarballVersion(reader, contentType, url)
+	tarball, err := ReadTarballVersion(spooled.file, contentType, url)
 	if err != nil {
 		return nil, err
 	}
 
 	// Adding metadata to the tarball struct
+	tarball.Content = spooled.file
 	tarball.ContentType = contentType
-	tarball.Size = counter.Written()
+	tarball.Size = spooled.size
 
 	if !tarball.HasPrefix {
 		tarball.TarPrefix = ""
@@ -650,13 +794,22 @@ func downloadTarball(opts *VersionOptions, url string) (*Tarball, error) {
 }
 
 func downloadVersion(opts *VersionOptions) (*Version, []*kivik.Attachment, error) {
-	var err *multierror.Error
 	url := opts.URL
 
-	tarball, errd := downloadTarball(opts, url)
-	if errd != nil {
-		return nil, nil, errd
+	spooled, tarball, err := downloadTarball(opts, url)
+	if err != nil {
+		return nil, nil, err
 	}
+	defer spooled.Close()
+
+	return newVersionFromTarball(opts, tarball, filepath.Base(url))
+}
+
+// newVersionFromTarball checks the tarball of a version, extracts its assets,
+// saves the tarball in the storage with the given filename, and returns the
+// version document.
+func newVersionFromTarball(opts *VersionOptions, tarball *Tarball, filename string) (*Version, []*kivik.Attachment, error) {
+	var err *multierror.Error
 
 	// Checks
 	if _, erre := tarball.CheckEditor(); erre != nil {
@@ -669,6 +822,18 @@ func downloadVersion(opts *VersionOptions) (*Version, []*kivik.Attachment, error
 		err = multierror.Append(err, errv)
 	}
 
+	if errs := checkSignature(opts, tarball); errs != nil {
+		err = multierror.Append(err, errs)
+	}
+
+	// If the checks have passed, the manifest is validated against its schema
+	// to give all the problems to the editor at once
+	if err == nil {
+		if errv := schema.ValidateManifest(tarball.AppType, tarball.ManifestContent); errv != nil {
+			return nil, nil, errv
+		}
+	}
+
 	// Handling tarball assets
 	attachments, erra := HandleAssets(tarball, opts)
 	if erra != nil {
@@ -696,7 +861,6 @@ func downloadVersion(opts *VersionOptions) (*Version, []*kivik.Attachment, error
 	// Retreiving the tarball manifest
 	parsedManifest := tarball.Manifest
 
-	filename := filepath.Base(url)
 	filepath := filepath.Join(parsedManifest.Slug, opts.Version, filename)
 
 	// Saving app tarball
@@ -714,15 +878,47 @@ func downloadVersion(opts *VersionOptions) (*Version, []*kivik.Attachment, error
 	// Now the tarball has been downloaded, override the original tarball URL to
 	// local registry url for future downloads
 	ver.URL = opts.RegistryURL.String()
+	ver.SourceURL = opts.URL
 	ver.Sha256 = opts.Sha256
+	ver.Signature = opts.Signature
 	ver.Editor = parsedManifest.Editor
 	ver.Manifest = manifestContent
 	ver.Size = tarball.Size
 	ver.TarPrefix = tarball.TarPrefix
+	ver.Rollout = newRollout(opts.Rollout)
 	ver.CreatedAt = time.Now().UTC()
 	return ver, attachments, nil
 }
 
+// checkSignature verifies the detached signature of the tarball with the
+// public keys of the editor. The signature is mandatory when the editor has
+// registered public keys.
+func checkSignature(opts *VersionOptions, tarball *Tarball) error {
+	editor := opts.Editor
+	if editor == nil && auth.Editors != nil {
+		editor, _ = auth.Editors.GetEditor(tarball.Manifest.Editor)
+	}
+	if editor == nil || !editor.HasPublicKeys() {
+		if opts.Signature != "" {
+			return ErrSignatureWithoutKey
+		}
+		return nil
+	}
+	if opts.Signature == "" {
+		return ErrSignatureMissing
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(opts.Signature)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	digest, err := hex.DecodeString(opts.Sha256)
+	if err != nil || !editor.VerifySignature(digest, signature) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
 func getIconPath(parsedManifest *Manifest, opts *VersionOptions) string {
 	var iconPath string
 	if opts.Icon != "" {
@@ -814,8 +1010,10 @@ func HandleAssets(tarball *Tarball, opts *VersionOptions) ([]*kivik.Attachment,
 		return attachments, nil
 	}
 
-	var buf io.Reader = bytes.NewReader(tarball.Content)
-	tr, err := tarReader(buf, tarball.ContentType)
+	if _, err := tarball.Content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tr, err := tarReader(tarball.Content, tarball.ContentType)
 	if err != nil {
 		err = errshttp.NewError(http.StatusUnprocessableEntity,
 			"Could not reach version on specified url %s: %s", tarball.URL, err)
@@ -875,12 +1073,15 @@ func HandleAssets(tarball *Tarball, opts *VersionOptions) ([]*kivik.Attachment,
 }
 
 func saveTarball(prefix base.Prefix, filepath string, tarball *Tarball) error {
-	var content = bytes.NewReader(tarball.Content)
-	return base.Storage.Create(prefix, filepath, tarball.ContentType, content)
+	if _, err := tarball.Content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return base.Storage.Create(prefix, filepath, tarball.ContentType, tarball.Content)
 }
 
 // ReadTarballVersion reads the content of the version tarball which has been
-// downloaded. It reads the tarball to check if an app prefix exists, ensure
+// downloaded. The Content of the returned Tarball is not set, as the tarball
+// is read as a stream. It reads the tarball to check if an app prefix exists, ensure
 // that the manifest and the package.json (if exists) files are correct, and
 // eventually returns a Tarball struct that holds these informations for the
 // next steps
@@ -891,10 +1092,6 @@ func ReadTarballVersion(reader io.Reader, contentType, url string) (*Tarball, er
 	var manifest *Manifest
 	var manifestmap map[string]interface{}
 
-	var content = new(bytes.Buffer)
-
-	reader = io.TeeReader(reader, content)
-
 	hasPrefix := true
 
 	tr, err := tarReader(reader, contentType)
@@ -984,7 +1181,6 @@ func ReadTarballVersion(reader io.Reader, contentType, url string) (*Tarball, er
 		PackageVersion:  packVersion,
 		HasPrefix:       hasPrefix,
 		TarPrefix:       tarPrefix,
-		Content:         content.Bytes(),
 		URL:             url,
 	}, nil
 }
@@ -1039,9 +1235,13 @@ func (v *Version) Delete(c *space.Space) error {
 
 	// Removing the CouchDB document
 	db := c.VersDB()
-	_, err = db.Delete(context.Background(), v.ID, v.Rev)
-
-	return err
+	if _, err = db.Delete(context.Background(), v.ID, v.Rev); err != nil {
+		return err
+	}
+	updateAppFacets(c, v.Slug)
+	reindexApp(c, v.Slug)
+	sendVersionEvent(webhook.VersionDeleted, c, v)
+	return nil
 }
 
 // RemoveAllAttachments removes all the attachments of a version
@@ -1113,8 +1313,12 @@ func RemoveAppFromSpace(s *space.Space, appSlug string) error {
 	}
 
 	db := s.AppsDB()
-	_, err = db.Delete(context.Background(), app.ID, app.Rev)
-	return err
+	if _, err = db.Delete(context.Background(), app.ID, app.Rev); err != nil {
+		return err
+	}
+	reindexApp(s, appSlug)
+	sendAppEvent(webhook.AppRemoved, s, appSlug, nil)
+	return nil
 }
 
 // RemoveSpace deletes CouchDB databases and Swift container for this space.
@@ -1149,6 +1353,14 @@ func RemoveSpace(s *space.Space) error {
 	}
 
 	// Removing databases
+	if err := base.DBClient.DestroyDB(context.Background(), s.StatsDB().Name()); err != nil {
+		return err
+	}
+
+	if err := base.DBClient.DestroyDB(context.Background(), s.ReviewsDB().Name()); err != nil {
+		return err
+	}
+
 	if err := base.DBClient.DestroyDB(context.Background(), s.PendingVersDB().Name()); err != nil {
 		return err
 	}
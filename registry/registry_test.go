@@ -3,12 +3,15 @@ package registry
 import (
 	"archive/tar"
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -16,6 +19,7 @@ import (
 	"time"
 
 	"github.com/cozy/cozy-apps-registry/asset"
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/config"
@@ -74,7 +78,7 @@ func TestFindPreviousMajorNotExisting(t *testing.T) {
 }
 
 func TestDownloadVersion(t *testing.T) {
-	manifest := defaultManifest()
+	manifest := schemaValidManifest("en")
 	tmpFile, shasum, err := generateTarball(&manifest, defaultPackage())
 	assert.NoError(t, err)
 	defer os.Remove(tmpFile)
@@ -118,6 +122,123 @@ func TestDownloadVersionWithoutEditor(t *testing.T) {
 	assert.Contains(t, err.Error(), "\"editor\" field is empty")
 }
 
+func TestUploadVersion(t *testing.T) {
+	manifest := schemaValidManifest("en")
+	tmpFile, shasum, err := generateTarball(&manifest, defaultPackage())
+	assert.NoError(t, err)
+	defer os.Remove(tmpFile)
+
+	f, err := os.Open(tmpFile)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	buildedURL := &url.URL{
+		Scheme: "http",
+		Host:   "foobar.com",
+		Path:   "/registry/",
+	}
+	opts := &VersionOptions{
+		Version:     "1.0.0",
+		RegistryURL: buildedURL,
+		SpacePrefix: base.Prefix(testSpaceName),
+	}
+
+	ver, att, err := UploadVersion(opts, "app-1.0.0.tar.gz", f, "application/octet-stream")
+	assert.NoError(t, err)
+	assert.Empty(t, att)
+	assert.Equal(t, "1.0.0", ver.Version)
+	assert.Equal(t, shasum, ver.Sha256)
+}
+
+func TestUploadVersionBadChecksum(t *testing.T) {
+	manifest := defaultManifest()
+	tmpFile, _, err := generateTarball(&manifest, defaultPackage())
+	assert.NoError(t, err)
+	defer os.Remove(tmpFile)
+
+	f, err := os.Open(tmpFile)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	opts := &VersionOptions{
+		Version: "1.0.0",
+		Sha256:  "d5afeaf17396050e17c40e640dbd26dd2b103b5fbc1bb97d3306ed6254322481",
+	}
+
+	_, _, err = UploadVersion(opts, "app-1.0.0.tar.gz", f, "application/gzip")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Checksum does not match")
+}
+
+func TestUploadVersionTooLarge(t *testing.T) {
+	manifest := defaultManifest()
+	tmpFile, _, err := generateTarball(&manifest, defaultPackage())
+	assert.NoError(t, err)
+	defer os.Remove(tmpFile)
+
+	f, err := os.Open(tmpFile)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	base.Config.SpaceOptions = map[string]base.SpaceOptions{
+		testSpaceName: {MaxAppSize: 16},
+	}
+	defer func() { base.Config.SpaceOptions = nil }()
+
+	opts := &VersionOptions{
+		Version:     "1.0.0",
+		SpacePrefix: base.Prefix(testSpaceName),
+	}
+
+	_, _, err = UploadVersion(opts, "app-1.0.0.tar.gz", f, "application/gzip")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "too large")
+}
+
+func TestUploadSignedVersion(t *testing.T) {
+	signer, err := auth.Editors.CreateEditorWithoutPublicKey("signtesteditor", true)
+	assert.NoError(t, err)
+	defer func() { _ = auth.Editors.DeleteEditor(signer) }()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, auth.Editors.AddPublicKey(signer, pub))
+
+	manifest := schemaValidManifest("en")
+	tmpFile, shasum, err := generateTarball(&manifest, defaultPackage())
+	assert.NoError(t, err)
+	defer os.Remove(tmpFile)
+	digest, _ := hex.DecodeString(shasum)
+
+	upload := func(signature string) (*Version, error) {
+		f, err := os.Open(tmpFile)
+		assert.NoError(t, err)
+		defer f.Close()
+		opts := &VersionOptions{
+			Version:     "1.0.0",
+			Signature:   signature,
+			Editor:      signer,
+			RegistryURL: &url.URL{Scheme: "http", Host: "foobar.com", Path: "/registry/"},
+			SpacePrefix: base.Prefix(testSpaceName),
+		}
+		ver, _, err := UploadVersion(opts, "app-1.0.0.tar.gz", f, "application/gzip")
+		return ver, err
+	}
+
+	_, err = upload("")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "must be signed")
+
+	badSignature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("foo")))
+	_, err = upload(badSignature)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Signature of the version is invalid")
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest))
+	ver, err := upload(signature)
+	assert.NoError(t, err)
+	assert.Equal(t, signature, ver.Signature)
+}
+
 // Apps
 func TestCreateApp(t *testing.T) {
 	space, _ := space.GetSpace(testSpaceName)
@@ -509,6 +630,310 @@ func TestIsValidVersionBadVersion(t *testing.T) {
 	assert.Contains(t, res.Error(), "version", "sha256", "url")
 }
 
+func TestIsValidUploadedVersion(t *testing.T) {
+	ver := &VersionOptions{Version: "1.0.0"}
+	assert.NoError(t, IsValidUploadedVersion(ver))
+
+	ver.Sha256 = "azerty"
+	res := IsValidUploadedVersion(ver)
+	assert.Error(t, res)
+	assert.Contains(t, res.Error(), "sha256")
+}
+
+func TestRejectPendingVersion(t *testing.T) {
+	s, _ := space.GetSpace(testSpaceName)
+
+	testApp, err := findApp(s, "app-test")
+	assert.NoError(t, err)
+
+	ver := new(Version)
+	ver.Version = "3.0.0"
+	ver.Slug = "app-test"
+	ver.ID = getVersionID(ver.Slug, ver.Version)
+	err = CreatePendingVersion(s, ver, []*kivik.Attachment{}, testApp)
+	assert.NoError(t, err)
+
+	pending, err := FindPendingVersion(s, "app-test", "3.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, PendingStateWaiting, pending.State())
+
+	_, err = AddReviewComment(s, pending, "cozy", RoleReviewer, "Why this new permission?")
+	assert.NoError(t, err)
+	_, err = RejectPendingVersion(s, pending, "cozy", " ")
+	assert.Equal(t, ErrReviewReasonMissing, err)
+	_, err = RejectPendingVersion(s, pending, "cozy", "Unused permission")
+	assert.NoError(t, err)
+	_, err = RejectPendingVersion(s, pending, "cozy", "Unused permission")
+	assert.Equal(t, ErrVersionRejected, err)
+
+	versions, err := GetAppPendingVersions(s, "app-test")
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 1) {
+		assert.Equal(t, PendingStateRejected, versions[0].State())
+		assert.Equal(t, "Unused permission", versions[0].Rejection.Reason)
+		assert.Equal(t, "cozy", versions[0].Rejection.Reviewer)
+		assert.Len(t, versions[0].ReviewComments, 1)
+	}
+
+	history, err := GetReviewHistory(s, "app-test")
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, ReviewRejected, history[0].Action)
+		assert.Equal(t, "3.0.0", history[0].Version)
+		assert.Len(t, history[0].Comments, 1)
+	}
+}
+
+func TestYankVersion(t *testing.T) {
+	s, _ := space.GetSpace(testSpaceName)
+
+	testApp, err := findApp(s, "app-test")
+	assert.NoError(t, err)
+
+	ver := new(Version)
+	ver.Version = "5.0.0"
+	ver.Slug = "app-test"
+	ver.ID = getVersionID(ver.Slug, ver.Version)
+	err = createVersion(s, s.VersDB(), ver, []*kivik.Attachment{}, testApp, true)
+	assert.NoError(t, err)
+
+	_, err = YankVersion(s, "app-test", "5.0.0", "")
+	assert.Equal(t, ErrYankReasonMissing, err)
+	_, err = YankVersion(s, "app-test", "5.0.0", "Data loss on sync")
+	assert.NoError(t, err)
+	_, err = YankVersion(s, "app-test", "5.0.0", "Data loss on sync")
+	assert.Equal(t, ErrVersionYanked, err)
+
+	// The yanked version is kept, but not served anymore
+	yanked, err := FindPublishedVersion(s, "app-test", "5.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "Data loss on sync", yanked.Yanked.Reason)
+	latest, err := FindLatestVersion(s, "app-test", Stable)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "5.0.0", latest.Version)
+	versions, err := FindAppVersions(s, "app-test", Dev, Concatenated)
+	assert.NoError(t, err)
+	assert.NotContains(t, versions.GetAll(), "5.0.0")
+
+	_, err = UnyankVersion(s, "app-test", "5.0.0")
+	assert.NoError(t, err)
+	_, err = UnyankVersion(s, "app-test", "5.0.0")
+	assert.Equal(t, ErrVersionNotYanked, err)
+	latest, err = FindLatestVersion(s, "app-test", Stable)
+	assert.NoError(t, err)
+	assert.Equal(t, "5.0.0", latest.Version)
+}
+
+func TestDownloadStats(t *testing.T) {
+	s, _ := space.GetSpace(testSpaceName)
+
+	before, err := findApp(s, "app-test")
+	assert.NoError(t, err)
+
+	RecordDownload(s, "app-test", "5.0.0")
+	RecordDownload(s, "app-test", "5.0.0")
+	RecordDownload(s, "app-test", "1.0.0")
+	assert.NoError(t, FlushDownloads())
+	RecordDownload(s, "app-test", "5.0.0")
+	assert.NoError(t, FlushDownloads())
+
+	stats, err := GetDownloadStats(s, "app-test", "", "")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, stats.Total)
+	assert.EqualValues(t, 3, stats.Versions["5.0.0"])
+	assert.EqualValues(t, 1, stats.Versions["1.0.0"])
+	if assert.Len(t, stats.Days, 1) {
+		assert.Equal(t, stats.Until, stats.Days[0].Day)
+		assert.EqualValues(t, 4, stats.Days[0].Count)
+	}
+
+	// The total of the application is updated only by FlushAppDownloads
+	after, err := findApp(s, "app-test")
+	assert.NoError(t, err)
+	assert.Equal(t, before.Downloads, after.Downloads)
+	assert.NoError(t, FlushAppDownloads())
+	after, err = findApp(s, "app-test")
+	assert.NoError(t, err)
+	assert.Equal(t, before.Downloads+4, after.Downloads)
+
+	stats, err = GetDownloadStats(s, "app-test", "2000-01-01", "2000-01-31")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, stats.Total)
+	_, err = GetDownloadStats(s, "app-test", "yesterday", "")
+	assert.Equal(t, ErrStatsDateInvalid, err)
+}
+
+func TestAuditLog(t *testing.T) {
+	audit.Record(&audit.Entry{
+		Actor:   "cli:tester",
+		Action:  audit.VersionYanked,
+		Space:   testSpaceName,
+		Slug:    "app-audit",
+		Version: "1.0.0",
+		After:   map[string]string{"reason": "broken"},
+	})
+	audit.Record(&audit.Entry{
+		Actor:  "cli:tester",
+		Action: audit.AppRemoved,
+		Space:  testSpaceName,
+		Slug:   "app-audit",
+	})
+
+	entries, err := audit.List(audit.Filters{Slug: "app-audit"})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		// The most recent entries come first
+		assert.Equal(t, audit.AppRemoved, entries[0].Action)
+		assert.Equal(t, audit.VersionYanked, entries[1].Action)
+		assert.Equal(t, "1.0.0", entries[1].Version)
+	}
+
+	entries, err = audit.List(audit.Filters{Slug: "app-audit", Action: audit.VersionYanked})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	entries, err = audit.List(audit.Filters{Slug: "app-audit", Until: time.Now().Add(-time.Hour)})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	// The entries cannot be modified
+	entries, err = audit.List(audit.Filters{Slug: "app-audit", Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		var doc audit.Entry
+		err = audit.DB().Get(context.Background(), entries[0].ID).ScanDoc(&doc)
+		assert.NoError(t, err)
+		doc.Actor = "someone-else"
+		_, err = audit.DB().Put(context.Background(), doc.ID, doc)
+		assert.Equal(t, http.StatusForbidden, kivik.StatusCode(err))
+	}
+}
+
+func TestTransferApp(t *testing.T) {
+	s, _ := space.GetSpace(testSpaceName)
+	secret := auth.GenerateMasterSecret()
+
+	previous, err := auth.Editors.CreateEditorWithoutPublicKey("cozytransferfrom", true)
+	assert.NoError(t, err)
+	next, err := auth.Editors.CreateEditorWithoutPublicKey("cozytransferto", true)
+	assert.NoError(t, err)
+
+	transferred, err := CreateApp(s, &AppOptions{Editor: previous.Name(), Slug: "app-transfer", Type: "webapp"}, previous)
+	assert.NoError(t, err)
+	ver := &Version{Slug: "app-transfer", Version: "1.0.0", Editor: previous.Name()}
+	ver.ID = getVersionID(ver.Slug, ver.Version)
+	err = createVersion(s, s.VersDB(), ver, []*kivik.Attachment{}, transferred, true)
+	assert.NoError(t, err)
+
+	token, info, err := previous.GenerateEditorToken(secret, 0, "app-transfer", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, auth.Editors.AddToken(previous, info, ""))
+	other, _, err := previous.GenerateEditorToken(secret, 0, "app-other", nil)
+	assert.NoError(t, err)
+
+	_, err = TransferApp(s, "app-transfer", previous, "cozy")
+	assert.Equal(t, ErrAppSameEditor, err)
+	transferred, err = TransferApp(s, "app-transfer", next, "cozy")
+	assert.NoError(t, err)
+	assert.Equal(t, next.Name(), transferred.Editor)
+
+	found, err := FindVersion(s, "app-transfer", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, next.Name(), found.Editor)
+
+	// Only the tokens for the transferred application are revoked
+	previous, err = auth.Editors.GetEditor(previous.Name())
+	assert.NoError(t, err)
+	assert.False(t, previous.VerifyEditorToken(secret, token, "app-transfer"))
+	assert.True(t, previous.VerifyEditorToken(secret, other, "app-other"))
+	if assert.Len(t, previous.Tokens(), 1) {
+		assert.NotNil(t, previous.Tokens()[0].RevokedAt)
+	}
+
+	history, err := GetReviewHistory(s, "app-transfer")
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, ReviewTransferred, history[0].Action)
+		assert.Equal(t, "cozy", history[0].Reviewer)
+		assert.Equal(t, previous.Name(), history[0].PreviousEditor)
+		assert.Equal(t, next.Name(), history[0].Editor)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	s, _ := space.GetSpace(testSpaceName)
+	prefix := s.GetPrefix()
+
+	orphan := "app-test/0.0.1-orphan/app-test.tar.gz"
+	err := base.Storage.Create(prefix, orphan, "application/gzip", strings.NewReader("orphan"))
+	assert.NoError(t, err)
+	link := "universallink/apple-app-site-association"
+	err = base.Storage.Create(prefix, link, "application/json", strings.NewReader("{}"))
+	assert.NoError(t, err)
+
+	findProblem := func(problems []*FsckProblem, name string) *FsckProblem {
+		for _, p := range problems {
+			if p.Kind == FsckOrphanObject && p.Prefix == prefix && p.Name == name {
+				return p
+			}
+		}
+		return nil
+	}
+	findOrphan := func(problems []*FsckProblem) *FsckProblem {
+		return findProblem(problems, orphan)
+	}
+
+	problems, err := Fsck(FsckOptions{})
+	assert.NoError(t, err)
+	if p := findOrphan(problems); assert.NotNil(t, p) {
+		assert.False(t, p.Repaired)
+	}
+	_, _, err = base.Storage.Get(prefix, orphan)
+	assert.NoError(t, err)
+
+	problems, err = Fsck(FsckOptions{Repair: true})
+	assert.NoError(t, err)
+	if p := findOrphan(problems); assert.NotNil(t, p) {
+		assert.True(t, p.Repaired)
+	}
+	_, _, err = base.Storage.Get(prefix, orphan)
+	assert.Error(t, err)
+	assert.Nil(t, findProblem(problems, link))
+	_, _, err = base.Storage.Get(prefix, link)
+	assert.NoError(t, err)
+
+	problems, err = Fsck(FsckOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, findOrphan(problems))
+}
+
+func TestSearchIndexesStableManifest(t *testing.T) {
+	s, _ := space.GetSpace(testSpaceName)
+	opts := &AppOptions{Editor: "cozy", Slug: "app-search", Type: "webapp"}
+	app, err := CreateApp(s, opts, editor)
+	assert.NoError(t, err)
+
+	manifests := map[string]string{
+		"1.0.0":            `{"name": "Stable Alpha"}`,
+		"2.0.0-dev.abcdef": `{"name": "Unreleased Omega"}`,
+	}
+	for version, manifest := range manifests {
+		ver := &Version{
+			ID:       getVersionID(app.Slug, version),
+			Slug:     app.Slug,
+			Version:  version,
+			Manifest: json.RawMessage(manifest),
+		}
+		assert.NoError(t, CreateReleaseVersion(s, ver, nil, app, true))
+	}
+
+	slugs, err := searchApps(s, "alpha", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, slugs, "app-search")
+	slugs, err = searchApps(s, "omega", nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, slugs, "app-search")
+}
+
 func TestRemoveSpace(t *testing.T) {
 	s, _ := space.GetSpace(testSpaceName)
 	err := RemoveSpace(s)
@@ -533,6 +958,14 @@ func TestRemoveSpace(t *testing.T) {
 	ok, err = client.DBExists(context.Background(), s.VersDB().Name())
 	assert.NoError(t, err)
 	assert.False(t, ok)
+
+	ok, err = client.DBExists(context.Background(), s.ReviewsDB().Name())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = client.DBExists(context.Background(), s.StatsDB().Name())
+	assert.NoError(t, err)
+	assert.False(t, ok)
 }
 
 func TestMain(m *testing.M) {
@@ -650,6 +1083,23 @@ func defaultManifest() Manifest {
 	}
 }
 
+// Return a manifest that is also valid for the JSON schema, with the given
+// locales
+func schemaValidManifest(locales ...string) Manifest {
+	manifest := defaultManifest()
+	manifest.Name = "Cozy Test App"
+	manifest.Screenshots = []string{}
+	manifest.Locales = make(map[string]struct {
+		Screenshots []string `json:"screenshots"`
+	})
+	for _, locale := range locales {
+		manifest.Locales[locale] = struct {
+			Screenshots []string `json:"screenshots"`
+		}{Screenshots: []string{}}
+	}
+	return manifest
+}
+
 // Return a simple validated package
 func defaultPackage() map[string]interface{} {
 	return map[string]interface{}{
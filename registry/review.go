@@ -0,0 +1,196 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/cozy/cozy-apps-registry/webhook"
+)
+
+var (
+	ErrReviewReasonMissing  = errshttp.NewError(http.StatusBadRequest, "A reason is required to reject a version")
+	ErrReviewMessageMissing = errshttp.NewError(http.StatusBadRequest, "The comment message is empty")
+	ErrVersionRejected      = errshttp.NewError(http.StatusConflict, "Version has already been rejected")
+)
+
+// The actions recorded in the review history of an application.
+const (
+	ReviewApproved    = "approved"
+	ReviewRejected    = "rejected"
+	ReviewTransferred = "transferred"
+)
+
+// The roles of the authors of the review comments.
+const (
+	RoleEditor   = "editor"
+	RoleReviewer = "reviewer"
+)
+
+// The states of a pending version, for filtering.
+const (
+	PendingStateWaiting  = "waiting"
+	PendingStateRejected = "rejected"
+)
+
+// ReviewComment is a message of the review thread of a pending version,
+// written by a reviewer or by the editor of the application.
+type ReviewComment struct {
+	Author    string    `json:"author"`
+	Role      string    `json:"role"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Rejection is the decision of a reviewer to not publish a pending version.
+type Rejection struct {
+	Reason     string    `json:"reason"`
+	Reviewer   string    `json:"reviewer"`
+	RejectedAt time.Time `json:"rejected_at"`
+}
+
+// ReviewEvent is an entry of the review history of an application: a pending
+// version has been approved or rejected, or the application has been
+// transferred to another editor.
+type ReviewEvent struct {
+	ID             string           `json:"_id,omitempty"`
+	Rev            string           `json:"_rev,omitempty"`
+	Slug           string           `json:"slug"`
+	Version        string           `json:"version,omitempty"`
+	Action         string           `json:"action"`
+	Reviewer       string           `json:"reviewer"`
+	Reason         string           `json:"reason,omitempty"`
+	Comments       []*ReviewComment `json:"comments,omitempty"`
+	PreviousEditor string           `json:"previous_editor,omitempty"`
+	Editor         string           `json:"editor,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+
+// State returns whether the pending version is waiting for a review or has
+// been rejected.
+func (version *Version) State() string {
+	if version.Rejection != nil {
+		return PendingStateRejected
+	}
+	return PendingStateWaiting
+}
+
+// RejectPendingVersion marks a pending version as rejected. The version is
+// kept in the pending database so that the editor can see the reason.
+func RejectPendingVersion(c *space.Space, pending *Version, reviewer, reason string) (*Version, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrReviewReasonMissing
+	}
+	if pending.Rejection != nil {
+		return nil, ErrVersionRejected
+	}
+
+	pending.Rejection = &Rejection{
+		Reason:     reason,
+		Reviewer:   reviewer,
+		RejectedAt: time.Now().UTC(),
+	}
+	rev, err := c.PendingVersDB().Put(context.Background(), pending.ID, pending)
+	if err != nil {
+		return nil, err
+	}
+	pending.Rev = rev
+
+	event := newReviewEvent(pending, ReviewRejected, reviewer)
+	event.Reason = reason
+	if err = saveReviewEvent(c, event); err != nil {
+		return nil, err
+	}
+	sendVersionEvent(webhook.VersionRejected, c, pending)
+	return pending, nil
+}
+
+// AddReviewComment adds a message to the review thread of a pending version.
+func AddReviewComment(c *space.Space, pending *Version, author, role, message string) (*ReviewComment, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, ErrReviewMessageMissing
+	}
+	comment := &ReviewComment{
+		Author:    author,
+		Role:      role,
+		Message:   message,
+		CreatedAt: time.Now().UTC(),
+	}
+	pending.ReviewComments = append(pending.ReviewComments, comment)
+	rev, err := c.PendingVersDB().Put(context.Background(), pending.ID, pending)
+	if err != nil {
+		return nil, err
+	}
+	pending.Rev = rev
+	return comment, nil
+}
+
+// GetAppPendingVersions returns the pending versions of an application,
+// including the rejected ones, with the feedback of the reviewers.
+func GetAppPendingVersions(c *space.Space, appSlug string) ([]*Version, error) {
+	versions, err := GetPendingVersions(c)
+	if err != nil {
+		return nil, err
+	}
+	filtered := versions[:0]
+	for _, version := range versions {
+		if version.Slug == appSlug {
+			filtered = append(filtered, version)
+		}
+	}
+	return filtered, nil
+}
+
+// GetReviewHistory returns the approvals and rejections of the pending
+// versions of an application, and its transfers, the most recent first.
+func GetReviewHistory(c *space.Space, appSlug string) ([]*ReviewEvent, error) {
+	rows, err := c.ReviewsDB().Find(context.Background(), map[string]interface{}{
+		"selector": map[string]interface{}{"slug": appSlug},
+		"limit":    10000,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*ReviewEvent, 0)
+	for rows.Next() {
+		var event ReviewEvent
+		if err = rows.ScanDoc(&event); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+	return events, nil
+}
+
+func newReviewEvent(version *Version, action, reviewer string) *ReviewEvent {
+	now := time.Now().UTC()
+	return &ReviewEvent{
+		ID:        fmt.Sprintf("%s-%s-%d", version.Slug, version.Version, now.UnixNano()),
+		Slug:      version.Slug,
+		Version:   version.Version,
+		Action:    action,
+		Reviewer:  reviewer,
+		Comments:  version.ReviewComments,
+		CreatedAt: now,
+	}
+}
+
+func saveReviewEvent(c *space.Space, event *ReviewEvent) error {
+	_, err := c.ReviewsDB().Put(context.Background(), event.ID, event)
+	return err
+}
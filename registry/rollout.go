@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+)
+
+var (
+	ErrRolloutInvalid    = errshttp.NewError(http.StatusBadRequest, "Invalid rollout: the percentage should be between 0 and 100")
+	ErrRolloutNotStable  = errshttp.NewError(http.StatusBadRequest, "Invalid rollout: only the stable versions can be rolled out progressively")
+	ErrRolloutNotFound   = errshttp.NewError(http.StatusNotFound, "Version has no rollout")
+	ErrRolloutPaused     = errshttp.NewError(http.StatusConflict, "Rollout is paused")
+	ErrRolloutRolledBack = errshttp.NewError(http.StatusConflict, "Rollout has been rolled back")
+)
+
+// rolloutBuckets is the number of groups of instances for the rollouts. An
+// instance is in a rollout at x% if its bucket is lower than x.
+const rolloutBuckets = 100
+
+// Rollout is used to serve a stable version as the latest version only to a
+// percentage of the instances, the others still get the previous version.
+type Rollout struct {
+	Percentage int `json:"percentage"`
+	// StartedAt is the date from which the percentage is applied. Before it,
+	// the version is served to no instance.
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Paused     bool      `json:"paused,omitempty"`
+	RolledBack bool      `json:"rolled_back,omitempty"`
+}
+
+// newRollout returns the rollout for a new version published with the given
+// percentage, or nil if the version is served to all the instances.
+func newRollout(percentage *int) *Rollout {
+	if percentage == nil || *percentage >= rolloutBuckets {
+		return nil
+	}
+	now := time.Now().UTC()
+	return &Rollout{
+		Percentage: *percentage,
+		StartedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+func isValidRollout(version string, percentage *int) error {
+	if percentage == nil {
+		return nil
+	}
+	if *percentage < 0 || *percentage > rolloutBuckets {
+		return ErrRolloutInvalid
+	}
+	if GetVersionChannel(version) != Stable {
+		return ErrRolloutNotStable
+	}
+	return nil
+}
+
+// effectivePercentage returns the percentage of instances that are served
+// the version at the given date.
+func (r *Rollout) effectivePercentage(now time.Time) int {
+	if r == nil {
+		return rolloutBuckets
+	}
+	if r.RolledBack || now.Before(r.StartedAt) {
+		return 0
+	}
+	return r.Percentage
+}
+
+// servesBucket returns true if the version can be served to the instances of
+// the bucket. A version without rollout is served to all the buckets,
+// including the one of the requests without instance.
+func (version *Version) servesBucket(bucket int, now time.Time) bool {
+	if version.Rollout == nil {
+		return true
+	}
+	return bucket < version.Rollout.effectivePercentage(now)
+}
+
+// rolloutBucket returns the bucket of an instance for an application. The
+// same instance is always in the same bucket for an application, so that it
+// doesn't go back to the previous version when the rollout advances. Without
+// an instance identifier, only the versions fully rolled out are served.
+func rolloutBucket(appSlug, instance string) int {
+	if instance == "" {
+		return rolloutBuckets
+	}
+	sum := sha256.Sum256([]byte(appSlug + ":" + instance))
+	return int(binary.BigEndian.Uint32(sum[:4]) % rolloutBuckets)
+}
+
+// FindLatestVersionForInstance is like FindLatestVersionWithOverride, but it
+// takes into account the rollouts of the versions: if the latest version is
+// not rolled out yet for the given instance, the previous one is returned.
+func FindLatestVersionForInstance(v *base.VirtualSpace, c *space.Space, appSlug string, channel Channel, instance string) (*Version, error) {
+	latest, err := FindLatestVersionWithOverride(v, c, appSlug, channel)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	bucket := rolloutBucket(appSlug, instance)
+	if latest.servesBucket(bucket, now) {
+		return latest, nil
+	}
+
+	// The key depends on the rollout of the latest version, so that the
+	// entries are not used anymore when it changes.
+	name := c.Name
+	if v != nil {
+		name = v.Name
+	}
+	var updatedAt int64
+	if latest.Rollout != nil {
+		updatedAt = latest.Rollout.UpdatedAt.Unix()
+	}
+	channelStr := ChannelToStr(channel)
+	key := base.NewKey(name, appSlug, channelStr+"/"+latest.Version+"/"+
+		strconv.FormatInt(updatedAt, 10)+"/"+strconv.Itoa(bucket))
+	if data, ok := base.LatestVersionsCache.Get(key); ok {
+		var cached *Version
+		if err := json.Unmarshal(data, &cached); err == nil {
+			return cached, nil
+		}
+	}
+
+	rows, err := versionViewQuery(c, c.VersDB(), appSlug, channelStr, map[string]interface{}{
+		"descending":   true,
+		"include_docs": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found *Version
+	for rows.Next() {
+		var ver *Version
+		if err = rows.ScanDoc(&ver); err != nil {
+			return nil, err
+		}
+		if ver.servesBucket(bucket, now) {
+			found = ver
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrVersionNotFound
+	}
+
+	if v != nil {
+		overwritten, err := FindOverwrittenVersion(v, found)
+		if err != nil && err != ErrVersionNotFound {
+			return nil, err
+		}
+		if err == nil {
+			found = overwritten
+		}
+	}
+	found.ID = ""
+	found.Rev = ""
+	if data, err := json.Marshal(found); err == nil {
+		go base.LatestVersionsCache.Add(key, base.Value(data))
+	}
+	return found, nil
+}
+
+// AdvanceRollout changes the percentage of instances that are served a
+// version. A percentage of 100 finishes the rollout. If startAt is not zero,
+// the new percentage is applied only from this date.
+func AdvanceRollout(c *space.Space, appSlug, version string, percentage int, startAt time.Time) (*Version, error) {
+	if err := isValidRollout(version, &percentage); err != nil {
+		return nil, err
+	}
+	return updateRollout(c, appSlug, version, func(ver *Version, now time.Time) error {
+		if ver.Rollout == nil {
+			// The version was fully rolled out, it is rolled out again for
+			// a part of the instances only
+			ver.Rollout = &Rollout{StartedAt: now}
+		}
+		if ver.Rollout.Paused {
+			return ErrRolloutPaused
+		}
+		if ver.Rollout.RolledBack {
+			return ErrRolloutRolledBack
+		}
+		ver.Rollout.Percentage = percentage
+		if !startAt.IsZero() {
+			ver.Rollout.StartedAt = startAt.UTC()
+		}
+		return nil
+	})
+}
+
+// PauseRollout stops the rollout of a version at its current percentage:
+// it can't be advanced until it is resumed.
+func PauseRollout(c *space.Space, appSlug, version string) (*Version, error) {
+	return updateRollout(c, appSlug, version, func(ver *Version, now time.Time) error {
+		if ver.Rollout == nil {
+			return ErrRolloutNotFound
+		}
+		ver.Rollout.Paused = true
+		return nil
+	})
+}
+
+// ResumeRollout allows to advance a paused rollout again.
+func ResumeRollout(c *space.Space, appSlug, version string) (*Version, error) {
+	return updateRollout(c, appSlug, version, func(ver *Version, now time.Time) error {
+		if ver.Rollout == nil {
+			return ErrRolloutNotFound
+		}
+		ver.Rollout.Paused = false
+		return nil
+	})
+}
+
+// RollbackRollout stops serving a version: all the instances get the
+// previous version again. A new version must be published to fix it.
+func RollbackRollout(c *space.Space, appSlug, version string) (*Version, error) {
+	return updateRollout(c, appSlug, version, func(ver *Version, now time.Time) error {
+		if ver.Rollout == nil {
+			ver.Rollout = &Rollout{StartedAt: now}
+		}
+		ver.Rollout.RolledBack = true
+		ver.Rollout.Paused = false
+		return nil
+	})
+}
+
+func updateRollout(c *space.Space, appSlug, version string, update func(*Version, time.Time) error) (*Version, error) {
+	ver, err := FindPublishedVersion(c, appSlug, version)
+	if err != nil {
+		return nil, err
+	}
+	if GetVersionChannel(ver.Version) != Stable {
+		return nil, ErrRolloutNotStable
+	}
+	now := time.Now().UTC()
+	if err = update(ver, now); err != nil {
+		return nil, err
+	}
+	ver.Rollout.UpdatedAt = now
+	if ver.Rollout.Percentage >= rolloutBuckets && !ver.Rollout.RolledBack {
+		ver.Rollout = nil
+	}
+	if ver.Rev, err = c.VersDB().Put(context.Background(), ver.ID, ver); err != nil {
+		return nil, err
+	}
+
+	for _, channel := range Channels {
+		key := base.NewKey(c.Name, ver.Slug, ChannelToStr(channel))
+		base.LatestVersionsCache.Remove(key)
+		base.ListVersionsCache.Remove(key)
+	}
+	return ver, nil
+}
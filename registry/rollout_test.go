@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutBucket(t *testing.T) {
+	bucket := rolloutBucket("drive", "alice.cozy.example")
+	assert.True(t, bucket >= 0 && bucket < rolloutBuckets)
+	assert.Equal(t, bucket, rolloutBucket("drive", "alice.cozy.example"))
+	assert.Equal(t, rolloutBuckets, rolloutBucket("drive", ""))
+
+	// The instances are spread between the buckets
+	seen := make(map[int]bool)
+	for i := 0; i < 1000; i++ {
+		seen[rolloutBucket("drive", time.Duration(i).String())] = true
+	}
+	assert.True(t, len(seen) > 90)
+}
+
+func TestVersionServesBucket(t *testing.T) {
+	now := time.Now()
+	ver := &Version{Version: "1.0.0"}
+	assert.True(t, ver.servesBucket(0, now))
+	assert.True(t, ver.servesBucket(99, now))
+	// A version without rollout is also served to the requests without
+	// instance
+	assert.True(t, ver.servesBucket(rolloutBuckets, now))
+
+	ver.Rollout = &Rollout{Percentage: 10, StartedAt: now.Add(-time.Hour)}
+	assert.True(t, ver.servesBucket(9, now))
+	assert.False(t, ver.servesBucket(10, now))
+	assert.False(t, ver.servesBucket(rolloutBuckets, now))
+
+	ver.Rollout.StartedAt = now.Add(time.Hour)
+	assert.False(t, ver.servesBucket(0, now))
+
+	ver.Rollout.StartedAt = now.Add(-time.Hour)
+	ver.Rollout.RolledBack = true
+	assert.False(t, ver.servesBucket(0, now))
+}
+
+func TestIsValidRollout(t *testing.T) {
+	ten, tooMuch := 10, 101
+	assert.NoError(t, isValidRollout("1.0.0", nil))
+	assert.NoError(t, isValidRollout("1.0.0", &ten))
+	assert.Equal(t, ErrRolloutInvalid, isValidRollout("1.0.0", &tooMuch))
+	assert.Equal(t, ErrRolloutNotStable, isValidRollout("1.0.0-beta.1", &ten))
+}
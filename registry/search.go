@@ -0,0 +1,328 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/sirupsen/logrus"
+)
+
+// searchIndexTTL is the maximal age of a search index before it is rebuilt.
+// The index is updated when a version is published by the server, but the
+// command-line can also modify the applications.
+const searchIndexTTL = 15 * time.Minute
+
+// The weights of the manifest fields for the relevance of a search.
+const (
+	searchWeightName        = 10
+	searchWeightTag         = 5
+	searchWeightCategory    = 4
+	searchWeightShortDesc   = 3
+	searchWeightLongDesc    = 1
+	searchPrefixWeightRatio = 2
+)
+
+// searchIndex is an in-memory index of the texts of the applications of a
+// space, used for the q parameter of the apps list.
+type searchIndex struct {
+	mu      sync.RWMutex
+	builtAt time.Time
+	docs    map[string]*searchDoc
+	// building is held during a rebuild, so that the concurrent requests
+	// wait for it instead of rebuilding the index too
+	building sync.Mutex
+}
+
+// searchDoc is an indexed application: its terms with their weight, and the
+// application document for the filters.
+type searchDoc struct {
+	app   *App
+	terms map[string]int
+}
+
+var searchIndexes = struct {
+	sync.Mutex
+	bySpace map[string]*searchIndex
+}{bySpace: make(map[string]*searchIndex)}
+
+func getSearchIndex(c *space.Space) *searchIndex {
+	searchIndexes.Lock()
+	defer searchIndexes.Unlock()
+	// The name of the database is used as the key, as the virtual spaces
+	// are clones of their source space with another name.
+	key := c.AppsDB().Name()
+	idx, ok := searchIndexes.bySpace[key]
+	if !ok {
+		idx = &searchIndex{}
+		searchIndexes.bySpace[key] = idx
+	}
+	return idx
+}
+
+// ensureBuilt (re)builds the index if it is missing or too old.
+func (idx *searchIndex) ensureBuilt(c *space.Space) error {
+	idx.mu.RLock()
+	fresh := idx.docs != nil && time.Since(idx.builtAt) < searchIndexTTL
+	idx.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	idx.building.Lock()
+	defer idx.building.Unlock()
+	idx.mu.RLock()
+	fresh = idx.docs != nil && time.Since(idx.builtAt) < searchIndexTTL
+	idx.mu.RUnlock()
+	if fresh {
+		// The index has been rebuilt by another request in the meantime
+		return nil
+	}
+
+	rows, err := c.AppsDB().AllDocs(context.Background(), map[string]interface{}{
+		"include_docs": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	docs := make(map[string]*searchDoc)
+	for rows.Next() {
+		if strings.HasPrefix(rows.ID(), "_design") {
+			continue
+		}
+		var app App
+		if err = rows.ScanDoc(&app); err != nil {
+			return err
+		}
+		doc, err := newSearchDoc(c, &app)
+		if err != nil {
+			return err
+		}
+		docs[app.Slug] = doc
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	idx.docs = docs
+	idx.builtAt = time.Now()
+	idx.mu.Unlock()
+	return nil
+}
+
+func newSearchDoc(c *space.Space, app *App) (*searchDoc, error) {
+	doc := &searchDoc{
+		app:   app,
+		terms: make(map[string]int),
+	}
+	doc.addText(app.Slug, searchWeightName)
+	// The manifest of the stable version is indexed, as it is the one shown
+	// in the listings. The beta and dev versions are used only for the
+	// applications without stable version.
+	for _, channel := range []Channel{Stable, Beta, Dev} {
+		latest, err := FindLatestVersion(c, app.Slug, channel)
+		if err == ErrVersionNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		doc.addManifest(latest.Manifest)
+		break
+	}
+	return doc, nil
+}
+
+// addManifest indexes the searchable fields of a manifest, for all its
+// locales.
+func (doc *searchDoc) addManifest(raw json.RawMessage) {
+	var manifest struct {
+		searchableFields
+		Locales map[string]searchableFields `json:"locales"`
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return
+	}
+	doc.addFields(manifest.searchableFields)
+	for _, fields := range manifest.Locales {
+		doc.addFields(fields)
+	}
+}
+
+type searchableFields struct {
+	Name             interface{} `json:"name"`
+	ShortDescription interface{} `json:"short_description"`
+	LongDescription  interface{} `json:"long_description"`
+	Tags             interface{} `json:"tags"`
+	Categories       interface{} `json:"categories"`
+}
+
+func (doc *searchDoc) addFields(fields searchableFields) {
+	doc.addValue(fields.Name, searchWeightName)
+	doc.addValue(fields.ShortDescription, searchWeightShortDesc)
+	doc.addValue(fields.LongDescription, searchWeightLongDesc)
+	doc.addValue(fields.Tags, searchWeightTag)
+	doc.addValue(fields.Categories, searchWeightCategory)
+}
+
+// addValue indexes a field of a manifest, which can be a string or a list of
+// strings.
+func (doc *searchDoc) addValue(value interface{}, weight int) {
+	switch v := value.(type) {
+	case string:
+		doc.addText(v, weight)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				doc.addText(s, weight)
+			}
+		}
+	}
+}
+
+func (doc *searchDoc) addText(text string, weight int) {
+	for _, term := range searchTerms(text) {
+		if doc.terms[term] < weight {
+			doc.terms[term] = weight
+		}
+	}
+}
+
+// score returns the relevance of the document for the query terms, or 0 if a
+// term is missing. A term can match an indexed word exactly, or as a prefix
+// with a lower weight.
+func (doc *searchDoc) score(query []string) int {
+	total := 0
+	for _, q := range query {
+		best := doc.terms[q]
+		for term, weight := range doc.terms {
+			w := weight / searchPrefixWeightRatio
+			if w == 0 {
+				w = 1
+			}
+			if w > best && strings.HasPrefix(term, q) {
+				best = w
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total
+}
+
+// searchApps returns the slugs of the applications that match the query,
+// from the most relevant to the least one.
+func searchApps(c *space.Space, query string, filters map[string]string) ([]string, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	idx := getSearchIndex(c)
+	if err := idx.ensureBuilt(c); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		slug  string
+		score int
+	}
+	var results []result
+	idx.mu.RLock()
+	for _, doc := range idx.docs {
+		if !doc.app.matchFilters(filters) {
+			continue
+		}
+		if score := doc.score(terms); score > 0 {
+			results = append(results, result{doc.app.Slug, score})
+		}
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].slug < results[j].slug
+	})
+	slugs := make([]string, len(results))
+	for i, r := range results {
+		slugs[i] = r.slug
+	}
+	return slugs, nil
+}
+
+// reindexApp updates the search index of a space after a change on an
+// application. Nothing is done if the index has not been built yet.
+func reindexApp(c *space.Space, appSlug string) {
+	idx := getSearchIndex(c)
+	idx.mu.RLock()
+	built := idx.docs != nil
+	idx.mu.RUnlock()
+	if !built {
+		return
+	}
+
+	var doc *searchDoc
+	app, err := findApp(c, appSlug)
+	if err == nil {
+		doc, err = newSearchDoc(c, app)
+	}
+	if err != nil && err != ErrAppNotFound {
+		logrus.WithFields(logrus.Fields{
+			"nspace":    "search",
+			"space":     c.Name,
+			"slug":      appSlug,
+			"error_msg": err,
+		}).Error()
+		return
+	}
+
+	idx.mu.Lock()
+	if doc == nil {
+		delete(idx.docs, appSlug)
+	} else {
+		idx.docs[appSlug] = doc
+	}
+	idx.mu.Unlock()
+}
+
+// searchTerms splits a text in lowercased words without diacritics.
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = removeDiacritics(word)
+	}
+	return words
+}
+
+var diacritics = map[rune]rune{
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a',
+	'ç': 'c',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+	'ñ': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u',
+	'ý': 'y', 'ÿ': 'y',
+}
+
+func removeDiacritics(word string) string {
+	return strings.Map(func(r rune) rune {
+		if base, ok := diacritics[r]; ok {
+			return base
+		}
+		return r
+	}, word)
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTerms(t *testing.T) {
+	terms := searchTerms("Récupère vos factures, l'Orange & co!")
+	assert.Equal(t, []string{"recupere", "vos", "factures", "l", "orange", "co"}, terms)
+}
+
+func TestSearchDocScore(t *testing.T) {
+	manifest := json.RawMessage(`{
+		"name": "Banks",
+		"short_description": "Manage your bank accounts",
+		"tags": ["finance", "money"],
+		"categories": ["banking"],
+		"locales": {
+			"fr": {
+				"name": "Banques",
+				"long_description": "Gérez vos comptes bancaires"
+			}
+		}
+	}`)
+	app := &App{Slug: "banks", Type: "webapp"}
+	doc := &searchDoc{app: app, terms: make(map[string]int)}
+	doc.addText(app.Slug, searchWeightName)
+	doc.addManifest(manifest)
+
+	assert.Equal(t, searchWeightName, doc.score([]string{"banks"}))
+	assert.Equal(t, searchWeightName, doc.score([]string{"banques"}))
+	// "bank" is in the short description, and a prefix of the name
+	assert.Equal(t, searchWeightName/searchPrefixWeightRatio, doc.score([]string{"bank"}))
+	assert.Equal(t, searchWeightTag, doc.score([]string{"finance"}))
+	assert.Equal(t, searchWeightLongDesc, doc.score([]string{"gerez"}))
+	// Prefix match
+	assert.Equal(t, searchWeightTag/searchPrefixWeightRatio, doc.score([]string{"fin"}))
+	// All the terms must match
+	assert.Equal(t, 0, doc.score([]string{"bank", "photos"}))
+	assert.Equal(t, searchWeightName/searchPrefixWeightRatio+searchWeightTag, doc.score([]string{"bank", "money"}))
+
+	assert.True(t, app.matchFilters(map[string]string{"type": "webapp"}))
+	assert.False(t, app.matchFilters(map[string]string{"type": "konnector"}))
+	assert.False(t, app.matchFilters(map[string]string{"reject": "drive,banks"}))
+	assert.True(t, app.matchFilters(map[string]string{"select": "drive,banks"}))
+}
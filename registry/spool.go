@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/h2non/filetype"
+)
+
+// spooledTarball is the tarball of a version saved in a temporary file while
+// it is received, to avoid keeping the whole archive in memory.
+type spooledTarball struct {
+	file   *os.File
+	size   int64
+	sha256 string
+}
+
+// spoolTarball copies the content to a temporary file, while computing its
+// sha256 and checking the size limit. If shasum is not empty, it must match
+// the computed checksum. The caller must call Close on the returned tarball
+// to remove the temporary file.
+func spoolTarball(content io.Reader, maxSize int64, shasum string) (*spooledTarball, error) {
+	f, err := ioutil.TempFile("", "cozy-registry-tarball-")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &spooledTarball{file: f}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(content, maxSize+1))
+	if err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if n > maxSize {
+		spooled.Close()
+		return nil, errshttp.NewError(http.StatusRequestEntityTooLarge,
+			"The tarball is too large (limit is %d bytes)", maxSize)
+	}
+	spooled.size = n
+	spooled.sha256 = hex.EncodeToString(h.Sum(nil))
+
+	if shasum != "" {
+		e, _ := hex.DecodeString(shasum)
+		if !bytes.Equal(e, h.Sum(nil)) {
+			spooled.Close()
+			return nil, errshttp.NewError(http.StatusUnprocessableEntity,
+				"Checksum does not match the calculated one (expecting %q, got %q)", shasum, spooled.sha256)
+		}
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+// detectContentType guesses the content type of the tarball from its first
+// bytes.
+func (s *spooledTarball) detectContentType() string {
+	head := make([]byte, 262)
+	n, _ := s.file.ReadAt(head, 0)
+	kind, _ := filetype.Match(head[:n])
+	return kind.MIME.Value
+}
+
+// Close closes and removes the temporary file.
+func (s *spooledTarball) Close() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
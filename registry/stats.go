@@ -0,0 +1,270 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/go-kivik/kivik/v3"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrStatsDateInvalid is used when a bound of the period of the download
+// statistics is not a valid day.
+var ErrStatsDateInvalid = errshttp.NewError(http.StatusBadRequest, "Invalid date, it should be formatted as YYYY-MM-DD")
+
+// StatsDayLayout is the format of the days of the download statistics.
+const StatsDayLayout = "2006-01-02"
+
+// DefaultStatsPeriod is the number of days of the download statistics when
+// the period is not given.
+const DefaultStatsPeriod = 30
+
+// maxStatsRetries is the number of times a document is updated again when
+// there is a conflict with another registry instance.
+const maxStatsRetries = 5
+
+// DownloadStat is the number of downloads of a version of an application for
+// a day. It is the document stored in the stats database of a space.
+type DownloadStat struct {
+	ID      string `json:"_id,omitempty"`
+	Rev     string `json:"_rev,omitempty"`
+	Slug    string `json:"slug"`
+	Version string `json:"version"`
+	Day     string `json:"day"`
+	Count   int64  `json:"count"`
+}
+
+// DayDownloads is the number of downloads of an application for a day.
+type DayDownloads struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// DownloadStats are the downloads of an application during a period, in
+// total, by version and by day.
+type DownloadStats struct {
+	Slug     string           `json:"slug"`
+	Since    string           `json:"since"`
+	Until    string           `json:"until"`
+	Total    int64            `json:"total"`
+	Versions map[string]int64 `json:"versions"`
+	Days     []*DayDownloads  `json:"days"`
+}
+
+type downloadKey struct {
+	space   string
+	slug    string
+	version string
+	day     string
+}
+
+// The downloads are counted in memory, and flushed periodically to CouchDB,
+// to avoid writing a document for each download of a tarball. The totals of
+// the applications are flushed less often, as each update of an application
+// document changes its revision, and so the changes feed and the ETags.
+var (
+	downloadsMu  sync.Mutex
+	downloads    = make(map[downloadKey]int64)
+	appDownloads = make(map[downloadKey]int64)
+)
+
+// RecordDownload counts a download of the tarball of a version. It is saved
+// in CouchDB on the next flush.
+func RecordDownload(c *space.Space, appSlug, version string) {
+	key := downloadKey{
+		space:   c.Name,
+		slug:    appSlug,
+		version: version,
+		day:     time.Now().UTC().Format(StatsDayLayout),
+	}
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+	downloads[key]++
+}
+
+// FlushDownloads saves the downloads counted since the last flush in the
+// stats databases. They are added to the totals of the applications on the
+// next call to FlushAppDownloads. The downloads that cannot be saved are kept
+// for the next flush.
+func FlushDownloads() error {
+	downloadsMu.Lock()
+	pending := downloads
+	downloads = make(map[downloadKey]int64)
+	downloadsMu.Unlock()
+
+	var errm error
+	for key, count := range pending {
+		s, ok := space.GetSpace(key.space)
+		if !ok {
+			// The space has been removed
+			continue
+		}
+		err := addVersionDownloads(s, key, count)
+		downloadsMu.Lock()
+		if err != nil {
+			errm = multierror.Append(errm, err)
+			downloads[key] += count
+		} else {
+			appDownloads[downloadKey{space: key.space, slug: key.slug}] += count
+		}
+		downloadsMu.Unlock()
+	}
+	return errm
+}
+
+// FlushAppDownloads adds the downloads saved by FlushDownloads to the totals
+// of the applications. The totals that cannot be saved are kept for the next
+// flush.
+func FlushAppDownloads() error {
+	downloadsMu.Lock()
+	pending := appDownloads
+	appDownloads = make(map[downloadKey]int64)
+	downloadsMu.Unlock()
+
+	var errm error
+	for key, count := range pending {
+		s, ok := space.GetSpace(key.space)
+		if !ok {
+			// The space has been removed
+			continue
+		}
+		if err := addAppDownloads(s, key.slug, count); err != nil {
+			errm = multierror.Append(errm, err)
+			downloadsMu.Lock()
+			appDownloads[key] += count
+			downloadsMu.Unlock()
+		}
+	}
+	return errm
+}
+
+// StartDownloadsFlush launches the periodic flushes of the downloads, and of
+// the totals of the applications.
+func StartDownloadsFlush(interval, totalsInterval time.Duration) {
+	if interval <= 0 {
+		interval = base.DefaultStatsFlushInterval
+	}
+	if totalsInterval <= 0 {
+		totalsInterval = base.DefaultStatsTotalsInterval
+	}
+	go flushPeriodically(interval, FlushDownloads, "Cannot flush the download statistics")
+	go flushPeriodically(totalsInterval, FlushAppDownloads, "Cannot flush the downloads of the applications")
+}
+
+func flushPeriodically(interval time.Duration, flush func() error, msg string) {
+	for {
+		time.Sleep(interval)
+		if err := flush(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"nspace":    "stats",
+				"error_msg": err,
+			}).Error(msg)
+		}
+	}
+}
+
+func addVersionDownloads(c *space.Space, key downloadKey, count int64) error {
+	db := c.StatsDB()
+	id := getVersionID(key.slug, key.version) + "-" + key.day
+	for i := 0; ; i++ {
+		stat := DownloadStat{Slug: key.slug, Version: key.version, Day: key.day}
+		err := db.Get(context.Background(), id).ScanDoc(&stat)
+		if err != nil && kivik.StatusCode(err) != http.StatusNotFound {
+			return err
+		}
+		stat.ID = id
+		stat.Count += count
+		_, err = db.Put(context.Background(), id, stat)
+		if kivik.StatusCode(err) != http.StatusConflict || i >= maxStatsRetries {
+			return err
+		}
+	}
+}
+
+func addAppDownloads(c *space.Space, appSlug string, count int64) error {
+	db := c.AppsDB()
+	for i := 0; ; i++ {
+		app, err := findApp(c, appSlug)
+		if err == ErrAppNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		app.Downloads += count
+		_, err = db.Put(context.Background(), app.ID, app)
+		if kivik.StatusCode(err) != http.StatusConflict || i >= maxStatsRetries {
+			return err
+		}
+	}
+}
+
+// GetDownloadStats returns the downloads of an application between the two
+// given days, included. When since is empty, the period starts
+// DefaultStatsPeriod days before until, and until defaults to today.
+func GetDownloadStats(c *space.Space, appSlug, since, until string) (*DownloadStats, error) {
+	end := time.Now().UTC()
+	if until != "" {
+		var err error
+		if end, err = time.Parse(StatsDayLayout, until); err != nil {
+			return nil, ErrStatsDateInvalid
+		}
+	}
+	start := end.AddDate(0, 0, -DefaultStatsPeriod+1)
+	if since != "" {
+		var err error
+		if start, err = time.Parse(StatsDayLayout, since); err != nil {
+			return nil, ErrStatsDateInvalid
+		}
+	}
+
+	stats := &DownloadStats{
+		Slug:     appSlug,
+		Since:    start.Format(StatsDayLayout),
+		Until:    end.Format(StatsDayLayout),
+		Versions: make(map[string]int64),
+		Days:     make([]*DayDownloads, 0),
+	}
+	rows, err := c.StatsDB().Find(context.Background(), map[string]interface{}{
+		"selector": map[string]interface{}{
+			"slug": appSlug,
+			"day": map[string]interface{}{
+				"$gte": stats.Since,
+				"$lte": stats.Until,
+			},
+		},
+		"limit": 100000,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make(map[string]int64)
+	for rows.Next() {
+		var stat DownloadStat
+		if err = rows.ScanDoc(&stat); err != nil {
+			return nil, err
+		}
+		stats.Total += stat.Count
+		stats.Versions[stat.Version] += stat.Count
+		days[stat.Day] += stat.Count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for day, count := range days {
+		stats.Days = append(stats.Days, &DayDownloads{Day: day, Count: count})
+	}
+	sort.Slice(stats.Days, func(i, j int) bool {
+		return stats.Days[i].Day < stats.Days[j].Day
+	})
+	return stats, nil
+}
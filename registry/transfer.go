@@ -0,0 +1,137 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/cozy/cozy-apps-registry/webhook"
+	"github.com/go-kivik/kivik/v3"
+)
+
+// ErrAppSameEditor is used when an application is transferred to the editor
+// that already owns it.
+var ErrAppSameEditor = errshttp.NewError(http.StatusBadRequest, "Application already belongs to this editor")
+
+// Transfer is the change of the editor of an application, recorded in its
+// review history and sent to the webhooks.
+type Transfer struct {
+	PreviousEditor string `json:"previous_editor"`
+	Editor         string `json:"editor"`
+}
+
+// TransferApp gives an application, with all its versions, to another editor.
+// The tokens of the previous editor for this application are revoked, and the
+// transfer is recorded in the review history of the application with the
+// given actor.
+func TransferApp(c *space.Space, appSlug string, editor *auth.Editor, actor string) (*App, error) {
+	app, err := findApp(c, appSlug)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(app.Editor, editor.Name()) {
+		return nil, ErrAppSameEditor
+	}
+	previous, err := auth.Editors.GetEditor(app.Editor)
+	if err != nil && err != auth.ErrEditorNotFound {
+		return nil, err
+	}
+	transfer := &Transfer{PreviousEditor: app.Editor, Editor: editor.Name()}
+
+	app.Editor = editor.Name()
+	if _, err = c.AppsDB().Put(context.Background(), app.ID, app); err != nil {
+		return nil, err
+	}
+
+	dbs := []*kivik.DB{c.VersDB(), c.PendingVersDB()}
+	for _, v := range base.Config.VirtualSpaces {
+		if v.Source == c.Name {
+			dbs = append(dbs, v.VersionDB())
+		}
+	}
+	for _, db := range dbs {
+		if err = transferVersions(db, appSlug, editor.Name()); err != nil {
+			return nil, err
+		}
+	}
+	for _, channel := range Channels {
+		key := base.NewKey(c.Name, appSlug, ChannelToStr(channel))
+		base.LatestVersionsCache.Remove(key)
+		base.ListVersionsCache.Remove(key)
+	}
+	reindexApp(c, appSlug)
+
+	if previous != nil {
+		if err = auth.Editors.RevokeAppTokens(previous, appSlug); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	event := &ReviewEvent{
+		ID:             fmt.Sprintf("%s-transfer-%d", appSlug, now.UnixNano()),
+		Slug:           appSlug,
+		Action:         ReviewTransferred,
+		Reviewer:       actor,
+		PreviousEditor: transfer.PreviousEditor,
+		Editor:         transfer.Editor,
+		CreatedAt:      now,
+	}
+	if err = saveReviewEvent(c, event); err != nil {
+		return nil, err
+	}
+	sendAppEvent(webhook.AppTransferred, c, appSlug, transfer)
+	return app, nil
+}
+
+// transferVersions changes the editor of the versions of the application in
+// the given database.
+func transferVersions(db *kivik.DB, appSlug, editorName string) error {
+	rows, err := db.Find(context.Background(), map[string]interface{}{
+		"selector": map[string]interface{}{"slug": appSlug},
+		"limit":    10000,
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// The documents are kept as maps to not lose the fields that are not
+	// known by the Version struct
+	var docs []interface{}
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err = rows.ScanDoc(&doc); err != nil {
+			return err
+		}
+		if doc["editor"] == editorName {
+			continue
+		}
+		doc["editor"] = editorName
+		docs = append(docs, doc)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	results, err := db.BulkDocs(context.Background(), docs)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for results.Next() {
+		if err = results.UpdateErr(); err != nil {
+			return err
+		}
+	}
+	return results.Err()
+}
@@ -10,3 +10,18 @@ func TestGetMIMEType(t *testing.T) {
 	mime := getMIMEType("icon.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 32 32"></svg>`))
 	assert.Equal(t, "image/svg+xml", mime)
 }
+
+func TestSplitVersionID(t *testing.T) {
+	slug, version, ok := splitVersionID("drive-1.2.3")
+	assert.True(t, ok)
+	assert.Equal(t, "drive", slug)
+	assert.Equal(t, "1.2.3", version)
+
+	slug, version, ok = splitVersionID("konnector-2fa-1.0.0-beta.2")
+	assert.True(t, ok)
+	assert.Equal(t, "konnector-2fa", slug)
+	assert.Equal(t, "1.0.0-beta.2", version)
+
+	_, _, ok = splitVersionID("_design/versions")
+	assert.False(t, ok)
+}
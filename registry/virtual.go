@@ -104,7 +104,7 @@ func deleteOverwrittenTarball(s base.VirtualSpace, version *Version) error {
 	return base.Storage.Remove(prefix, h)
 }
 
-func getOriginalTarball(space *space.Space, version *Version) (io.Reader, error) {
+func getOriginalTarball(space *space.Space, version *Version) (io.ReadCloser, error) {
 	url, err := url.Parse(version.URL)
 	if err != nil {
 		return nil, err
@@ -299,6 +299,7 @@ func RegenerateOverwrittenTarballs(virtualSpaceName string, appSlug string) (err
 		if err != nil {
 			return err
 		}
+		defer tarball.Close()
 
 		prefix := fmt.Sprintf("%s_%s_*.tar.gz", lastVersion.Slug, lastVersion.Version)
 		file, err := ioutil.TempFile("", prefix)
@@ -330,6 +331,9 @@ func RegenerateOverwrittenTarballs(virtualSpaceName string, appSlug string) (err
 		newVersion.AttachmentReferences = map[string]string{"tarball": hash}
 		newVersion.Size = size
 		newVersion.Sha256 = hash
+		// The tarball has been modified, so the signature of the editor is no
+		// longer valid for it
+		newVersion.Signature = ""
 
 		u, err := url.Parse(newVersion.URL)
 		if err != nil {
@@ -417,17 +421,12 @@ func FindAttachmentFromOverwrite(space *base.VirtualSpace, appSlug, filename str
 		return nil, false, nil
 	}
 
-	content, headers, err := base.GlobalAssetStore.Get(shasum)
+	obj, err := base.GlobalAssetStore.Open(shasum)
 	if err != nil {
 		return nil, false, err
 	}
 
-	return &Attachment{
-		ContentType:   headers["Content-Type"],
-		Content:       content,
-		Etag:          headers["Etag"],
-		ContentLength: headers["Content-Length"],
-	}, true, nil
+	return newAttachment(obj), true, nil
 }
 
 func FindOverwrittenVersion(space *base.VirtualSpace, version *Version) (*Version, error) {
@@ -462,17 +461,12 @@ func FindOverwrittenTarball(space *base.VirtualSpace, version *Version) (*Attach
 	}
 
 	prefix := base.Prefix(space.Name)
-	content, headers, err := base.Storage.Get(prefix, checksum)
+	obj, err := base.Storage.Open(prefix, checksum)
 	if err != nil {
 		return nil, false, err
 	}
 
-	return &Attachment{
-		ContentType:   headers["Content-Type"],
-		Content:       content,
-		Etag:          headers["Etag"],
-		ContentLength: headers["Content-Length"],
-	}, true, nil
+	return newAttachment(obj), true, nil
 }
 
 // OverwriteAppName tells that an app will have a different name in the virtual
@@ -580,6 +574,34 @@ func DeactivateMaintenanceVirtualSpace(virtualSpaceName, appSlug string) error {
 	return err
 }
 
+// overrideAppMaintenance sets the maintenance of the application to the one
+// activated in the virtual space, if any.
+func overrideAppMaintenance(virtualSpace *base.VirtualSpace, app *App) error {
+	db, err := getDBForVirtualSpace(virtualSpace.Name)
+	if err != nil {
+		return err
+	}
+	overwrite, ok, err := findOverwrite(db, app.Slug)
+	if err != nil || !ok {
+		return err
+	}
+	if activated, _ := overwrite["maintenance_activated"].(bool); !activated {
+		return nil
+	}
+
+	var opts MaintenanceOptions
+	j, err := json.Marshal(overwrite["maintenance_options"])
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(j, &opts); err != nil {
+		return err
+	}
+	app.MaintenanceActivated = true
+	app.MaintenanceOptions = &opts
+	return nil
+}
+
 func getDBForVirtualSpace(virtualSpaceName string) (*kivik.DB, error) {
 	dbName := base.VirtualDBName(virtualSpaceName)
 	ok, err := base.DBClient.DBExists(context.Background(), dbName)
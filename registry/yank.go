@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/space"
+	"github.com/cozy/cozy-apps-registry/webhook"
+)
+
+var (
+	ErrYankReasonMissing = errshttp.NewError(http.StatusBadRequest, "A reason is required to yank a version")
+	ErrVersionYanked     = errshttp.NewError(http.StatusConflict, "Version is already yanked")
+	ErrVersionNotYanked  = errshttp.NewError(http.StatusConflict, "Version is not yanked")
+)
+
+// Yank is set on a version that should not be installed anymore. A yanked
+// version is not listed in the versions of its application and is never
+// the latest version of a channel, but it is kept with its tarball for the
+// instances that still use it.
+type Yank struct {
+	Reason   string    `json:"reason"`
+	YankedAt time.Time `json:"yanked_at"`
+}
+
+// YankVersion marks a version as yanked, with the reason given by the editor.
+func YankVersion(c *space.Space, appSlug, version, reason string) (*Version, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrYankReasonMissing
+	}
+	ver, err := FindPublishedVersion(c, appSlug, version)
+	if err != nil {
+		return nil, err
+	}
+	if ver.Yanked != nil {
+		return nil, ErrVersionYanked
+	}
+	ver.Yanked = &Yank{
+		Reason:   reason,
+		YankedAt: time.Now().UTC(),
+	}
+	if err = saveYankedVersion(c, ver); err != nil {
+		return nil, err
+	}
+	sendVersionEvent(webhook.VersionYanked, c, ver)
+	return ver, nil
+}
+
+// UnyankVersion makes a yanked version available again.
+func UnyankVersion(c *space.Space, appSlug, version string) (*Version, error) {
+	ver, err := FindPublishedVersion(c, appSlug, version)
+	if err != nil {
+		return nil, err
+	}
+	if ver.Yanked == nil {
+		return nil, ErrVersionNotYanked
+	}
+	ver.Yanked = nil
+	if err = saveYankedVersion(c, ver); err != nil {
+		return nil, err
+	}
+	sendVersionEvent(webhook.VersionUnyanked, c, ver)
+	return ver, nil
+}
+
+func saveYankedVersion(c *space.Space, ver *Version) error {
+	rev, err := c.VersDB().Put(context.Background(), ver.ID, ver)
+	if err != nil {
+		return err
+	}
+	ver.Rev = rev
+
+	// The version can be the latest one, or be listed, for its channel and
+	// the channels above
+	versionChannel := GetVersionChannel(ver.Version)
+	for _, channel := range Channels {
+		if channel >= versionChannel {
+			key := base.NewKey(c.Name, ver.Slug, ChannelToStr(channel))
+			base.LatestVersionsCache.Remove(key)
+			base.ListVersionsCache.Remove(key)
+		}
+	}
+	updateAppFacets(c, ver.Slug)
+	reindexApp(c, ver.Slug)
+	return nil
+}
@@ -0,0 +1,303 @@
+package schema
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CurrentVersion is the version of the manifest schemas used to validate the
+// versions published on the registry. When the rules change, a new version
+// of the schemas is added, and the old ones are kept so that editors can
+// still check a tarball against them.
+const CurrentVersion = 1
+
+var manifestSchemas = map[string][]*Schema{
+	"webapp":    {MustParse(webappSchemaV1)},
+	"konnector": {MustParse(konnectorSchemaV1)},
+}
+
+// Error is returned when a manifest doesn't respect its schema. It lists all
+// the violations.
+type Error struct {
+	AppType       string      `json:"type"`
+	SchemaVersion int         `json:"schema_version"`
+	Violations    []Violation `json:"violations"`
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return fmt.Sprintf("The manifest.%s is invalid (schema v%d): %s",
+		e.AppType, e.SchemaVersion, strings.Join(msgs, "; "))
+}
+
+// StatusCode returns the HTTP status code for this error.
+func (e *Error) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+// ManifestSchema returns the schema for the manifests of the given type of
+// application (webapp or konnector), in the given version.
+func ManifestSchema(appType string, version int) (*Schema, error) {
+	versions, ok := manifestSchemas[appType]
+	if !ok {
+		return nil, fmt.Errorf("No schema for the applications of type %q", appType)
+	}
+	if version < 1 || version > len(versions) {
+		return nil, fmt.Errorf("No schema version %d for the %s manifests (latest is %d)",
+			version, appType, len(versions))
+	}
+	return versions[version-1], nil
+}
+
+// ValidateManifest checks the content of a manifest with the current version
+// of the schema for its type of application. It returns an *Error if the
+// manifest is invalid.
+func ValidateManifest(appType string, content []byte) error {
+	return ValidateManifestWithVersion(appType, content, CurrentVersion)
+}
+
+// ValidateManifestWithVersion is like ValidateManifest, with the version of
+// the schema to use.
+func ValidateManifestWithVersion(appType string, content []byte, version int) error {
+	s, err := ManifestSchema(appType, version)
+	if err != nil {
+		return err
+	}
+	violations, err := s.Validate(content)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &Error{
+			AppType:       appType,
+			SchemaVersion: version,
+			Violations:    violations,
+		}
+	}
+	return nil
+}
+
+// The definitions and properties shared by the webapp and konnector
+// manifests, in the version 1 of the schemas.
+const (
+	commonDefinitionsV1 = `
+		"stringList": {
+			"type": "array",
+			"items": {"type": "string"}
+		},
+		"permissions": {
+			"type": "object",
+			"additionalProperties": {
+				"type": "object",
+				"required": ["type"],
+				"properties": {
+					"type": {"type": "string", "pattern": "^[a-zA-Z0-9_-]+(\\.[a-zA-Z0-9_-]+)+(\\.\\*)?$"},
+					"description": {"type": "string"},
+					"verbs": {
+						"type": "array",
+						"minItems": 1,
+						"items": {"enum": ["ALL", "GET", "POST", "PUT", "PATCH", "DELETE"]}
+					},
+					"selector": {"type": "string"},
+					"values": {"$ref": "#/definitions/stringList"},
+					"remote": {"type": "boolean"}
+				}
+			}
+		},
+		"locale": {
+			"type": "object",
+			"properties": {
+				"name": {"type": "string"},
+				"name_prefix": {"type": "string"},
+				"short_description": {"type": "string"},
+				"long_description": {"type": "string"},
+				"changes": {"type": "string"},
+				"screenshots": {"$ref": "#/definitions/stringList"},
+				"fields": {"type": "object"},
+				"folders": {"type": ["object", "array"]}
+			}
+		},
+		"locales": {
+			"type": "object",
+			"minProperties": 1,
+			"patternProperties": {
+				"^[a-z]{2,3}([_-][A-Za-z]{4})?([_-]([A-Za-z]{2}|[0-9]{3}))?$": {"$ref": "#/definitions/locale"}
+			},
+			"additionalProperties": false
+		},
+		"terms": {
+			"type": "object",
+			"required": ["url", "version", "id"],
+			"properties": {
+				"url": {"type": "string", "minLength": 1},
+				"version": {"type": "string", "pattern": "^[^*+~.()'\"!:@]+$"},
+				"id": {"type": "string", "pattern": "^[^*+~.()'\"!:@]+$"}
+			}
+		}`
+
+	commonPropertiesV1 = `
+		"name": {"type": "string", "minLength": 1},
+		"name_prefix": {"type": "string"},
+		"slug": {"type": "string", "pattern": "^[a-z0-9][a-z0-9-]*$"},
+		"editor": {"type": "string", "minLength": 1},
+		"version": {"type": "string", "minLength": 1},
+		"manifest_version": {"type": ["string", "integer"]},
+		"icon": {"type": "string"},
+		"categories": {"$ref": "#/definitions/stringList"},
+		"tags": {"$ref": "#/definitions/stringList"},
+		"langs": {"$ref": "#/definitions/stringList"},
+		"screenshots": {"$ref": "#/definitions/stringList"},
+		"license": {"type": "string"},
+		"source": {"type": "string"},
+		"developer": {
+			"type": "object",
+			"properties": {
+				"name": {"type": "string"},
+				"url": {"type": "string"}
+			}
+		},
+		"partnership": {
+			"type": "object",
+			"properties": {
+				"icon": {"type": "string"},
+				"description": {"type": "string"},
+				"name": {"type": "string"},
+				"domain": {"type": "string"}
+			}
+		},
+		"aggregator": {"type": "object"},
+		"terms": {"$ref": "#/definitions/terms"},
+		"locales": {"$ref": "#/definitions/locales"},
+		"permissions": {"$ref": "#/definitions/permissions"}`
+)
+
+const webappSchemaV1 = `{
+	"$id": "https://apps-registry.cozycloud.cc/schemas/v1/manifest.webapp.json",
+	"type": "object",
+	"required": ["name", "slug", "editor", "version", "locales"],
+	"definitions": {` + commonDefinitionsV1 + `
+	},
+	"properties": {` + commonPropertiesV1 + `,
+		"type": {"enum": ["webapp"]},
+		"routes": {
+			"type": "object",
+			"additionalProperties": {
+				"type": "object",
+				"required": ["folder"],
+				"properties": {
+					"folder": {"type": "string"},
+					"index": {"type": "string"},
+					"public": {"type": "boolean"}
+				}
+			}
+		},
+		"services": {
+			"type": "object",
+			"additionalProperties": {
+				"type": "object",
+				"required": ["type", "file"],
+				"properties": {
+					"type": {"enum": ["node"]},
+					"file": {"type": "string", "minLength": 1},
+					"trigger": {"type": "string"},
+					"debounce": {"type": "string"}
+				}
+			}
+		},
+		"intents": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["action", "href"],
+				"properties": {
+					"action": {"type": "string", "minLength": 1},
+					"type": {"$ref": "#/definitions/stringList"},
+					"href": {"type": "string", "minLength": 1}
+				}
+			}
+		},
+		"platforms": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["type"],
+				"properties": {
+					"type": {"type": "string", "minLength": 1},
+					"url": {"type": "string"}
+				}
+			}
+		}
+	}
+}`
+
+const konnectorSchemaV1 = `{
+	"$id": "https://apps-registry.cozycloud.cc/schemas/v1/manifest.konnector.json",
+	"type": "object",
+	"required": ["name", "slug", "editor", "version", "locales"],
+	"definitions": {` + commonDefinitionsV1 + `
+	},
+	"properties": {` + commonPropertiesV1 + `,
+		"type": {"enum": ["konnector"]},
+		"language": {"type": "string"},
+		"vendor_link": {"type": "string"},
+		"uuid": {"type": "string"},
+		"frequency": {"enum": ["monthly", "weekly", "daily"]},
+		"data_types": {"$ref": "#/definitions/stringList"},
+		"messages": {"$ref": "#/definitions/stringList"},
+		"parameters": {"type": "object"},
+		"oauth": {
+			"type": "object",
+			"properties": {
+				"scope": {"type": ["string", "array", "boolean", "null"]}
+			}
+		},
+		"time_interval": {
+			"type": "array",
+			"minItems": 2,
+			"maxItems": 2,
+			"items": {"type": "integer", "minimum": 0, "maximum": 24}
+		},
+		"folders": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["defaultDir"],
+				"properties": {
+					"defaultDir": {"type": "string", "minLength": 1}
+				}
+			}
+		},
+		"fields": {
+			"type": "object",
+			"additionalProperties": {
+				"type": "object",
+				"properties": {
+					"type": {"enum": ["text", "email", "password", "hidden", "dropdown", "checkbox", "date"]},
+					"label": {"type": "string"},
+					"description": {"type": "string"},
+					"identifier": {"type": "boolean"},
+					"advanced": {"type": "boolean"},
+					"isRequired": {"type": "boolean"},
+					"pattern": {"type": "string"},
+					"min": {"type": "integer", "minimum": 0},
+					"max": {"type": "integer", "minimum": 0},
+					"options": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"required": ["value"],
+							"properties": {
+								"name": {"type": "string"},
+								"value": {"type": "string"}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
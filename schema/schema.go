@@ -0,0 +1,329 @@
+// Package schema is used to validate the manifests of the applications
+// against JSON schemas. Only the subset of JSON Schema used by the schemas of
+// the registry is supported: type, enum, pattern, minLength, minimum,
+// maximum, required, properties, patternProperties, additionalProperties,
+// minProperties, items, minItems, maxItems, definitions and local $ref.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Violation is a part of a document that doesn't respect the schema.
+type Violation struct {
+	// Path is a JSON pointer to the invalid value, like /permissions/files
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	path := v.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + v.Message
+}
+
+// Schema is a parsed JSON schema.
+type Schema struct {
+	ID                   string             `json:"$id"`
+	Ref                  string             `json:"$ref"`
+	Definitions          map[string]*Schema `json:"definitions"`
+	Type                 typeList           `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	MinLength            *int               `json:"minLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	PatternProperties    map[string]*Schema `json:"patternProperties"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+	MinProperties        *int               `json:"minProperties"`
+	Items                *Schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+
+	// never is true for the false schema, that doesn't accept any value
+	never    bool
+	regexp   *regexp.Regexp
+	patterns map[*regexp.Regexp]*Schema
+}
+
+// typeList is the type keyword, which can be a string or a list of strings.
+type typeList []string
+
+func (t *typeList) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = typeList{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*t = names
+	return nil
+}
+
+// UnmarshalJSON accepts the boolean schemas in addition to the objects.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{never: true}
+		return nil
+	}
+	type plain Schema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// Parse parses a JSON schema and compiles its patterns.
+func Parse(raw []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// MustParse is like Parse but panics on error. It is used for the schemas
+// defined in the code.
+func MustParse(raw string) *Schema {
+	s, err := Parse([]byte(raw))
+	if err != nil {
+		panic(fmt.Errorf("schema: invalid schema: %s", err))
+	}
+	return s
+}
+
+func (s *Schema) compile(root *Schema) error {
+	if s.Ref != "" {
+		if _, err := root.resolve(s.Ref); err != nil {
+			return err
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.regexp = re
+	}
+	if len(s.PatternProperties) > 0 {
+		s.patterns = make(map[*regexp.Regexp]*Schema, len(s.PatternProperties))
+		for pattern, sub := range s.PatternProperties {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			s.patterns[re] = sub
+		}
+	}
+	for _, subs := range []map[string]*Schema{s.Definitions, s.Properties, s.PatternProperties} {
+		for _, sub := range subs {
+			if err := sub.compile(root); err != nil {
+				return err
+			}
+		}
+	}
+	for _, sub := range []*Schema{s.AdditionalProperties, s.Items} {
+		if sub != nil {
+			if err := sub.compile(root); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) resolve(ref string) (*Schema, error) {
+	name := strings.TrimPrefix(ref, "#/definitions/")
+	if def, ok := s.Definitions[name]; ok && name != ref {
+		return def, nil
+	}
+	return nil, fmt.Errorf("unknown reference %q", ref)
+}
+
+// Validate checks a JSON document against the schema, and returns all the
+// violations.
+func (s *Schema) Validate(raw []byte) ([]Violation, error) {
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	v := &validator{root: s}
+	v.validate(s, doc, "")
+	return v.violations, nil
+}
+
+type validator struct {
+	root       *Schema
+	violations []Violation
+}
+
+func (v *validator) fail(path, format string, a ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Path:    path,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (v *validator) validate(s *Schema, doc interface{}, path string) {
+	if s.Ref != "" {
+		// The references have been checked when the schema was compiled
+		s, _ = v.root.resolve(s.Ref)
+	}
+	if s.never {
+		v.fail(path, "is not allowed")
+		return
+	}
+	if len(s.Type) > 0 && !s.Type.match(doc) {
+		v.fail(path, "must be of type %s, not %s", strings.Join(s.Type, " or "), typeOf(doc))
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, doc) {
+		v.fail(path, "must be one of %s", formatEnum(s.Enum))
+	}
+
+	switch val := doc.(type) {
+	case string:
+		if s.MinLength != nil && len([]rune(val)) < *s.MinLength {
+			if *s.MinLength == 1 {
+				v.fail(path, "must not be empty")
+			} else {
+				v.fail(path, "must have at least %d characters", *s.MinLength)
+			}
+		}
+		if s.regexp != nil && !s.regexp.MatchString(val) {
+			v.fail(path, "must match the pattern %s", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			v.fail(path, "must be greater than or equal to %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			v.fail(path, "must be less than or equal to %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			v.fail(path, "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			v.fail(path, "must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				v.validate(s.Items, item, fmt.Sprintf("%s/%d", path, i))
+			}
+		}
+	case map[string]interface{}:
+		v.validateObject(s, val, path)
+	}
+}
+
+func (v *validator) validateObject(s *Schema, obj map[string]interface{}, path string) {
+	if s.MinProperties != nil && len(obj) < *s.MinProperties {
+		v.fail(path, "must have at least %d properties", *s.MinProperties)
+	}
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			v.fail(path+"/"+escapePointer(name), "is required")
+		}
+	}
+
+	// The keys are sorted to have the violations in a stable order
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		val := obj[key]
+		subpath := path + "/" + escapePointer(key)
+		matched := false
+		if sub, ok := s.Properties[key]; ok {
+			matched = true
+			v.validate(sub, val, subpath)
+		}
+		for re, sub := range s.patterns {
+			if re.MatchString(key) {
+				matched = true
+				v.validate(sub, val, subpath)
+			}
+		}
+		if !matched && s.AdditionalProperties != nil {
+			v.validate(s.AdditionalProperties, val, subpath)
+		}
+	}
+}
+
+func (t typeList) match(doc interface{}) bool {
+	actual := typeOf(doc)
+	for _, name := range t {
+		if name == actual {
+			return true
+		}
+		if name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(doc interface{}) string {
+	switch val := doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", doc)
+}
+
+func inEnum(enum []interface{}, doc interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		raw, _ := json.Marshal(e)
+		values[i] = string(raw)
+	}
+	return strings.Join(values, ", ")
+}
+
+// escapePointer escapes a key for a JSON pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,110 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSubset(t *testing.T) {
+	s := MustParse(`{
+		"type": "object",
+		"required": ["name"],
+		"definitions": {"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}},
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"kind": {"enum": ["a", "b"]},
+			"tags": {"$ref": "#/definitions/tags"},
+			"size": {"type": "integer", "minimum": 1}
+		},
+		"patternProperties": {"^x-": {"type": "boolean"}},
+		"additionalProperties": false
+	}`)
+
+	violations, err := s.Validate([]byte(`{"name": "foo", "kind": "a", "tags": ["x"], "size": 2, "x-debug": true}`))
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = s.Validate([]byte(`{"kind": "c", "tags": ["x", 1, "z"], "size": 1.5, "x-debug": "yes", "other": 1}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Violation{
+		{Path: "/name", Message: "is required"},
+		{Path: "/kind", Message: `must be one of "a", "b"`},
+		{Path: "/other", Message: "is not allowed"},
+		{Path: "/size", Message: "must be of type integer, not number"},
+		{Path: "/tags", Message: "must have at most 2 items"},
+		{Path: "/tags/1", Message: "must be of type string, not integer"},
+		{Path: "/x-debug", Message: "must be of type boolean, not string"},
+	}, violations)
+
+	_, err = Parse([]byte(`{"$ref": "#/definitions/missing"}`))
+	assert.Error(t, err)
+}
+
+func TestValidateManifest(t *testing.T) {
+	webapp := []byte(`{
+		"name": "Drive",
+		"slug": "drive",
+		"editor": "Cozy",
+		"version": "1.2.3",
+		"type": "webapp",
+		"locales": {"en": {"short_description": "Files"}, "fr": {}},
+		"permissions": {"files": {"type": "io.cozy.files", "verbs": ["GET"]}},
+		"routes": {"/": {"folder": "/", "index": "index.html", "public": false}}
+	}`)
+	assert.NoError(t, ValidateManifest("webapp", webapp))
+
+	konnector := []byte(`{
+		"name": "Trainline",
+		"slug": "trainline",
+		"editor": "Cozy",
+		"version": "1.0.0",
+		"type": "webapp",
+		"locales": {"english": {}},
+		"permissions": {"bills": {"verbs": ["READ"]}},
+		"fields": {"login": {"type": "text"}, "password": {"type": "secret"}},
+		"time_interval": [15]
+	}`)
+	err := ValidateManifest("konnector", konnector)
+	require.Error(t, err)
+	schemaErr, ok := err.(*Error)
+	require.True(t, ok)
+	assert.Equal(t, 422, schemaErr.StatusCode())
+	assert.Equal(t, CurrentVersion, schemaErr.SchemaVersion)
+	assert.Equal(t, []Violation{
+		{Path: "/fields/password/type", Message: `must be one of "text", "email", "password", "hidden", "dropdown", "checkbox", "date"`},
+		{Path: "/locales/english", Message: "is not allowed"},
+		{Path: "/permissions/bills/type", Message: "is required"},
+		{Path: "/permissions/bills/verbs/0", Message: `must be one of "ALL", "GET", "POST", "PUT", "PATCH", "DELETE"`},
+		{Path: "/time_interval", Message: "must have at least 2 items"},
+		{Path: "/type", Message: `must be one of "konnector"`},
+	}, schemaErr.Violations)
+	assert.Contains(t, err.Error(), "/locales/english: is not allowed")
+
+	for _, locale := range []string{"en", "pt_BR", "en-US", "zh-Hant", "zh-Hant-TW", "es-419", "ast"} {
+		manifest := []byte(`{
+			"name": "Drive",
+			"slug": "drive",
+			"editor": "Cozy",
+			"version": "1.2.3",
+			"locales": {"` + locale + `": {}}
+		}`)
+		assert.NoError(t, ValidateManifest("webapp", manifest), locale)
+	}
+	for _, locale := range []string{"english", "zh-Hantx", "en-", "EN"} {
+		manifest := []byte(`{
+			"name": "Drive",
+			"slug": "drive",
+			"editor": "Cozy",
+			"version": "1.2.3",
+			"locales": {"` + locale + `": {}}
+		}`)
+		assert.Error(t, ValidateManifest("webapp", manifest), locale)
+	}
+
+	_, err = ManifestSchema("webapp", 2)
+	assert.Error(t, err)
+	_, err = ManifestSchema("plugin", 1)
+	assert.Error(t, err)
+}
@@ -14,6 +14,8 @@ const (
 	appsDBSuffix        = "apps"
 	versDBSuffix        = "versions"
 	pendingVersDBSuffix = "pending"
+	reviewsDBSuffix     = "reviews"
+	statsDBSuffix       = "stats"
 )
 
 var validSpaceReg = regexp.MustCompile(`^[a-z]+[a-z0-9\_\-]*$`)
@@ -25,8 +27,18 @@ var AppsIndexes = map[string][]string{
 	"editor":      {"editor", "slug", "type"},
 	"created_at":  {"created_at", "slug", "editor", "type"},
 	"maintenance": {"maintenance_activated"},
+	"label":       {"label", "slug", "editor", "type"},
+	"downloads":   {"downloads", "slug", "editor", "type"},
+	"category":    {"categories", "slug"},
+	"tag":         {"tags", "slug"},
+	"locale":      {"locales", "slug"},
+	"permission":  {"permissions", "remote_doctypes", "slug"},
 }
 
+// statsIndexName is the name of the index used to find the download
+// statistics of an application.
+const statsIndexName = "stats-index-by-slug-and-day"
+
 // AppIndexName returns the long name of the index.
 func AppIndexName(name string) string {
 	return "apps-index-by-" + name + "-v2"
@@ -40,6 +52,8 @@ type Space struct {
 	dbApps        *kivik.DB
 	dbVers        *kivik.DB
 	dbPendingVers *kivik.DB
+	dbReviews     *kivik.DB
+	dbStats       *kivik.DB
 }
 
 // NewSpace returns a space with the given name.
@@ -48,7 +62,7 @@ func NewSpace(name string) *Space {
 }
 
 func (s *Space) init() (err error) {
-	for _, suffix := range []string{appsDBSuffix, versDBSuffix, pendingVersDBSuffix} {
+	for _, suffix := range []string{appsDBSuffix, versDBSuffix, pendingVersDBSuffix, reviewsDBSuffix, statsDBSuffix} {
 		var ok bool
 		dbName := s.dbName(suffix)
 		ok, err = base.DBClient.DBExists(context.Background(), dbName)
@@ -74,6 +88,10 @@ func (s *Space) init() (err error) {
 			s.dbVers = db
 		case pendingVersDBSuffix:
 			s.dbPendingVers = db
+		case reviewsDBSuffix:
+			s.dbReviews = db
+		case statsDBSuffix:
+			s.dbStats = db
 		default:
 			panic("unreachable")
 		}
@@ -88,6 +106,13 @@ func (s *Space) init() (err error) {
 		}
 	}
 
+	err = s.StatsDB().CreateIndex(context.Background(), statsIndexName, statsIndexName,
+		echo.Map{"fields": []string{"slug", "day"}})
+	if err != nil {
+		err = fmt.Errorf("Error while creating index %q: %w", statsIndexName, err)
+		return
+	}
+
 	return CreateVersionsDateView(s.VersDB())
 }
 
@@ -102,6 +127,8 @@ func (s *Space) Clone(name string) Space {
 		dbApps:        s.dbApps,
 		dbVers:        s.dbVers,
 		dbPendingVers: s.dbPendingVers,
+		dbReviews:     s.dbReviews,
+		dbStats:       s.dbStats,
 	}
 }
 
@@ -120,9 +147,21 @@ func (s *Space) PendingVersDB() *kivik.DB {
 	return s.dbPendingVers
 }
 
-// DBs returns the three databases used by this space.
+// ReviewsDB returns the database used for storing the history of the
+// approvals and rejections of the pending versions in this space.
+func (s *Space) ReviewsDB() *kivik.DB {
+	return s.dbReviews
+}
+
+// StatsDB returns the database used for storing the number of downloads of
+// the versions, per day, in this space.
+func (s *Space) StatsDB() *kivik.DB {
+	return s.dbStats
+}
+
+// DBs returns the five databases used by this space.
 func (s *Space) DBs() []*kivik.DB {
-	return []*kivik.DB{s.AppsDB(), s.VersDB(), s.PendingVersDB()}
+	return []*kivik.DB{s.AppsDB(), s.VersDB(), s.PendingVersDB(), s.ReviewsDB(), s.StatsDB()}
 }
 
 func (s *Space) dbName(suffix string) string {
@@ -56,7 +56,7 @@ function expandVersion(doc) {
 	devView = `
 function(doc) {
   ` + viewsHelpers + `
-  if (doc.slug != %q) {
+  if (doc.slug != %q || doc.yanked) {
     return
   }
   var version = expandVersion(doc);
@@ -67,7 +67,7 @@ function(doc) {
 	betaView = `
 function(doc) {
   ` + viewsHelpers + `
-  if (doc.slug != %q) {
+  if (doc.slug != %q || doc.yanked) {
     return
   }
   var version = expandVersion(doc);
@@ -81,7 +81,7 @@ function(doc) {
 	stableView = `
 function(doc) {
   ` + viewsHelpers + `
-  if (doc.slug != %q) {
+  if (doc.slug != %q || doc.yanked) {
     return
   }
   var version = expandVersion(doc);
@@ -104,7 +104,7 @@ var versionsViews = map[string]view{
 }
 
 func VersViewDocName(appSlug string) string {
-	return "versions-" + appSlug + "-v2"
+	return "versions-" + appSlug + "-v3"
 }
 
 func CreateVersionsViews(c *Space, db *kivik.DB, appSlug string) error {
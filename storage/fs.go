@@ -109,6 +109,30 @@ func (m *localFS) Get(prefix base.Prefix, name string) (*bytes.Buffer, map[strin
 	return buf, headers, nil
 }
 
+func (m *localFS) Open(prefix base.Prefix, name string) (*base.Object, error) {
+	path, err := m.getPath(prefix, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, base.NewFileNotFoundError(err)
+		}
+		return nil, base.NewInternalError(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, base.NewInternalError(err)
+	}
+	obj := &base.Object{File: f, Size: info.Size()}
+	if mime, err := xattr.Get(path, xattrMime); err == nil {
+		obj.ContentType = string(mime)
+	}
+	return obj, nil
+}
+
 func (m *localFS) Remove(prefix base.Prefix, name string) error {
 	path, err := m.getPath(prefix, name)
 	if err != nil {
@@ -126,17 +150,21 @@ func (m *localFS) Remove(prefix base.Prefix, name string) error {
 func (m *localFS) Walk(prefix base.Prefix, fn base.WalkFn) error {
 	dir := filepath.Join(m.baseDir, string(prefix))
 
-	return filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return base.NewFileNotFoundError(err)
+			}
 			return err
 		}
+		// Only the files are given to fn, not the directories of their path
+		if info.IsDir() {
+			return nil
+		}
 		name, err := filepath.Rel(dir, path)
 		if err != nil {
 			return err
 		}
-		if name == "." {
-			return nil
-		}
 		contentType := "application/octet-stream"
 		if mime, err := xattr.Get(path, xattrMime); err == nil {
 			contentType = string(mime)
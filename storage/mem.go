@@ -78,6 +78,25 @@ func (m *memFS) Get(prefix base.Prefix, name string) (*bytes.Buffer, map[string]
 	return buf, headers, nil
 }
 
+func (m *memFS) Open(prefix base.Prefix, name string) (*base.Object, error) {
+	buf, headers, err := m.Get(prefix, name)
+	if err != nil {
+		return nil, err
+	}
+	return &base.Object{
+		File:        nopCloser{bytes.NewReader(buf.Bytes())},
+		Size:        int64(buf.Len()),
+		ContentType: headers["Content-Type"],
+	}, nil
+}
+
+// nopCloser is a base.File for the content already in memory.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
 func (m *memFS) Remove(prefix base.Prefix, name string) error {
 	if _, ok := m.prefixes[prefix]; !ok {
 		return base.NewFileNotFoundError(fmt.Errorf("Prefix %s not found", prefix))
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/metrics"
+)
+
+// WithMetrics returns a storage that measures the latency of the operations
+// on the given storage.
+func WithMetrics(s base.VirtualStorage) base.VirtualStorage {
+	return &instrumentedStorage{s}
+}
+
+type instrumentedStorage struct {
+	store base.VirtualStorage
+}
+
+func observe(start time.Time, operation string, prefix base.Prefix) {
+	metrics.StorageDurations.ObserveSince(start, operation, prefix.String())
+}
+
+func (s *instrumentedStorage) Status() error {
+	defer observe(time.Now(), "status", "")
+	return s.store.Status()
+}
+
+func (s *instrumentedStorage) EnsureExists(prefix base.Prefix) error {
+	defer observe(time.Now(), "ensure_exists", prefix)
+	return s.store.EnsureExists(prefix)
+}
+
+func (s *instrumentedStorage) EnsureEmpty(prefix base.Prefix) error {
+	defer observe(time.Now(), "ensure_empty", prefix)
+	return s.store.EnsureEmpty(prefix)
+}
+
+func (s *instrumentedStorage) EnsureDeleted(prefix base.Prefix) error {
+	defer observe(time.Now(), "ensure_deleted", prefix)
+	return s.store.EnsureDeleted(prefix)
+}
+
+func (s *instrumentedStorage) Create(prefix base.Prefix, name, contentType string, content io.Reader) error {
+	defer observe(time.Now(), "create", prefix)
+	return s.store.Create(prefix, name, contentType, content)
+}
+
+func (s *instrumentedStorage) Get(prefix base.Prefix, name string) (*bytes.Buffer, map[string]string, error) {
+	defer observe(time.Now(), "get", prefix)
+	return s.store.Get(prefix, name)
+}
+
+// Open measures only the time to open the file, not the time to read it, as
+// it depends on the client that downloads it.
+func (s *instrumentedStorage) Open(prefix base.Prefix, name string) (*base.Object, error) {
+	defer observe(time.Now(), "open", prefix)
+	return s.store.Open(prefix, name)
+}
+
+func (s *instrumentedStorage) Remove(prefix base.Prefix, name string) error {
+	defer observe(time.Now(), "remove", prefix)
+	return s.store.Remove(prefix, name)
+}
+
+func (s *instrumentedStorage) Walk(prefix base.Prefix, fn base.WalkFn) error {
+	defer observe(time.Now(), "walk", prefix)
+	return s.store.Walk(prefix, fn)
+}
+
+func (s *instrumentedStorage) FindByPrefix(prefix base.Prefix, namePrefix string) ([]string, error) {
+	defer observe(time.Now(), "find_by_prefix", prefix)
+	return s.store.FindByPrefix(prefix, namePrefix)
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultMigrateConcurrency is the number of files copied at the same time
+// by a migration.
+const DefaultMigrateConcurrency = 10
+
+// MigrateOptions are the parameters of a migration between two storages.
+type MigrateOptions struct {
+	Concurrency int
+	// Progress receives a line for each prefix, and for each file copied or
+	// skipped. It can be nil.
+	Progress io.Writer
+}
+
+// MigrateStats counts the files handled by a migration.
+type MigrateStats struct {
+	Copied  int
+	Skipped int
+}
+
+// Migrate copies the files of the given prefixes from a storage to another,
+// with their content types. Each copy is verified by comparing the checksums
+// of the source and of the destination. The files that are already in the
+// destination with the same content are skipped, so a migration that has
+// been interrupted can be started again to finish it.
+func Migrate(from, to base.VirtualStorage, prefixes []base.Prefix, opts MigrateOptions) (*MigrateStats, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultMigrateConcurrency
+	}
+	if opts.Progress == nil {
+		opts.Progress = ioutil.Discard
+	}
+	m := &migration{from: from, to: to, opts: opts, stats: &MigrateStats{}}
+	for _, prefix := range prefixes {
+		if err := m.migratePrefix(prefix); err != nil {
+			return m.stats, err
+		}
+	}
+	return m.stats, nil
+}
+
+type migration struct {
+	from  base.VirtualStorage
+	to    base.VirtualStorage
+	opts  MigrateOptions
+	mu    sync.Mutex
+	stats *MigrateStats
+}
+
+type migrateEntry struct {
+	name        string
+	contentType string
+}
+
+func (m *migration) migratePrefix(prefix base.Prefix) error {
+	var entries []migrateEntry
+	err := m.from.Walk(prefix, func(name, contentType string) error {
+		entries = append(entries, migrateEntry{name, contentType})
+		return nil
+	})
+	if err != nil {
+		if isNotFound(err) {
+			fmt.Fprintf(m.opts.Progress, "Skipping %s: not found in the source\n", prefix)
+			return nil
+		}
+		return fmt.Errorf("Cannot list the files of %s: %w", prefix, err)
+	}
+	fmt.Fprintf(m.opts.Progress, "Migrating %s (%d files)\n", prefix, len(entries))
+	if err := m.to.EnsureExists(prefix); err != nil {
+		return fmt.Errorf("Cannot create %s: %w", prefix, err)
+	}
+
+	g, ctx := errgroup.WithContext(context.Background())
+	toCopy := make(chan migrateEntry)
+	g.Go(func() error {
+		defer close(toCopy)
+		for _, entry := range entries {
+			select {
+			case toCopy <- entry:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	done := 0
+	for i := 0; i < m.opts.Concurrency; i++ {
+		g.Go(func() error {
+			for entry := range toCopy {
+				copied, err := m.migrateFile(prefix, entry)
+				if err != nil {
+					return fmt.Errorf("Cannot migrate %s/%s: %w", prefix, entry.name, err)
+				}
+				m.mu.Lock()
+				done++
+				status := "skipped"
+				if copied {
+					m.stats.Copied++
+					status = "copied"
+				} else {
+					m.stats.Skipped++
+				}
+				fmt.Fprintf(m.opts.Progress, "  [%d/%d] %s %s\n", done, len(entries), entry.name, status)
+				m.mu.Unlock()
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// migrateFile copies a file, unless it is already in the destination. It
+// returns true if the file has been copied.
+func (m *migration) migrateFile(prefix base.Prefix, entry migrateEntry) (bool, error) {
+	src, err := m.from.Open(prefix, entry.name)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	dst, err := m.to.Open(prefix, entry.name)
+	if err == nil {
+		same, err := sameObjects(src, dst, entry.contentType)
+		dst.Close()
+		if err != nil || same {
+			return false, err
+		}
+		if _, err = src.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	} else if !isNotFound(err) {
+		return false, err
+	}
+
+	h := sha256.New()
+	if err := m.to.Create(prefix, entry.name, entry.contentType, io.TeeReader(src, h)); err != nil {
+		return false, err
+	}
+
+	dst, err = m.to.Open(prefix, entry.name)
+	if err != nil {
+		return false, err
+	}
+	defer dst.Close()
+	sum, err := checksum(dst)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(sum, h.Sum(nil)) {
+		return false, fmt.Errorf("checksum mismatch after the copy")
+	}
+	return true, nil
+}
+
+// sameObjects returns true if the destination has the same content and the
+// same content type as the source.
+func sameObjects(src, dst *base.Object, contentType string) (bool, error) {
+	if src.Size != dst.Size || dst.ContentType != contentType {
+		return false, nil
+	}
+	srcSum, err := checksum(src)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := checksum(dst)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcSum, dstSum), nil
+}
+
+func checksum(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(base.Error)
+	return ok && e.Code == 404
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.TempDir(), "migrate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	assets := base.Prefix("__assets__")
+	space := base.Prefix("__default__")
+	missing := base.Prefix("missing")
+	from := NewMemFS()
+	assert.NoError(t, from.EnsureExists(assets))
+	assert.NoError(t, from.EnsureExists(space))
+	assert.NoError(t, from.Create(assets, "shasum-icon", "image/svg+xml", strings.NewReader("<svg></svg>")))
+	assert.NoError(t, from.Create(space, "app/1.0.0/app.tar.gz", "application/gzip", strings.NewReader("tarball 1.0.0")))
+	assert.NoError(t, from.Create(space, "app/1.0.1/app.tar.gz", "application/gzip", strings.NewReader("tarball 1.0.1")))
+
+	to := NewMemFS()
+	prefixes := []base.Prefix{assets, space, missing}
+	progress := new(bytes.Buffer)
+	stats, err := Migrate(from, to, prefixes, MigrateOptions{Concurrency: 2, Progress: progress})
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrateStats{Copied: 3}, stats)
+	assert.Contains(t, progress.String(), "Migrating __default__ (2 files)")
+	assert.Contains(t, progress.String(), "Skipping missing")
+
+	content, headers, err := to.Get(space, "app/1.0.1/app.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, "tarball 1.0.1", content.String())
+	assert.Equal(t, "application/gzip", headers["Content-Type"])
+
+	// A second run only copies the files that are missing or different
+	assert.NoError(t, to.Remove(assets, "shasum-icon"))
+	assert.NoError(t, to.Create(space, "app/1.0.0/app.tar.gz", "application/gzip", strings.NewReader("truncated")))
+	stats, err = Migrate(from, to, prefixes, MigrateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrateStats{Copied: 2, Skipped: 1}, stats)
+
+	content, _, err = to.Get(space, "app/1.0.0/app.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, "tarball 1.0.0", content.String())
+	content, headers, err = to.Get(assets, "shasum-icon")
+	assert.NoError(t, err)
+	assert.Equal(t, "<svg></svg>", content.String())
+	assert.Equal(t, "image/svg+xml", headers["Content-Type"])
+
+	// The files can also be migrated to the local file system
+	local := &localFS{tmp}
+	stats, err = Migrate(to, local, prefixes, MigrateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrateStats{Copied: 3}, stats)
+}
@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/cozy/cozy-apps-registry/base"
+)
+
+// minPartSize is the minimal size of the parts of a multipart upload allowed
+// by S3 (except for the last part).
+const minPartSize = 5 * 1024 * 1024
+
+// DefaultS3PartSize is the size of the parts used to upload the large files.
+const DefaultS3PartSize = 16 * 1024 * 1024
+
+// prefixMarker is the name of the empty object that marks the existence of a
+// prefix when a single bucket is used for all the prefixes.
+const prefixMarker = ".prefix"
+
+// S3Options are the parameters for connecting to an S3-compatible object
+// store.
+type S3Options struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Bucket is the bucket used for all the prefixes, the files of a prefix
+	// have their keys starting by the prefix. When it is empty, there is one
+	// bucket per prefix, whose name starts by BucketPrefix.
+	Bucket       string
+	BucketPrefix string
+	// PartSize is the size of the parts for the multipart uploads. The files
+	// smaller than that are uploaded in a single request.
+	PartSize int64
+}
+
+// NewS3 returns a VirtualStorage where the files are persisted in an
+// S3-compatible object store, like MinIO.
+func NewS3(opts S3Options) (base.VirtualStorage, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.PartSize == 0 {
+		opts.PartSize = DefaultS3PartSize
+	}
+	if opts.PartSize < minPartSize {
+		return nil, fmt.Errorf("The S3 part size must be at least %d bytes", minPartSize)
+	}
+	return &s3FS{
+		client: &s3Client{
+			endpoint:  endpoint,
+			region:    opts.Region,
+			accessKey: opts.AccessKey,
+			secretKey: opts.SecretKey,
+			http:      http.DefaultClient,
+		},
+		bucket:       opts.Bucket,
+		bucketPrefix: opts.BucketPrefix,
+		partSize:     opts.PartSize,
+	}, nil
+}
+
+type s3FS struct {
+	client       *s3Client
+	bucket       string
+	bucketPrefix string
+	partSize     int64
+}
+
+var invalidBucketChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// location returns the bucket and the key prefix used for the files of a
+// prefix.
+func (s *s3FS) location(prefix base.Prefix) (string, string) {
+	if s.bucket != "" {
+		return s.bucket, string(prefix) + "/"
+	}
+	// The bucket names can only have lowercase letters, digits, dots and
+	// hyphens, and must start and end with a letter or a digit
+	name := strings.ToLower(s.bucketPrefix + string(prefix))
+	name = invalidBucketChars.ReplaceAllString(name, "-")
+	name = strings.Trim(name, ".-")
+	return name, ""
+}
+
+func (s *s3FS) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*s3Error); ok {
+		switch {
+		case e.StatusCode == http.StatusNotFound:
+			return base.NewFileNotFoundError(err)
+		case e.StatusCode == http.StatusRequestEntityTooLarge || e.Code == "EntityTooLarge":
+			return base.NewTooLargeError(err)
+		}
+	}
+	return base.NewInternalError(err)
+}
+
+func isS3NotFound(err error) bool {
+	e, ok := err.(*s3Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// checkPrefix returns a not found error if the prefix has not been created.
+func (s *s3FS) checkPrefix(prefix base.Prefix) error {
+	bucket, keyPrefix := s.location(prefix)
+	if keyPrefix == "" {
+		_, err := s.client.doAndClose(&s3Request{method: http.MethodHead, bucket: bucket})
+		return s.wrapError(err)
+	}
+	_, err := s.client.doAndClose(&s3Request{method: http.MethodHead, bucket: bucket, key: keyPrefix + prefixMarker})
+	return s.wrapError(err)
+}
+
+func (s *s3FS) Status() error {
+	if s.bucket != "" {
+		_, err := s.client.doAndClose(&s3Request{method: http.MethodHead, bucket: s.bucket})
+		return err
+	}
+	_, err := s.client.doAndClose(&s3Request{method: http.MethodGet})
+	return err
+}
+
+func (s *s3FS) EnsureExists(prefix base.Prefix) error {
+	bucket, keyPrefix := s.location(prefix)
+	if keyPrefix != "" {
+		_, err := s.client.doAndClose(&s3Request{
+			method: http.MethodPut,
+			bucket: bucket,
+			key:    keyPrefix + prefixMarker,
+		})
+		return s.wrapError(err)
+	}
+
+	var body []byte
+	if s.client.region != "us-east-1" {
+		body = []byte(`<CreateBucketConfiguration><LocationConstraint>` +
+			s.client.region + `</LocationConstraint></CreateBucketConfiguration>`)
+	}
+	_, err := s.client.doAndClose(&s3Request{method: http.MethodPut, bucket: bucket, body: body})
+	if e, ok := err.(*s3Error); ok && e.Code == "BucketAlreadyOwnedByYou" {
+		err = nil
+	}
+	return s.wrapError(err)
+}
+
+func (s *s3FS) EnsureEmpty(prefix base.Prefix) error {
+	if err := s.EnsureDeleted(prefix); err != nil {
+		return err
+	}
+	return s.EnsureExists(prefix)
+}
+
+func (s *s3FS) EnsureDeleted(prefix base.Prefix) error {
+	bucket, keyPrefix := s.location(prefix)
+	keys, err := s.client.listKeys(bucket, keyPrefix)
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil
+		}
+		return s.wrapError(err)
+	}
+	for _, key := range keys {
+		_, err := s.client.doAndClose(&s3Request{method: http.MethodDelete, bucket: bucket, key: key})
+		if err != nil && !isS3NotFound(err) {
+			return s.wrapError(err)
+		}
+	}
+	if keyPrefix != "" {
+		return nil
+	}
+	_, err = s.client.doAndClose(&s3Request{method: http.MethodDelete, bucket: bucket})
+	if err != nil && !isS3NotFound(err) {
+		return s.wrapError(err)
+	}
+	return nil
+}
+
+func (s *s3FS) Create(prefix base.Prefix, name, contentType string, content io.Reader) error {
+	bucket, keyPrefix := s.location(prefix)
+	if keyPrefix != "" {
+		if err := s.checkPrefix(prefix); err != nil {
+			return err
+		}
+	}
+
+	// The small files are sent in a single request, and the large ones with
+	// a multipart upload
+	first, err := readPart(content, s.partSize)
+	if err != nil {
+		return s.wrapError(err)
+	}
+	if int64(len(first)) == s.partSize {
+		err = s.client.putMultipart(bucket, keyPrefix+name, contentType, first, content, s.partSize)
+		return s.wrapError(err)
+	}
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	_, err = s.client.doAndClose(&s3Request{
+		method:  http.MethodPut,
+		bucket:  bucket,
+		key:     keyPrefix + name,
+		headers: headers,
+		body:    first,
+	})
+	return s.wrapError(err)
+}
+
+func (s *s3FS) Get(prefix base.Prefix, name string) (*bytes.Buffer, map[string]string, error) {
+	bucket, keyPrefix := s.location(prefix)
+	res, err := s.client.do(&s3Request{method: http.MethodGet, bucket: bucket, key: keyPrefix + name})
+	if err != nil {
+		return nil, nil, s.wrapError(err)
+	}
+	defer res.Body.Close()
+
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, res.Body); err != nil {
+		return nil, nil, s.wrapError(err)
+	}
+	headers := map[string]string{
+		"Content-Type":   res.Header.Get("Content-Type"),
+		"Content-Length": fmt.Sprintf("%d", buf.Len()),
+		"Etag":           res.Header.Get("ETag"),
+	}
+	return buf, headers, nil
+}
+
+func (s *s3FS) Open(prefix base.Prefix, name string) (*base.Object, error) {
+	bucket, keyPrefix := s.location(prefix)
+	res, err := s.client.do(&s3Request{method: http.MethodGet, bucket: bucket, key: keyPrefix + name})
+	if err != nil {
+		return nil, s.wrapError(err)
+	}
+	return &base.Object{
+		File: &s3File{
+			client: s.client,
+			bucket: bucket,
+			key:    keyPrefix + name,
+			size:   res.ContentLength,
+			body:   res.Body,
+		},
+		Size:        res.ContentLength,
+		ContentType: res.Header.Get("Content-Type"),
+		Etag:        res.Header.Get("ETag"),
+	}, nil
+}
+
+func (s *s3FS) Remove(prefix base.Prefix, name string) error {
+	bucket, keyPrefix := s.location(prefix)
+	_, err := s.client.doAndClose(&s3Request{method: http.MethodDelete, bucket: bucket, key: keyPrefix + name})
+	// If the object is not found, it's OK.
+	if isS3NotFound(err) {
+		err = nil
+	}
+	return s.wrapError(err)
+}
+
+func (s *s3FS) Walk(prefix base.Prefix, fn base.WalkFn) error {
+	if err := s.checkPrefix(prefix); err != nil {
+		return err
+	}
+	bucket, keyPrefix := s.location(prefix)
+	keys, err := s.client.listKeys(bucket, keyPrefix)
+	if err != nil {
+		return s.wrapError(err)
+	}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, keyPrefix)
+		if keyPrefix != "" && name == prefixMarker {
+			continue
+		}
+		// The content type is not in the listing
+		headers, err := s.client.doAndClose(&s3Request{method: http.MethodHead, bucket: bucket, key: key})
+		if err != nil {
+			return s.wrapError(err)
+		}
+		if err := fn(name, headers.Get("Content-Type")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3FS) FindByPrefix(prefix base.Prefix, namePrefix string) ([]string, error) {
+	bucket, keyPrefix := s.location(prefix)
+	keys, err := s.client.listKeys(bucket, keyPrefix+namePrefix)
+	if err != nil {
+		return nil, s.wrapError(err)
+	}
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, keyPrefix)
+		if keyPrefix != "" && name == prefixMarker {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// s3File reads an object as a stream. When it is seeked, the next read is
+// made with a new request for the range from the new offset.
+type s3File struct {
+	client *s3Client
+	bucket string
+	key    string
+	size   int64
+	body   io.ReadCloser
+	// pos is the position of the body, and offset the position asked by the
+	// last Seek
+	pos    int64
+	offset int64
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.body != nil && f.offset != f.pos {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		headers := http.Header{}
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", f.offset))
+		res, err := f.client.do(&s3Request{
+			method:  http.MethodGet,
+			bucket:  f.bucket,
+			key:     f.key,
+			headers: headers,
+		})
+		if err != nil {
+			return 0, err
+		}
+		f.body = res.Body
+		f.pos = f.offset
+	}
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("Invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("Negative position %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client is a minimal client for the S3 API, with only the operations
+// needed by the storage. The requests are signed with AWS Signature Version
+// 4, and use the path-style URLs (endpoint/bucket/key), which are supported
+// by all the S3-compatible object stores.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+// s3Error is the error returned by the S3 API.
+type s3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 error %d", e.StatusCode)
+	}
+	return fmt.Sprintf("S3 error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// s3Request describes a request to the S3 API. The body is kept in memory,
+// so that its sha256 can be used in the signature.
+type s3Request struct {
+	method  string
+	bucket  string
+	key     string
+	query   url.Values
+	headers http.Header
+	body    []byte
+}
+
+// do sends the request, and returns the response if its status code is 2xx.
+// Otherwise, the response body is parsed as an S3 error. The caller must
+// close the body of the response.
+func (c *s3Client) do(r *s3Request) (*http.Response, error) {
+	u := *c.endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if r.bucket != "" {
+		p += "/" + r.bucket
+		if r.key != "" {
+			p += "/" + r.key
+		}
+	}
+	if p == "" {
+		p = "/"
+	}
+	u.Path = p
+	u.RawPath = s3EncodePath(p)
+	u.RawQuery = s3EncodeQuery(r.query)
+
+	req, err := http.NewRequest(r.method, u.String(), bytes.NewReader(r.body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(r.body))
+	for k, v := range r.headers {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(r.body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	signS3Request(req, c.accessKey, c.secretKey, c.region, time.Now())
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+	s3err := &s3Error{StatusCode: res.StatusCode}
+	if data, err := ioutil.ReadAll(res.Body); err == nil && len(data) > 0 {
+		_ = xml.Unmarshal(data, s3err)
+	}
+	return nil, s3err
+}
+
+// doAndClose is like do, but for the requests where only the status matters.
+func (c *s3Client) doAndClose(r *s3Request) (http.Header, error) {
+	res, err := c.do(r)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return res.Header, nil
+}
+
+// doXML sends the request and parses the XML response in out.
+func (c *s3Client) doXML(r *s3Request, out interface{}) error {
+	res, err := c.do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return xml.NewDecoder(res.Body).Decode(out)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// listKeys returns the keys of the objects of a bucket that start with the
+// given prefix.
+func (c *s3Client) listKeys(bucket, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result s3ListResult
+		err := c.doXML(&s3Request{method: http.MethodGet, bucket: bucket, query: query}, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Contents {
+			keys = append(keys, obj.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads an object in several parts. The first part has
+// already been read from the reader.
+func (c *s3Client) putMultipart(bucket, key, contentType string, first []byte, rest io.Reader, partSize int64) error {
+	headers := http.Header{}
+	if contentType != "" {
+		headers.Set("Content-Type", contentType)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := c.doXML(&s3Request{
+		method:  http.MethodPost,
+		bucket:  bucket,
+		key:     key,
+		query:   url.Values{"uploads": {""}},
+		headers: headers,
+	}, &initiated)
+	if err != nil {
+		return err
+	}
+
+	parts, err := c.uploadParts(bucket, key, initiated.UploadID, first, rest, partSize)
+	if err == nil {
+		var body []byte
+		body, err = xml.Marshal(struct {
+			XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+			Parts   []s3CompletedPart `xml:"Part"`
+		}{Parts: parts})
+		if err == nil {
+			_, err = c.doAndClose(&s3Request{
+				method: http.MethodPost,
+				bucket: bucket,
+				key:    key,
+				query:  url.Values{"uploadId": {initiated.UploadID}},
+				body:   body,
+			})
+		}
+	}
+	if err != nil {
+		// Don't keep the parts already uploaded in the bucket
+		_, _ = c.doAndClose(&s3Request{
+			method: http.MethodDelete,
+			bucket: bucket,
+			key:    key,
+			query:  url.Values{"uploadId": {initiated.UploadID}},
+		})
+		return err
+	}
+	return nil
+}
+
+func (c *s3Client) uploadParts(bucket, key, uploadID string, first []byte, rest io.Reader, partSize int64) ([]s3CompletedPart, error) {
+	var parts []s3CompletedPart
+	part := first
+	for number := 1; len(part) > 0; number++ {
+		headers, err := c.doAndClose(&s3Request{
+			method: http.MethodPut,
+			bucket: bucket,
+			key:    key,
+			query: url.Values{
+				"partNumber": {fmt.Sprintf("%d", number)},
+				"uploadId":   {uploadID},
+			},
+			body: part,
+		})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: headers.Get("ETag")})
+
+		part, err = readPart(rest, partSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// readPart reads at most size bytes from the reader. It returns an empty
+// slice at the end of the reader.
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if _, err := io.CopyN(buf, r, size); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// signS3Request adds the Authorization header to the request, with the AWS
+// Signature Version 4. The host, the x-amz-* headers and some standard
+// headers are signed.
+func signS3Request(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" ||
+			name == "content-md5" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape encodes a string like the S3 API expects it: only the unreserved
+// characters are kept as is.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EncodePath(p string) string {
+	return s3Escape(p, true)
+}
+
+// s3EncodeQuery encodes the query string with the keys sorted, as it is
+// required for the canonical request.
+func s3EncodeQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"io"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 	"testing"
 
@@ -88,6 +90,45 @@ func testStorage(t *testing.T, storage base.VirtualStorage) {
 		}
 	})
 
+	t.Run("Open", func(t *testing.T) {
+		obj, err := storage.Open(fooPrefix, "file-one")
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(10), obj.Size)
+			assert.Equal(t, "text/plain", obj.ContentType)
+			pos, err := obj.Seek(5, io.SeekStart)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), pos)
+			content, err := ioutil.ReadAll(obj)
+			assert.NoError(t, err)
+			assert.Equal(t, "bytes", string(content))
+			assert.NoError(t, obj.Close())
+		}
+
+		_, err = storage.Open(fooPrefix, "no-such-file")
+		if assert.Error(t, err) {
+			assert.Equal(t, 404, err.(base.Error).Code)
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		content := strings.NewReader("nested bytes")
+		assert.NoError(t, storage.Create(barPrefix, "dir/nested-file", "text/plain", content))
+
+		var names []string
+		err := storage.Walk(barPrefix, func(name, _ string) error {
+			names = append(names, name)
+			return nil
+		})
+		assert.NoError(t, err)
+		sort.Strings(names)
+		assert.Equal(t, []string{"dir/nested-file", "file-in-bar"}, names)
+
+		err = storage.Walk(bazPrefix, func(name, _ string) error { return nil })
+		if assert.Error(t, err) {
+			assert.Equal(t, 404, err.(base.Error).Code)
+		}
+	})
+
 	t.Run("Remove", func(t *testing.T) {
 		assert.NoError(t, storage.Remove(fooPrefix, "file-two"))
 		_, _, err := storage.Get(fooPrefix, "file-two")
@@ -26,7 +26,7 @@ func (s *swiftFS) wrapError(err error) error {
 	switch err {
 	case nil:
 		return nil
-	case swift.ObjectNotFound:
+	case swift.ObjectNotFound, swift.ContainerNotFound:
 		return base.NewFileNotFoundError(err)
 	case swift.TooLargeObject:
 		return base.NewTooLargeError(err)
@@ -86,6 +86,24 @@ func (s *swiftFS) Get(prefix base.Prefix, name string) (*bytes.Buffer, map[strin
 	return buf, headers, nil
 }
 
+func (s *swiftFS) Open(prefix base.Prefix, name string) (*base.Object, error) {
+	f, headers, err := s.conn.ObjectOpen(string(prefix), name, false, nil)
+	if err != nil {
+		return nil, s.wrapError(err)
+	}
+	size, err := f.Length()
+	if err != nil {
+		f.Close()
+		return nil, s.wrapError(err)
+	}
+	return &base.Object{
+		File:        f,
+		Size:        size,
+		ContentType: headers["Content-Type"],
+		Etag:        headers["Etag"],
+	}, nil
+}
+
 func (s *swiftFS) Remove(prefix base.Prefix, name string) error {
 	err := s.conn.ObjectDelete(string(prefix), name)
 	// If the object is not found, it's OK.
@@ -99,7 +117,7 @@ func (s *swiftFS) Walk(prefix base.Prefix, fn base.WalkFn) error {
 	return s.conn.ObjectsWalk(string(prefix), nil, func(opts *swift.ObjectsOpts) (interface{}, error) {
 		objects, err := s.conn.Objects(string(prefix), opts)
 		if err != nil {
-			return nil, err
+			return nil, s.wrapError(err)
 		}
 		for _, object := range objects {
 			if err := fn(object.Name, object.ContentType); err != nil {
@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/errshttp"
 	"github.com/cozy/cozy-apps-registry/registry"
@@ -27,9 +29,9 @@ func createApp(c echo.Context) (err error) {
 		return err
 	}
 
-	editor, err := checkPermissions(c, opts.Editor, "", true /* = master */)
+	editor, err := checkPermissions(c, opts.Editor, "", nil /* = master */)
 	if err != nil {
-		return errshttp.NewError(http.StatusUnauthorized, err.Error())
+		return err
 	}
 
 	if err = validateAppRequest(c, opts); err != nil {
@@ -62,15 +64,25 @@ func patchApp(c echo.Context) (err error) {
 		return err
 	}
 
-	_, err = checkPermissions(c, app.Editor, "", true /* = master */)
+	editor, err := checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationPatch,
+		Space:     spaceNameForClaims(c),
+	})
 	if err != nil {
-		return errshttp.NewError(http.StatusUnauthorized, err.Error())
+		return err
 	}
 
+	before := app.Properties()
 	app, err = registry.ModifyApp(getSpace(c), appSlug, opts)
 	if err != nil {
 		return err
 	}
+	recordAction(c, editor, &audit.Entry{
+		Action: audit.AppModified,
+		Slug:   app.Slug,
+		Before: before,
+		After:  app.Properties(),
+	})
 
 	cleanApp(app)
 
@@ -208,9 +220,12 @@ func activateMaintenanceApp(c echo.Context) error {
 		return err
 	}
 
-	_, err = checkPermissions(c, app.Editor, app.Slug, true /* = master */)
+	editor, err := checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationMaintenance,
+		Space:     spaceNameForClaims(c),
+	})
 	if err != nil {
-		return errshttp.NewError(http.StatusUnauthorized, err.Error())
+		return err
 	}
 
 	var opts registry.MaintenanceOptions
@@ -226,6 +241,11 @@ func activateMaintenanceApp(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	recordAction(c, editor, &audit.Entry{
+		Action: audit.MaintenanceActivated,
+		Slug:   appSlug,
+		After:  opts,
+	})
 
 	return c.JSON(http.StatusOK, echo.Map{"ok": true})
 }
@@ -246,9 +266,12 @@ func deactivateMaintenanceApp(c echo.Context) (err error) {
 		return
 	}
 
-	_, err = checkPermissions(c, app.Editor, app.Slug, true /* = master */)
+	editor, err := checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationMaintenance,
+		Space:     spaceNameForClaims(c),
+	})
 	if err != nil {
-		return errshttp.NewError(http.StatusUnauthorized, err.Error())
+		return err
 	}
 
 	if vs != nil {
@@ -259,6 +282,10 @@ func deactivateMaintenanceApp(c echo.Context) (err error) {
 	if err != nil {
 		return err
 	}
+	recordAction(c, editor, &audit.Entry{
+		Action: audit.MaintenanceDeactivated,
+		Slug:   appSlug,
+	})
 
 	return c.JSON(http.StatusOK, echo.Map{"ok": true})
 }
@@ -268,7 +295,8 @@ func deactivateMaintenanceApp(c echo.Context) (err error) {
 func getAppsList(c echo.Context) error {
 	var filter map[string]string
 	var limit, cursor int
-	var sort string
+	var sort, query string
+	var facets []string
 	var err error
 	latestVersionChannel := registry.Stable
 	versionsChannel := registry.Dev
@@ -289,6 +317,10 @@ func getAppsList(c echo.Context) error {
 			}
 		case "sort":
 			sort = val
+		case "q":
+			query = val
+		case "facets":
+			facets = strings.Split(val, ",")
 		case "latestChannelVersion":
 			latestVersionChannel, err = registry.StrToChannel(val)
 			if err != nil {
@@ -333,25 +365,35 @@ func getAppsList(c echo.Context) error {
 		space = &clone
 	}
 
-	next, apps, err := registry.GetAppsList(virtual, space, &registry.AppsListOptions{
+	opts := &registry.AppsListOptions{
 		Filters:              filter,
 		Limit:                limit,
 		Cursor:               cursor,
 		Sort:                 sort,
+		Query:                query,
 		LatestVersionChannel: latestVersionChannel,
 		VersionsChannel:      versionsChannel,
-	})
+	}
+	next, apps, err := registry.GetAppsList(virtual, space, opts)
 	if err != nil {
 		return err
 	}
 
+	var counts registry.Facets
+	if len(facets) > 0 {
+		if counts, err = registry.GetAppsFacets(space, opts, facets); err != nil {
+			return err
+		}
+	}
+
 	for _, app := range apps {
 		cleanApp(app)
 	}
 
 	type pageInfo struct {
-		Count      int    `json:"count"`
-		NextCursor string `json:"next_cursor,omitempty"`
+		Count      int             `json:"count"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+		Facets     registry.Facets `json:"facets,omitempty"`
 	}
 
 	var nextCursor string
@@ -367,6 +409,7 @@ func getAppsList(c echo.Context) error {
 		PageInfo: pageInfo{
 			Count:      len(apps),
 			NextCursor: nextCursor,
+			Facets:     counts,
 		},
 	}
 
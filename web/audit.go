@@ -0,0 +1,79 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/labstack/echo/v4"
+)
+
+// auditActor returns the author of a request for the audit log: the
+// reviewer for a master token, or the name of the editor of the token.
+func auditActor(c echo.Context, editor *auth.Editor) string {
+	if reviewer := reviewerName(c); reviewer != "" {
+		return reviewer
+	}
+	if editor != nil {
+		return editor.Name()
+	}
+	return ""
+}
+
+// recordAction adds an action made through the API on an application of the
+// space (or virtual space) of the request to the audit log.
+func recordAction(c echo.Context, editor *auth.Editor, entry *audit.Entry) {
+	entry.Actor = auditActor(c, editor)
+	entry.Space = spaceNameForClaims(c)
+	audit.Record(entry)
+}
+
+// getAuditLog returns the entries of the audit log, to the administrators of
+// the registry. They can be filtered with the actor, action, space, app,
+// since and until (RFC 3339) query parameters.
+func getAuditLog(c echo.Context) error {
+	if err := checkAuthorized(c); err != nil {
+		return err
+	}
+	// only allow reading the audit log with a master token, like for the
+	// approval of the pending versions
+	if _, err := checkPermissions(c, "cozy", "", nil /* = master */); err != nil {
+		return err
+	}
+
+	filters := audit.Filters{
+		Actor:  c.QueryParam("actor"),
+		Action: c.QueryParam("action"),
+		Space:  c.QueryParam("space"),
+		Slug:   c.QueryParam("app"),
+	}
+	var err error
+	if since := c.QueryParam("since"); since != "" {
+		if filters.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return errshttp.NewError(http.StatusBadRequest,
+				`Query param "since" is invalid: %s`, err)
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		if filters.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return errshttp.NewError(http.StatusBadRequest,
+				`Query param "until" is invalid: %s`, err)
+		}
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		if filters.Limit, err = strconv.Atoi(limit); err != nil || filters.Limit > 1000 {
+			return errshttp.NewError(http.StatusBadRequest,
+				`Query param "limit" is invalid`)
+		}
+	}
+
+	entries, err := audit.List(filters)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("cache-control", "no-cache")
+	return writeJSON(c, entries)
+}
@@ -0,0 +1,168 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultChangesLimit   = 100
+	maxChangesLimit       = 1000
+	defaultChangesTimeout = 30 * time.Second
+	maxChangesTimeout     = 60 * time.Second
+	mimeEventStream       = "text/event-stream"
+)
+
+type changesResponse struct {
+	Results []*registry.Change `json:"results"`
+	LastSeq string             `json:"last_seq"`
+}
+
+// getChanges returns the changes feed of a space. The feed query parameter
+// can be normal (the default), longpoll, or eventsource for Server-Sent
+// Events.
+func getChanges(c echo.Context) error {
+	opts, err := changesOptions(c)
+	if err != nil {
+		return err
+	}
+
+	switch feed := c.QueryParam("feed"); feed {
+	case "", "normal":
+		return writeChanges(c, opts)
+	case "longpoll":
+		opts.LongPoll = true
+		return writeChanges(c, opts)
+	case "eventsource":
+		return streamChanges(c, opts)
+	default:
+		return errshttp.NewError(http.StatusBadRequest,
+			`Query param "feed" is invalid: %q`, feed)
+	}
+}
+
+func changesOptions(c echo.Context) (registry.ChangesOptions, error) {
+	opts := registry.ChangesOptions{
+		Since:   c.QueryParam("since"),
+		Limit:   defaultChangesLimit,
+		Timeout: defaultChangesTimeout,
+	}
+	if lastEventID := c.Request().Header.Get("Last-Event-ID"); lastEventID != "" {
+		opts.Since = lastEventID
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return opts, errshttp.NewError(http.StatusBadRequest,
+				`Query param "limit" is invalid`)
+		}
+		if l > maxChangesLimit {
+			l = maxChangesLimit
+		}
+		opts.Limit = l
+	}
+	if timeout := c.QueryParam("timeout"); timeout != "" {
+		ms, err := strconv.Atoi(timeout)
+		if err != nil || ms <= 0 {
+			return opts, errshttp.NewError(http.StatusBadRequest,
+				`Query param "timeout" is invalid`)
+		}
+		opts.Timeout = time.Duration(ms) * time.Millisecond
+		if opts.Timeout > maxChangesTimeout {
+			opts.Timeout = maxChangesTimeout
+		}
+	}
+	return opts, nil
+}
+
+func changesVirtualSpace(c echo.Context) *base.VirtualSpace {
+	virtual, _ := c.Get("virtual").(*base.VirtualSpace)
+	return virtual
+}
+
+func cleanChanges(changes []*registry.Change) []*registry.Change {
+	if changes == nil {
+		return []*registry.Change{}
+	}
+	for _, change := range changes {
+		switch doc := change.Doc.(type) {
+		case *registry.App:
+			cleanApp(doc)
+		case *registry.Version:
+			cleanVersion(doc)
+		}
+	}
+	return changes
+}
+
+func writeChanges(c echo.Context, opts registry.ChangesOptions) error {
+	ctx := c.Request().Context()
+	changes, lastSeq, err := registry.GetChanges(ctx, changesVirtualSpace(c), getSpace(c), opts)
+	if err != nil {
+		return err
+	}
+	return writeJSON(c, changesResponse{
+		Results: cleanChanges(changes),
+		LastSeq: lastSeq,
+	})
+}
+
+// streamChanges sends the changes as Server-Sent Events until the client
+// closes the connection. The id of the events is the token to use for
+// resuming the feed, and it is sent back by the browsers in the Last-Event-ID
+// header when they reconnect.
+func streamChanges(c echo.Context, opts registry.ChangesOptions) error {
+	ctx := c.Request().Context()
+	virtual := changesVirtualSpace(c)
+	s := getSpace(c)
+	opts.LongPoll = true
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, mimeEventStream)
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	for {
+		changes, lastSeq, err := registry.GetChanges(ctx, virtual, s, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if len(changes) == 0 {
+			// Keep the connection alive
+			if _, err = fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		}
+		for i, change := range cleanChanges(changes) {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+			// Only the last event of a batch has the id, so that a client
+			// that reconnects in the middle of a batch receives it again
+			if i == len(changes)-1 {
+				_, err = fmt.Fprintf(res, "id: %s\n", lastSeq)
+			}
+			if err == nil {
+				_, err = fmt.Fprintf(res, "event: change\ndata: %s\n\n", data)
+			}
+			if err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+		opts.Since = lastSeq
+	}
+}
@@ -1,9 +1,17 @@
 package web
 
 import (
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
 
+	"github.com/cozy/cozy-apps-registry/audit"
 	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/registry"
 	"github.com/labstack/echo/v4"
 )
 
@@ -28,3 +36,135 @@ func getEditorsList(c echo.Context) error {
 	}
 	return writeJSON(c, editors)
 }
+
+// checkEditorAccess checks that the request is made with the master token of
+// the editor of the URL, or of the cozy editor, and returns the editor of the
+// URL.
+func checkEditorAccess(c echo.Context) (*auth.Editor, error) {
+	if err := checkAuthorized(c); err != nil {
+		return nil, err
+	}
+	token, err := extractAuthHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	editor, err := auth.Editors.GetEditor(c.Param("editor"))
+	if err != nil {
+		return nil, err
+	}
+	// checkPermissions accepts the master token of any editor, so the token
+	// is verified with the editor of the URL
+	if editor.VerifyMasterToken(base.SessionSecret, token) {
+		setMasterEditor(c, editor, token)
+		return editor, nil
+	}
+	ok, err := verifyAdminToken(c, token)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errshttp.NewError(http.StatusForbidden,
+			"The master token of the editor is required")
+	}
+	return editor, nil
+}
+
+func patchEditor(c echo.Context) (err error) {
+	editor, err := checkEditorAccess(c)
+	if err != nil {
+		return err
+	}
+
+	var opts auth.ProfileOptions
+	if err = c.Bind(&opts); err != nil {
+		return err
+	}
+
+	before := editor.Profile()
+	if err = auth.Editors.ModifyProfile(editor, opts); err != nil {
+		return err
+	}
+	audit.Record(&audit.Entry{
+		Actor:  auditActor(c, editor),
+		Action: audit.EditorModified,
+		Editor: editor.Name(),
+		Before: before,
+		After:  editor.Profile(),
+	})
+
+	return c.JSON(http.StatusOK, editor)
+}
+
+func putEditorLogo(c echo.Context) (err error) {
+	editor, err := checkEditorAccess(c)
+	if err != nil {
+		return err
+	}
+
+	body := io.LimitReader(c.Request().Body, registry.MaxEditorLogoSize+1)
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	// The extension is used to detect the SVG logos
+	filename := "logo"
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		filename += exts[0]
+	}
+	before := editor.Profile()
+	if err = registry.SetEditorLogo(editor, filename, content); err != nil {
+		return err
+	}
+	audit.Record(&audit.Entry{
+		Actor:  auditActor(c, editor),
+		Action: audit.EditorModified,
+		Editor: editor.Name(),
+		Before: before,
+		After:  editor.Profile(),
+	})
+
+	return c.JSON(http.StatusOK, editor)
+}
+
+func deleteEditorLogo(c echo.Context) (err error) {
+	editor, err := checkEditorAccess(c)
+	if err != nil {
+		return err
+	}
+
+	before := editor.Profile()
+	if err = registry.RemoveEditorLogo(editor); err != nil {
+		return err
+	}
+	audit.Record(&audit.Entry{
+		Actor:  auditActor(c, editor),
+		Action: audit.EditorModified,
+		Editor: editor.Name(),
+		Before: before,
+		After:  editor.Profile(),
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getEditorLogo(c echo.Context) error {
+	editor, err := auth.Editors.GetEditor(c.Param("editor"))
+	if err != nil {
+		return err
+	}
+	att, err := registry.FindEditorLogo(editor)
+	if err != nil {
+		return err
+	}
+	return sendAttachment(c, att, "logo")
+}
+
+// isEditorLogoUpload returns true if the request body is the logo of an
+// editor, which can be larger than the other request bodies.
+func isEditorLogoUpload(c echo.Context) bool {
+	path := c.Request().URL.Path
+	return c.Request().Method == http.MethodPut &&
+		strings.HasPrefix(path, "/editors/") &&
+		strings.HasSuffix(path, "/logo")
+}
@@ -0,0 +1,180 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/labstack/echo/v4"
+)
+
+// reviewerName returns the identity of the reviewer that makes the request
+// with a master token: the name of the editor of the token, with the
+// description of the token if it has one. It returns an empty string if the
+// request has no master token. The editor of the master token is the one
+// found by checkPermissions or checkTransferAccess.
+func reviewerName(c echo.Context) string {
+	editor, ok := c.Get("master_editor").(*auth.Editor)
+	if !ok {
+		return ""
+	}
+	name := editor.Name()
+	if token, err := extractAuthHeader(c); err == nil {
+		if info := editor.TokenInfo(token); info != nil && info.Description != "" {
+			name += " (" + info.Description + ")"
+		}
+	}
+	return name
+}
+
+// checkReviewAccess checks that the request has a master token, or an editor
+// token that allows to publish the application. It returns the name and the
+// role of the author of the request: the editor of the application is never a
+// reviewer of its own versions, even with its master token, except for the
+// cozy editor.
+func checkReviewAccess(c echo.Context, app *registry.App) (string, string, error) {
+	if err := checkAuthorized(c); err != nil {
+		return "", "", err
+	}
+	_, err := checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationPublish,
+		Space:     spaceNameForClaims(c),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	master, ok := c.Get("master_editor").(*auth.Editor)
+	if ok && (master.Name() == "cozy" || master.Name() != app.Editor) {
+		return reviewerName(c), registry.RoleReviewer, nil
+	}
+	return app.Editor, registry.RoleEditor, nil
+}
+
+// findPendingVersion returns the application and the pending version from
+// the URL parameters.
+func findPendingVersion(c echo.Context) (*registry.App, *registry.Version, error) {
+	appSlug := c.Param("app")
+	if appSlug == "" {
+		return nil, nil, errshttp.NewError(http.StatusNotFound, "App is missing in the URL")
+	}
+	app, err := registry.FindApp(nil, getSpace(c), appSlug, registry.Stable)
+	if err != nil {
+		return nil, nil, err
+	}
+	ver := stripVersion(c.Param("version"))
+	if ver == "" {
+		return nil, nil, errshttp.NewError(http.StatusNotFound, "Version is missing in the URL")
+	}
+	version, err := registry.FindPendingVersion(getSpace(c), appSlug, ver)
+	if err != nil {
+		return nil, nil, err
+	}
+	return app, version, nil
+}
+
+func rejectPendingVersion(c echo.Context) (err error) {
+	if err = checkAuthorized(c); err != nil {
+		return err
+	}
+
+	// only allow rejecting versions from editor cozy, like for the approval
+	editorName := "cozy"
+	_, err = checkPermissions(c, editorName, "", nil /* = master */)
+	if err != nil {
+		return err
+	}
+
+	_, version, err := findPendingVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err = c.Bind(&body); err != nil {
+		return err
+	}
+
+	version, err = registry.RejectPendingVersion(getSpace(c), version, reviewerName(c), body.Reason)
+	if err != nil {
+		return err
+	}
+	recordAction(c, nil, &audit.Entry{
+		Action:  audit.VersionRejected,
+		Slug:    version.Slug,
+		Version: version.Version,
+		After:   version.Rejection,
+	})
+
+	cleanVersion(version)
+	return c.JSON(http.StatusOK, version)
+}
+
+func addReviewComment(c echo.Context) (err error) {
+	app, version, err := findPendingVersion(c)
+	if err != nil {
+		return err
+	}
+	author, role, err := checkReviewAccess(c, app)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err = c.Bind(&body); err != nil {
+		return err
+	}
+
+	comment, err := registry.AddReviewComment(getSpace(c), version, author, role, body.Message)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// getAppPendingVersions returns the pending and rejected versions of an
+// application, with the feedback of the reviewers. It can be used by the
+// editor of the application.
+func getAppPendingVersions(c echo.Context) (err error) {
+	app, err := registry.FindApp(nil, getSpace(c), c.Param("app"), registry.Stable)
+	if err != nil {
+		return err
+	}
+	if _, _, err = checkReviewAccess(c, app); err != nil {
+		return err
+	}
+
+	versions, err := registry.GetAppPendingVersions(getSpace(c), app.Slug)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		cleanVersion(version)
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+func getReviewHistory(c echo.Context) (err error) {
+	app, err := registry.FindApp(nil, getSpace(c), c.Param("app"), registry.Stable)
+	if err != nil {
+		return err
+	}
+	if _, _, err = checkReviewAccess(c, app); err != nil {
+		return err
+	}
+
+	events, err := registry.GetReviewHistory(getSpace(c), app.Slug)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		event.ID = ""
+		event.Rev = ""
+	}
+	return c.JSON(http.StatusOK, events)
+}
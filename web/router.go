@@ -9,13 +9,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/metrics"
 	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/cozy/cozy-apps-registry/schema"
 	"github.com/cozy/cozy-apps-registry/space"
 
 	"github.com/labstack/echo/v4"
@@ -38,6 +42,7 @@ var (
 func cleanVersion(version *registry.Version) {
 	version.ID = ""
 	version.Rev = ""
+	version.SourceURL = ""
 }
 
 // Do not show internal identifier and revision
@@ -60,7 +65,11 @@ func checkAuthorized(c echo.Context) error {
 	return nil
 }
 
-func checkPermissions(c echo.Context, editorName string, appName string, master bool) (*auth.Editor, error) {
+// checkPermissions checks that the request has a token for the given editor
+// that allows the action. When action is nil, only the master tokens are
+// accepted. Otherwise, an editor token for the application is accepted if its
+// claims allow the action, and a master token is always accepted.
+func checkPermissions(c echo.Context, editorName string, appName string, action *auth.Action) (*auth.Editor, error) {
 	token, err := extractAuthHeader(c)
 	if err != nil {
 		return nil, err
@@ -70,8 +79,14 @@ func checkPermissions(c echo.Context, editorName string, appName string, master
 		return nil, errshttp.NewError(http.StatusUnauthorized, "Could not find editor: %s", editorName)
 	}
 	ok := false
-	if !master {
-		ok = editor.VerifyEditorToken(base.SessionSecret, token, appName)
+	forbidden := false
+	if action != nil && appName != "" {
+		var claims *auth.Claims
+		claims, ok = editor.EditorTokenClaims(base.SessionSecret, token, appName)
+		if ok && !claims.Allows(*action) {
+			ok = false
+			forbidden = true
+		}
 	}
 	if !ok {
 		editors, err := auth.Editors.AllEditors()
@@ -80,9 +95,16 @@ func checkPermissions(c echo.Context, editorName string, appName string, master
 		}
 		for _, e := range editors {
 			if ok = e.VerifyMasterToken(base.SessionSecret, token); ok {
+				setMasterEditor(c, e, token)
 				break
 			}
 		}
+	} else {
+		auth.TouchToken(editor, token)
+	}
+	if !ok && forbidden {
+		return nil, errshttp.NewError(http.StatusForbidden,
+			"Token does not allow to %s in this space or channel", action.Operation)
 	}
 	if !ok {
 		return nil, errshttp.NewError(http.StatusUnauthorized, "Token could not be verified")
@@ -90,6 +112,43 @@ func checkPermissions(c echo.Context, editorName string, appName string, master
 	return editor, nil
 }
 
+// verifyAdminToken returns true if the token is a master token of the cozy
+// editor, used by the administrators of the registry. The editor is kept in
+// the context like with setMasterEditor.
+func verifyAdminToken(c echo.Context, token []byte) (bool, error) {
+	admin, err := auth.Editors.GetEditor("cozy")
+	if err == auth.ErrEditorNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !admin.VerifyMasterToken(base.SessionSecret, token) {
+		return false, nil
+	}
+	setMasterEditor(c, admin, token)
+	return true, nil
+}
+
+// setMasterEditor keeps the editor of the master token used for the request
+// in its context, for reviewerName.
+func setMasterEditor(c echo.Context, editor *auth.Editor, token []byte) {
+	c.Set("master_editor", editor)
+	auth.TouchToken(editor, token)
+}
+
+// spaceNameForClaims returns the name of the space (or virtual space) of the
+// request, as it is written in the claims of the tokens.
+func spaceNameForClaims(c echo.Context) string {
+	if virtual, ok := c.Get("virtual_name").(string); ok && virtual != "" {
+		return virtual
+	}
+	if name := getSpace(c).Name; name != "" {
+		return name
+	}
+	return base.DefaultSpacePrefix.String()
+}
+
 func extractAuthHeader(c echo.Context) ([]byte, error) {
 	authHeader := c.Request().Header.Get(echo.HeaderAuthorization)
 	if !strings.HasPrefix(authHeader, authTokenScheme) {
@@ -212,8 +271,12 @@ func httpErrorHandler(err error, c echo.Context) {
 
 	isJSON, _ := c.Get("json").(bool)
 
+	var violations []schema.Violation
 	if he, ok := err.(*errshttp.Error); ok {
 		code = he.StatusCode()
+	} else if se, ok := err.(*schema.Error); ok {
+		code = se.StatusCode()
+		violations = se.Violations
 	} else if be, ok := err.(base.Error); ok {
 		code = be.Code
 		msg = be.Message()
@@ -255,7 +318,11 @@ func httpErrorHandler(err error, c echo.Context) {
 				c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
 				err = c.NoContent(code)
 			} else {
-				err = c.JSON(code, echo.Map{"error": desc})
+				body := echo.Map{"error": desc}
+				if violations != nil {
+					body["violations"] = violations
+				}
+				err = c.JSON(code, body)
 			}
 		} else {
 			if c.Request().Method == echo.HEAD {
@@ -336,6 +403,44 @@ func filterAppInVirtualSpace(handler echo.HandlerFunc, virtual base.VirtualSpace
 	}
 }
 
+// instrumentRequests is a middleware that counts the requests and measures
+// their latency, by route, space and virtual space. The requests that do not
+// match a route of the router are regrouped under the unknown route.
+func instrumentRequests(e *echo.Echo) echo.MiddlewareFunc {
+	var once sync.Once
+	routes := make(map[string]bool)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			once.Do(func() {
+				for _, r := range e.Routes() {
+					routes[r.Path] = true
+				}
+			})
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// Let the error handler write the response, to know its status
+				c.Error(err)
+			}
+
+			req := c.Request()
+			route := c.Path()
+			if !routes[route] {
+				route = "unknown"
+			}
+			spaceName := ""
+			if s, ok := c.Get(spaceKey).(*space.Space); ok {
+				spaceName = s.GetPrefix().String()
+			}
+			virtualName, _ := c.Get("virtual_name").(string)
+			code := strconv.Itoa(c.Response().Status)
+			metrics.HTTPDurations.ObserveSince(start, req.Method, route, spaceName, virtualName)
+			metrics.HTTPRequests.Inc(req.Method, route, code, spaceName, virtualName)
+			return nil
+		}
+	}
+}
+
 // Router sets up the HTTP routes.
 func Router() *echo.Echo {
 	err := initAssets()
@@ -349,8 +454,16 @@ func Router() *echo.Echo {
 	e.HTTPErrorHandler = httpErrorHandler
 
 	e.Pre(middleware.RemoveTrailingSlash())
-	e.Use(middleware.BodyLimit("100K"))
+	e.Use(middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		Limit: "100K",
+		// The tarballs uploaded for new versions are limited by the registry,
+		// and the logos of the editors by their handler
+		Skipper: func(c echo.Context) bool {
+			return isTarballUpload(c) || isEditorLogoUpload(c)
+		},
+	}))
 	e.Use(middleware.Recover())
+	e.Use(instrumentRequests(e))
 
 	for _, c := range space.GetSpacesNames() {
 		var groupName string
@@ -363,13 +476,18 @@ func Router() *echo.Echo {
 
 		g.POST("", createApp, jsonEndpoint, middleware.Gzip())
 		g.PATCH("/:app", patchApp, jsonEndpoint, middleware.Gzip())
-		g.POST("/:app", createVersion, jsonEndpoint, middleware.Gzip())
+		g.POST("/:app", createVersion, uploadEndpoint, middleware.Gzip())
 
 		g.GET("", getAppsList, jsonEndpoint, middleware.Gzip())
+		g.GET("/_changes", getChanges)
 
 		g.HEAD("/pending", getPendingVersions, jsonEndpoint, middleware.Gzip())
 		g.GET("/pending", getPendingVersions, jsonEndpoint, middleware.Gzip())
 		g.PUT("/pending/:app/:version/approval", approvePendingVersion, middleware.Gzip())
+		g.PUT("/pending/:app/:version/rejection", rejectPendingVersion, jsonEndpoint, middleware.Gzip())
+		g.POST("/pending/:app/:version/comments", addReviewComment, jsonEndpoint, middleware.Gzip())
+		g.GET("/pending/:app", getAppPendingVersions, jsonEndpoint, middleware.Gzip())
+		g.GET("/pending/:app/history", getReviewHistory, jsonEndpoint, middleware.Gzip())
 
 		g.GET("/maintenance", getMaintenanceApps, jsonEndpoint, middleware.Gzip())
 		g.PUT("/maintenance/:app/activate", activateMaintenanceApp, jsonEndpoint, middleware.Gzip())
@@ -378,10 +496,15 @@ func Router() *echo.Echo {
 		g.HEAD("/:app", getApp, jsonEndpoint, middleware.Gzip())
 		g.GET("/:app", getApp, jsonEndpoint, middleware.Gzip())
 		g.GET("/:app/versions", getAppVersions, jsonEndpoint, middleware.Gzip())
+		g.GET("/:app/stats", getAppStats, jsonEndpoint, middleware.Gzip())
 		g.HEAD("/:app/:version", getVersion, jsonEndpoint, middleware.Gzip())
 		g.GET("/:app/:version", getVersion, jsonEndpoint, middleware.Gzip())
 		g.HEAD("/:app/:channel/latest", getLatestVersion, jsonEndpoint, middleware.Gzip())
 		g.GET("/:app/:channel/latest", getLatestVersion, jsonEndpoint, middleware.Gzip())
+		g.POST("/:app/:version/promotion", promoteVersion, jsonEndpoint, middleware.Gzip())
+		g.POST("/:app/transfer", transferApp, jsonEndpoint, middleware.Gzip())
+		g.PUT("/:app/:version/yank", yankVersion, jsonEndpoint, middleware.Gzip())
+		g.DELETE("/:app/:version/yank", unyankVersion, jsonEndpoint, middleware.Gzip())
 
 		g.GET("/:app/icon", getAppIcon)
 		g.HEAD("/:app/icon", getAppIcon)
@@ -401,6 +524,8 @@ func Router() *echo.Echo {
 		g.GET("/:app/:version/screenshots/*", getVersionScreenshot)
 		g.HEAD("/:app/:version/tarball/:tarball", getVersionTarball)
 		g.GET("/:app/:version/tarball/:tarball", getVersionTarball)
+		g.HEAD("/:app/:version/signature", getVersionSignature, jsonEndpoint, middleware.Gzip())
+		g.GET("/:app/:version/signature", getVersionSignature, jsonEndpoint, middleware.Gzip())
 	}
 
 	for name, v := range base.Config.VirtualSpaces {
@@ -414,6 +539,7 @@ func Router() *echo.Echo {
 
 		virtualGetAppsList := applyVirtualSpace(getAppsList, v, name)
 		g.GET("", virtualGetAppsList, jsonEndpoint, middleware.Gzip())
+		g.GET("/_changes", applyVirtualSpace(getChanges, v, name))
 
 		filteredGetMaintenanceApps := filterGetMaintenanceApps(v)
 		g.GET("/maintenance", filteredGetMaintenanceApps, jsonEndpoint, middleware.Gzip())
@@ -459,11 +585,20 @@ func Router() *echo.Echo {
 		filteredGetVersionTarball := applyVirtualSpace(filterAppInVirtualSpace(getVersionTarball, v), v, name)
 		g.HEAD("/:app/:version/tarball/:tarball", filteredGetVersionTarball)
 		g.GET("/:app/:version/tarball/:tarball", filteredGetVersionTarball)
+		filteredGetVersionSignature := applyVirtualSpace(filterAppInVirtualSpace(getVersionSignature, v), v, name)
+		g.HEAD("/:app/:version/signature", filteredGetVersionSignature, jsonEndpoint, middleware.Gzip())
+		g.GET("/:app/:version/signature", filteredGetVersionSignature, jsonEndpoint, middleware.Gzip())
 	}
 
 	e.GET("/editors", getEditorsList, jsonEndpoint, middleware.Gzip())
+	e.GET("/audit", getAuditLog, jsonEndpoint, middleware.Gzip())
 	e.HEAD("/editors/:editor", getEditor, jsonEndpoint, middleware.Gzip())
 	e.GET("/editors/:editor", getEditor, jsonEndpoint, middleware.Gzip())
+	e.PATCH("/editors/:editor", patchEditor, jsonEndpoint)
+	e.HEAD("/editors/:editor/logo", getEditorLogo)
+	e.GET("/editors/:editor/logo", getEditorLogo)
+	e.PUT("/editors/:editor/logo", putEditorLogo)
+	e.DELETE("/editors/:editor/logo", deleteEditorLogo)
 
 	e.GET("/.well-known/:filename", universalLink, middleware.Gzip())
 	e.GET("/biwebauth", webAuthRedirect)
@@ -480,6 +615,7 @@ func Router() *echo.Echo {
 
 	// Status routes
 	StatusRoutes(e.Group("/status"))
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
 
 	return e
 }
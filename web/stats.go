@@ -0,0 +1,35 @@
+package web
+
+import (
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/labstack/echo/v4"
+)
+
+// getAppStats returns the download statistics of an application to its
+// editor. The period can be given with the since and until query parameters,
+// formatted as YYYY-MM-DD.
+func getAppStats(c echo.Context) error {
+	if err := checkAuthorized(c); err != nil {
+		return err
+	}
+	app, err := registry.FindApp(nil, getSpace(c), c.Param("app"), registry.Stable)
+	if err != nil {
+		return err
+	}
+	_, err = checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationStats,
+		Space:     spaceNameForClaims(c),
+	})
+	if err != nil {
+		return err
+	}
+
+	stats, err := registry.GetDownloadStats(getSpace(c), app.Slug,
+		c.QueryParam("since"), c.QueryParam("until"))
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("cache-control", "no-cache")
+	return writeJSON(c, stats)
+}
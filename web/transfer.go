@@ -0,0 +1,105 @@
+package web
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/labstack/echo/v4"
+)
+
+// checkTransferAccess checks that the request has the master tokens of both
+// editors: one in the Authorization header, and the other in the body. The
+// master token of the cozy editor is also accepted alone, for the
+// administrators of the registry.
+func checkTransferAccess(c echo.Context, app *registry.App, editor *auth.Editor, otherToken string) error {
+	token, err := extractAuthHeader(c)
+	if err != nil {
+		return err
+	}
+	previous, err := auth.Editors.GetEditor(app.Editor)
+	if err != nil && err != auth.ErrEditorNotFound {
+		return err
+	}
+
+	var other *auth.Editor
+	switch {
+	case previous != nil && previous.VerifyMasterToken(base.SessionSecret, token):
+		setMasterEditor(c, previous, token)
+		other = editor
+	case editor.VerifyMasterToken(base.SessionSecret, token):
+		setMasterEditor(c, editor, token)
+		other = previous
+	default:
+		// checkPermissions accepts the master token of any editor, so the
+		// token is verified with the cozy editor only
+		ok, err := verifyAdminToken(c, token)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errshttp.NewError(http.StatusForbidden,
+				"The master tokens of both editors are required")
+		}
+		return nil
+	}
+
+	// The previous editor may have been removed, and only an administrator
+	// can transfer its applications
+	if other == nil {
+		return errshttp.NewError(http.StatusUnauthorized, "Token could not be verified")
+	}
+	raw, err := base64.StdEncoding.DecodeString(otherToken)
+	if err != nil || !other.VerifyMasterToken(base.SessionSecret, raw) {
+		return errshttp.NewError(http.StatusUnauthorized,
+			"The master tokens of both editors are required")
+	}
+	return nil
+}
+
+func transferApp(c echo.Context) (err error) {
+	if err = checkAuthorized(c); err != nil {
+		return err
+	}
+
+	var body struct {
+		Editor string `json:"editor"`
+		Token  string `json:"token"`
+	}
+	if err = c.Bind(&body); err != nil {
+		return err
+	}
+
+	app, err := registry.FindApp(nil, getSpace(c), c.Param("app"), registry.Stable)
+	if err != nil {
+		return err
+	}
+	editor, err := auth.Editors.GetEditor(body.Editor)
+	if err != nil {
+		return err
+	}
+	if err = checkTransferAccess(c, app, editor, body.Token); err != nil {
+		return err
+	}
+
+	previous := app.Editor
+	actor := auditActor(c, nil)
+	app, err = registry.TransferApp(getSpace(c), app.Slug, editor, actor)
+	if err != nil {
+		return err
+	}
+	recordAction(c, nil, &audit.Entry{
+		Action: audit.AppTransferred,
+		Slug:   app.Slug,
+		Editor: app.Editor,
+		Before: map[string]string{"editor": previous},
+		After:  map[string]string{"editor": app.Editor},
+	})
+
+	cleanApp(app)
+	return c.JSON(http.StatusOK, app)
+}
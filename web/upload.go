@@ -0,0 +1,211 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/labstack/echo/v4"
+)
+
+// tarballContentTypes is the list of the content types that can be used to
+// send directly the tarball of a version in the request body.
+var tarballContentTypes = []string{
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-tgz",
+	"application/tar+gzip",
+	"application/x-tar",
+	"application/octet-stream",
+}
+
+// maxUploadFieldsSize is the maximal size of the fields of a multipart form
+// used to upload a version, without the tarball.
+const maxUploadFieldsSize = 100 << 10
+
+// tarballUpload is the tarball of a version sent by the client in the
+// request, instead of an URL where the registry can download it.
+type tarballUpload struct {
+	content     io.Reader
+	contentType string
+	name        string
+}
+
+// isTarballUpload returns true if the request body contains the tarball of a
+// version (raw or in a multipart form), and not a JSON document.
+func isTarballUpload(c echo.Context) bool {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if strings.HasPrefix(contentType, echo.MIMEMultipartForm) {
+		return true
+	}
+	for _, typ := range tarballContentTypes {
+		if strings.HasPrefix(contentType, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadEndpoint middleware is like jsonEndpoint, but it also accepts a
+// request where the body is the tarball of a version.
+func uploadEndpoint(next echo.HandlerFunc) echo.HandlerFunc {
+	json := jsonEndpoint(next)
+	return func(c echo.Context) error {
+		if !isTarballUpload(c) {
+			return json(c)
+		}
+		c.Set("json", true)
+		return next(c)
+	}
+}
+
+// bindTarballUpload fills the version options from the request, and returns
+// the uploaded tarball. Two forms are accepted:
+//   - the raw tarball as the body, with the version, sha256, signature and
+//     rollout in the query string
+//   - a multipart/form-data with the options as fields, and the tarball in
+//     the "tarball" file field, after the other fields.
+//
+// The tarball is not read here: it is streamed by the caller, and its size is
+// limited by registry.UploadVersion.
+func bindTarballUpload(c echo.Context, opts *registry.VersionOptions) (*tarballUpload, error) {
+	req := c.Request()
+	contentType := req.Header.Get(echo.HeaderContentType)
+	if !strings.HasPrefix(contentType, echo.MIMEMultipartForm) {
+		opts.Version = c.QueryParam("version")
+		opts.Sha256 = c.QueryParam("sha256")
+		opts.Signature = c.QueryParam("signature")
+		rollout, err := parseRollout(c.QueryParam("rollout"))
+		if err != nil {
+			return nil, err
+		}
+		opts.Rollout = rollout
+		return &tarballUpload{
+			content:     req.Body,
+			contentType: contentType,
+		}, nil
+	}
+
+	maxSize := base.Config.MaxApplicationSize(getSpace(c).GetPrefix())
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxSize+maxUploadFieldsSize)
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, errshttp.NewError(http.StatusBadRequest,
+			"Could not parse the multipart form: %s", err)
+	}
+
+	// The fields are read until the tarball, that is returned without being
+	// read, so that it is never buffered in memory or in a temporary file.
+	form := &multipart.Form{Value: make(map[string][]string)}
+	remaining := int64(maxUploadFieldsSize)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errshttp.NewError(http.StatusBadRequest,
+				"The multipart form should contain a tarball file after the other fields")
+		}
+		if err != nil {
+			return nil, errshttp.NewError(http.StatusBadRequest,
+				"Could not parse the multipart form: %s", err)
+		}
+		name := part.FormName()
+		if name == "tarball" {
+			if err = bindFormOptions(form, opts); err != nil {
+				return nil, err
+			}
+			return &tarballUpload{
+				content:     part,
+				contentType: part.Header.Get(echo.HeaderContentType),
+				name:        part.FileName(),
+			}, nil
+		}
+		value, err := ioutil.ReadAll(io.LimitReader(part, remaining+1))
+		if err != nil {
+			return nil, errshttp.NewError(http.StatusBadRequest,
+				"Could not parse the multipart form: %s", err)
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, errshttp.NewError(http.StatusRequestEntityTooLarge,
+				"The fields of the multipart form are too large (limit is %d bytes)", maxUploadFieldsSize)
+		}
+		if name != "" {
+			form.Value[name] = append(form.Value[name], string(value))
+		}
+	}
+}
+
+func bindFormOptions(form *multipart.Form, opts *registry.VersionOptions) error {
+	opts.Version = formValue(form, "version")
+	opts.Sha256 = formValue(form, "sha256")
+	opts.Signature = formValue(form, "signature")
+	opts.Icon = formValue(form, "icon")
+	opts.Screenshots = form.Value["screenshots"]
+	rollout, err := parseRollout(formValue(form, "rollout"))
+	if err != nil {
+		return err
+	}
+	opts.Rollout = rollout
+	if params := formValue(form, "parameters"); params != "" {
+		if !json.Valid([]byte(params)) {
+			return errshttp.NewError(http.StatusBadRequest,
+				"The parameters field should be a JSON document")
+		}
+		opts.Parameters = json.RawMessage(params)
+	}
+	if partnership := formValue(form, "partnership"); partnership != "" {
+		if err := json.Unmarshal([]byte(partnership), &opts.Partnership); err != nil {
+			return errshttp.NewError(http.StatusBadRequest,
+				"The partnership field should be a JSON object")
+		}
+	}
+	return nil
+}
+
+func formValue(form *multipart.Form, key string) string {
+	if values := form.Value[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// filename returns the name of the file that will be used to store the
+// tarball. The name given by the client is kept when it looks like a tarball,
+// else it is generated from the slug and the version.
+func (u *tarballUpload) filename(slug, version string) string {
+	name := filepath.Base(u.name)
+	if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") {
+		return name
+	}
+	return fmt.Sprintf("%s-%s.tar.gz", slug, version)
+}
+
+func validateUploadRequest(c echo.Context, ver *registry.VersionOptions) error {
+	if err := registry.IsValidUploadedVersion(ver); err != nil {
+		return wrapErr(err, http.StatusBadRequest)
+	}
+	return nil
+}
+
+// parseRollout reads the rollout percentage of an upload. An empty value means
+// that the version is served to all the instances.
+func parseRollout(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	percentage, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, errshttp.NewError(http.StatusBadRequest,
+			"The rollout should be a percentage: %s", err)
+	}
+	return &percentage, nil
+}
@@ -6,10 +6,15 @@ import (
 	"net/url"
 	"path"
 	"path/filepath"
+	"time"
 
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/cozy/cozy-apps-registry/auth"
 	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/errshttp"
+	"github.com/cozy/cozy-apps-registry/metrics"
 	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/go-kivik/kivik/v3"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 )
@@ -28,18 +33,42 @@ func createVersion(c echo.Context) (err error) {
 	}
 
 	opts := &registry.VersionOptions{}
-	if err = c.Bind(opts); err != nil {
+	var upload *tarballUpload
+	if isTarballUpload(c) {
+		// The token is checked before reading the uploaded body, and the
+		// channel is checked below, when the version is known
+		if _, err = checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+			Operation: auth.OperationPublish,
+			Space:     spaceNameForClaims(c),
+		}); err != nil {
+			return err
+		}
+		upload, err = bindTarballUpload(c, opts)
+	} else {
+		err = c.Bind(opts)
+	}
+	if err != nil {
 		return err
 	}
 	opts.Version = stripVersion(opts.Version)
 	opts.SpacePrefix = prefix
 
-	editor, err := checkPermissions(c, app.Editor, app.Slug, false /* = not master */)
+	editor, err := checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationPublish,
+		Space:     spaceNameForClaims(c),
+		Channel:   registry.ChannelToStr(registry.GetVersionChannel(opts.Version)),
+	})
 	if err != nil {
-		return errshttp.NewError(http.StatusUnauthorized, err.Error())
+		return err
 	}
+	opts.Editor = editor
 
-	if err = validateVersionRequest(c, opts); err != nil {
+	if upload != nil {
+		err = validateUploadRequest(c, opts)
+	} else {
+		err = validateVersionRequest(c, opts)
+	}
+	if err != nil {
 		return err
 	}
 
@@ -54,6 +83,9 @@ func createVersion(c echo.Context) (err error) {
 	// Generate the registryURL which contains the registryURL where to download
 	// the file
 	filename := filepath.Base(opts.URL)
+	if upload != nil {
+		filename = upload.filename(appSlug, opts.Version)
+	}
 	buildedURL := &url.URL{
 		Scheme: c.Scheme(),
 		Host:   c.Request().Host,
@@ -62,7 +94,13 @@ func createVersion(c echo.Context) (err error) {
 
 	opts.RegistryURL = buildedURL
 
-	ver, attachments, err := registry.DownloadVersion(opts)
+	var ver *registry.Version
+	var attachments []*kivik.Attachment
+	if upload != nil {
+		ver, attachments, err = registry.UploadVersion(opts, filename, upload.content, upload.contentType)
+	} else {
+		ver, attachments, err = registry.DownloadVersion(opts)
+	}
 	if err != nil {
 		return err
 	}
@@ -110,9 +148,9 @@ func getPendingVersions(c echo.Context) (err error) {
 	}
 
 	editorName := c.QueryParam("editor")
-	_, err = checkPermissions(c, editorName, "", true /* = master */)
+	_, err = checkPermissions(c, editorName, "", nil /* = master */)
 	if err != nil {
-		return errshttp.NewError(http.StatusUnauthorized, err.Error())
+		return err
 	}
 
 	versions, err := registry.GetPendingVersions(getSpace(c))
@@ -121,9 +159,15 @@ func getPendingVersions(c echo.Context) (err error) {
 	}
 
 	slugFilter := c.QueryParam("filter[slug]")
-	filteredVersions := versions[:]
+	stateFilter := c.QueryParam("filter[state]")
+	if stateFilter != "" && stateFilter != registry.PendingStateWaiting && stateFilter != registry.PendingStateRejected {
+		return errshttp.NewError(http.StatusBadRequest,
+			`Filter "state" is invalid: should be %q or %q`, registry.PendingStateWaiting, registry.PendingStateRejected)
+	}
+	filteredVersions := versions[:0]
 	for _, version := range versions {
-		if slugFilter == "" || version.Slug == slugFilter {
+		if (slugFilter == "" || version.Slug == slugFilter) &&
+			(stateFilter == "" || version.State() == stateFilter) {
 			cleanVersion(version)
 			filteredVersions = append(filteredVersions, version)
 		}
@@ -139,9 +183,9 @@ func approvePendingVersion(c echo.Context) (err error) {
 
 	// only allow approving versions from editor cozy
 	editorName := "cozy"
-	_, err = checkPermissions(c, editorName, "", true /* = master */)
+	_, err = checkPermissions(c, editorName, "", nil /* = master */)
 	if err != nil {
-		return errshttp.NewError(http.StatusUnauthorized, err.Error())
+		return err
 	}
 
 	appSlug := c.Param("app")
@@ -162,9 +206,14 @@ func approvePendingVersion(c echo.Context) (err error) {
 		return err
 	}
 
-	if version, err = registry.ApprovePendingVersion(getSpace(c), version, app); err != nil {
+	if version, err = registry.ApprovePendingVersion(getSpace(c), version, app, reviewerName(c)); err != nil {
 		return err
 	}
+	recordAction(c, nil, &audit.Entry{
+		Action:  audit.VersionApproved,
+		Slug:    version.Slug,
+		Version: version.Version,
+	})
 
 	cleanVersion(version)
 
@@ -216,10 +265,49 @@ func getVersionTarball(c echo.Context) error {
 		}
 	}
 
-	return sendAttachment(c, att, filename)
+	if err := sendAttachment(c, att, filename); err != nil {
+		return err
+	}
+	spaceName := space.GetPrefix().String()
+	virtualName, _ := c.Get("virtual_name").(string)
+	if c.Request().Method == http.MethodGet && c.Response().Status == http.StatusOK {
+		metrics.TarballDownloads.Inc(spaceName, virtualName)
+		registry.RecordDownload(space, ver.Slug, ver.Version)
+	}
+	metrics.TarballDownloadBytes.Add(float64(c.Response().Size), spaceName, virtualName)
+	return nil
+}
+
+func getVersionSignature(c echo.Context) error {
+	appSlug := c.Param("app")
+	version := stripVersion(c.Param("version"))
+
+	doc, err := registry.FindPublishedVersion(getSpace(c), appSlug, version)
+	if err != nil {
+		return err
+	}
+	if doc, err = override(c, doc); err != nil {
+		return err
+	}
+	if doc.Signature == "" {
+		return errshttp.NewError(http.StatusNotFound, "Version is not signed")
+	}
+
+	if cacheControl(c, doc.Rev, oneYear) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return writeJSON(c, echo.Map{
+		"algorithm": "ed25519",
+		"editor":    doc.Editor,
+		"sha256":    doc.Sha256,
+		"signature": doc.Signature,
+	})
 }
 
 func sendAttachment(c echo.Context, att *registry.Attachment, filename string) error {
+	defer att.Content.Close()
+
 	contentType := att.ContentType
 	// force image/svg content-type for svg assets that start with <?xml
 	if (filename == "icon" || filename == "partnership_icon") && contentType == "text/xml" {
@@ -231,15 +319,10 @@ func sendAttachment(c echo.Context, att *registry.Attachment, filename string) e
 		return c.NoContent(http.StatusNotModified)
 	}
 
-	if c.Request().Method == http.MethodHead {
-		return c.NoContent(http.StatusOK)
-	}
-
-	if att.ContentLength != "" {
-		c.Response().Header().Set(echo.HeaderContentLength, att.ContentLength)
-	}
-
-	return c.Stream(http.StatusOK, contentType, att.Content)
+	// ServeContent handles the HEAD and Range requests, and reads only the
+	// requested part of the file from the storage.
+	http.ServeContent(c.Response(), c.Request(), filename, time.Time{}, att.Content)
+	return nil
 }
 
 func getVersionAttachment(c echo.Context, filename string) error {
@@ -351,7 +434,10 @@ func getLatestVersion(c echo.Context) error {
 		return err
 	}
 	space := getSpace(c)
-	version, err := registry.FindLatestVersion(space, appSlug, ch)
+	// The instance is used to know if the version that is rolled out should
+	// be served to it
+	instance := c.QueryParam("instance")
+	version, err := registry.FindLatestVersionForInstance(nil, space, appSlug, ch, instance)
 	if err != nil {
 		return err
 	}
@@ -367,3 +453,51 @@ func getLatestVersion(c echo.Context) error {
 
 	return writeJSON(c, version)
 }
+
+// promoteVersion publishes an existing version in a more stable channel,
+// with the same tarball.
+func promoteVersion(c echo.Context) (err error) {
+	if err = checkAuthorized(c); err != nil {
+		return err
+	}
+
+	var body struct {
+		Channel string `json:"channel"`
+		Version string `json:"version"`
+	}
+	if err = c.Bind(&body); err != nil {
+		return err
+	}
+	channel, err := registry.StrToChannel(body.Channel)
+	if err != nil {
+		return err
+	}
+
+	app, err := registry.FindApp(nil, getSpace(c), c.Param("app"), registry.Stable)
+	if err != nil {
+		return err
+	}
+	editor, err := checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationPublish,
+		Space:     spaceNameForClaims(c),
+		Channel:   registry.ChannelToStr(channel),
+	})
+	if err != nil {
+		return err
+	}
+
+	version := stripVersion(c.Param("version"))
+	ver, err := registry.PromoteVersion(getSpace(c), app.Slug, version, channel, stripVersion(body.Version))
+	if err != nil {
+		return err
+	}
+	recordAction(c, editor, &audit.Entry{
+		Action:  audit.VersionPromoted,
+		Slug:    app.Slug,
+		Version: version,
+		After:   map[string]string{"channel": body.Channel, "version": ver.Version},
+	})
+
+	cleanVersion(ver)
+	return c.JSON(http.StatusCreated, ver)
+}
@@ -1,17 +1,22 @@
 package web
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-kivik/kivik/v3"
 
 	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/base"
 	"github.com/cozy/cozy-apps-registry/config"
 	"github.com/cozy/cozy-apps-registry/registry"
 	"github.com/cozy/cozy-apps-registry/space"
@@ -142,6 +147,282 @@ func TestVersionIconFromVirtualSpace(t *testing.T) {
 	assert.Equal(t, expected, body)
 }
 
+func TestLatestVersionWithoutInstance(t *testing.T) {
+	u := fmt.Sprintf("%s/%s/registry/%s/stable/latest", server.URL, allAppsSpace, overwrittenApp)
+	res, err := http.Get(u)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	defer res.Body.Close()
+	var body map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3", body["version"])
+}
+
+func TestForbiddenOperation(t *testing.T) {
+	editor, err := auth.Editors.CreateEditorWithoutPublicKey("webtesteditor", true)
+	assert.NoError(t, err)
+	defer func() { _ = auth.Editors.DeleteEditor(editor) }()
+
+	s, _ := space.GetSpace(allAppsSpace)
+	opts := &registry.AppOptions{Editor: editor.Name(), Slug: "forbidden", Type: "webapp"}
+	_, err = registry.CreateApp(s, opts, editor)
+	assert.NoError(t, err)
+
+	claims, err := auth.NewClaims(nil, nil, []string{auth.OperationPublish})
+	assert.NoError(t, err)
+	token, _, err := editor.GenerateEditorToken(base.SessionSecret, 0, "forbidden", claims)
+	assert.NoError(t, err)
+
+	u := fmt.Sprintf("%s/%s/registry/forbidden", server.URL, allAppsSpace)
+	req, err := http.NewRequest(http.MethodPatch, u, strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+base64.StdEncoding.EncodeToString(token))
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestUploadVersionForm(t *testing.T) {
+	var editors []*auth.Editor
+	for _, name := range []string{"webuploadeditor", "webuploadother"} {
+		editor, err := auth.Editors.CreateEditorWithoutPublicKey(name, true)
+		assert.NoError(t, err)
+		editors = append(editors, editor)
+	}
+	defer func() {
+		for _, editor := range editors {
+			_ = auth.Editors.DeleteEditor(editor)
+		}
+	}()
+	editor, other := editors[0], editors[1]
+
+	s, _ := space.GetSpace(allAppsSpace)
+	opts := &registry.AppOptions{Editor: editor.Name(), Slug: "uploaded", Type: "webapp"}
+	_, err := registry.CreateApp(s, opts, editor)
+	assert.NoError(t, err)
+
+	upload := func(token []byte, fields ...string) int {
+		body := &bytes.Buffer{}
+		form := multipart.NewWriter(body)
+		for i := 0; i < len(fields); i += 2 {
+			if fields[i] == "tarball" {
+				part, err := form.CreateFormFile("tarball", "uploaded.tar.gz")
+				assert.NoError(t, err)
+				_, _ = part.Write([]byte(fields[i+1]))
+			} else {
+				assert.NoError(t, form.WriteField(fields[i], fields[i+1]))
+			}
+		}
+		assert.NoError(t, form.Close())
+
+		u := fmt.Sprintf("%s/%s/registry/uploaded", server.URL, allAppsSpace)
+		req, err := http.NewRequest(http.MethodPost, u, body)
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Authorization", "Token "+base64.StdEncoding.EncodeToString(token))
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+
+	// The token is checked before the form is read
+	otherToken, _, err := other.GenerateEditorToken(base.SessionSecret, 0, "uploaded", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, upload(otherToken, "version", "1.0.0", "tarball", "..."))
+
+	// The tarball must be the last field
+	token, _, err := editor.GenerateEditorToken(base.SessionSecret, 0, "uploaded", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, upload(token, "version", "1.0.0"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge,
+		upload(token, "version", "1.0.0", "parameters", strings.Repeat("x", maxUploadFieldsSize+1), "tarball", "..."))
+}
+
+func TestPatchEditorWithAnotherEditorToken(t *testing.T) {
+	var editors []*auth.Editor
+	for _, name := range []string{"webprofileeditor", "webprofileother"} {
+		editor, err := auth.Editors.CreateEditorWithoutPublicKey(name, true)
+		assert.NoError(t, err)
+		editors = append(editors, editor)
+	}
+	defer func() {
+		for _, editor := range editors {
+			_ = auth.Editors.DeleteEditor(editor)
+		}
+	}()
+	editor, other := editors[0], editors[1]
+
+	patch := func(token []byte) int {
+		u := fmt.Sprintf("%s/editors/%s", server.URL, editor.Name())
+		req, err := http.NewRequest(http.MethodPatch, u, strings.NewReader(`{"display_name": "Changed"}`))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Token "+base64.StdEncoding.EncodeToString(token))
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+
+	otherToken, _, err := other.GenerateMasterToken(base.SessionSecret, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, patch(otherToken))
+	fresh, err := auth.Editors.GetEditor(editor.Name())
+	assert.NoError(t, err)
+	assert.Empty(t, fresh.Profile().DisplayName)
+
+	token, _, err := editor.GenerateMasterToken(base.SessionSecret, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, patch(token))
+	fresh, err = auth.Editors.GetEditor(editor.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "Changed", fresh.Profile().DisplayName)
+}
+
+func TestReviewCommentRoles(t *testing.T) {
+	var editors []*auth.Editor
+	for _, name := range []string{"webreviewededitor", "webreviewer"} {
+		editor, err := auth.Editors.CreateEditorWithoutPublicKey(name, false)
+		assert.NoError(t, err)
+		editors = append(editors, editor)
+	}
+	defer func() {
+		for _, editor := range editors {
+			_ = auth.Editors.DeleteEditor(editor)
+		}
+	}()
+	editor, reviewer := editors[0], editors[1]
+
+	s, _ := space.GetSpace(allAppsSpace)
+	opts := &registry.AppOptions{Editor: editor.Name(), Slug: "reviewed", Type: "webapp"}
+	app, err := registry.CreateApp(s, opts, editor)
+	assert.NoError(t, err)
+	version := &registry.Version{
+		Slug:    app.Slug,
+		Version: "1.0.0",
+		URL:     "http://example.org/registry/reviewed.tar.gz",
+	}
+	assert.NoError(t, registry.CreatePendingVersion(s, version, nil, app))
+
+	comment := func(token []byte) *registry.ReviewComment {
+		u := fmt.Sprintf("%s/%s/registry/pending/reviewed/1.0.0/comments", server.URL, allAppsSpace)
+		req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(`{"message": "Hello"}`))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Token "+base64.StdEncoding.EncodeToString(token))
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		var c registry.ReviewComment
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&c))
+		return &c
+	}
+
+	// The master token of the editor of the application is not a reviewer
+	token, _, err := editor.GenerateMasterToken(base.SessionSecret, 0)
+	assert.NoError(t, err)
+	c := comment(token)
+	assert.Equal(t, registry.RoleEditor, c.Role)
+	assert.Equal(t, editor.Name(), c.Author)
+
+	token, _, err = reviewer.GenerateMasterToken(base.SessionSecret, 0)
+	assert.NoError(t, err)
+	c = comment(token)
+	assert.Equal(t, registry.RoleReviewer, c.Role)
+	assert.Equal(t, reviewer.Name(), c.Author)
+}
+
+func TestTransferWithAnotherEditorToken(t *testing.T) {
+	var editors []*auth.Editor
+	for _, name := range []string{"webtransferfrom", "webtransferto", "webtransferother"} {
+		editor, err := auth.Editors.CreateEditorWithoutPublicKey(name, true)
+		assert.NoError(t, err)
+		editors = append(editors, editor)
+	}
+	defer func() {
+		for _, editor := range editors {
+			_ = auth.Editors.DeleteEditor(editor)
+		}
+	}()
+	from, to, other := editors[0], editors[1], editors[2]
+
+	s, _ := space.GetSpace(allAppsSpace)
+	opts := &registry.AppOptions{Editor: from.Name(), Slug: "transferred", Type: "webapp"}
+	_, err := registry.CreateApp(s, opts, from)
+	assert.NoError(t, err)
+
+	token, _, err := other.GenerateMasterToken(base.SessionSecret, 0)
+	assert.NoError(t, err)
+	u := fmt.Sprintf("%s/%s/registry/transferred/transfer", server.URL, allAppsSpace)
+	body := fmt.Sprintf(`{"editor": %q}`, to.Name())
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+base64.StdEncoding.EncodeToString(token))
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	app, err := registry.FindApp(nil, s, "transferred", registry.Stable)
+	assert.NoError(t, err)
+	assert.Equal(t, from.Name(), app.Editor)
+}
+
+func TestChangesFromVirtualSpace(t *testing.T) {
+	u := fmt.Sprintf("%s/%s/registry/_changes", server.URL, myAppsSpace)
+	res, err := http.Get(u)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	defer res.Body.Close()
+	var body struct {
+		Results []struct {
+			Type    string                 `json:"type"`
+			Slug    string                 `json:"slug"`
+			Version string                 `json:"version"`
+			Doc     map[string]interface{} `json:"doc"`
+		} `json:"results"`
+		LastSeq string `json:"last_seq"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, body.LastSeq)
+
+	slugs := map[string]bool{}
+	for _, change := range body.Results {
+		slugs[change.Slug] = true
+		assert.NotContains(t, change.Doc, "_id")
+		assert.NotContains(t, change.Doc, "_rev")
+		if change.Type == registry.ChangeVersion {
+			assert.Equal(t, overwrittenApp, change.Slug)
+			assert.Equal(t, "1.2.3", change.Version)
+			// The version is the one overwritten in the virtual space
+			manifest, _ := change.Doc["manifest"].(map[string]interface{})
+			assert.Equal(t, "my new name", manifest["name"])
+			assert.Contains(t, change.Doc["url"], myAppsSpace)
+		}
+	}
+	assert.True(t, slugs[keptApp])
+	assert.True(t, slugs[overwrittenApp])
+	assert.False(t, slugs[rejectedApp])
+
+	// Nothing has changed since the last call
+	u = fmt.Sprintf("%s?since=%s", u, body.LastSeq)
+	res2, err := http.Get(u)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res2.StatusCode)
+	defer res2.Body.Close()
+	body.Results = nil
+	err = json.NewDecoder(res2.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.Len(t, body.Results, 0)
+}
+
 func TestMain(m *testing.M) {
 	config.SetDefaults()
 	viper.Set("spaces", []string{"__default__", allAppsSpace, allKonnectorsSpace})
@@ -171,6 +452,7 @@ func TestMain(m *testing.M) {
 		fmt.Println("Cannot prepare the spaces:", err)
 		os.Exit(1)
 	}
+	base.SessionSecret = auth.GenerateMasterSecret()
 
 	if err := createApps(); err != nil {
 		fmt.Println("Cannot create apps:", err)
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-apps-registry/audit"
+	"github.com/cozy/cozy-apps-registry/auth"
+	"github.com/cozy/cozy-apps-registry/registry"
+	"github.com/labstack/echo/v4"
+)
+
+// checkYankAccess checks that the request is made by an editor that can
+// publish the versions of the application.
+func checkYankAccess(c echo.Context) (*registry.App, *auth.Editor, error) {
+	if err := checkAuthorized(c); err != nil {
+		return nil, nil, err
+	}
+	app, err := registry.FindApp(nil, getSpace(c), c.Param("app"), registry.Stable)
+	if err != nil {
+		return nil, nil, err
+	}
+	editor, err := checkPermissions(c, app.Editor, app.Slug, &auth.Action{
+		Operation: auth.OperationPublish,
+		Space:     spaceNameForClaims(c),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return app, editor, nil
+}
+
+func yankVersion(c echo.Context) (err error) {
+	app, editor, err := checkYankAccess(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err = c.Bind(&body); err != nil {
+		return err
+	}
+
+	version, err := registry.YankVersion(getSpace(c), app.Slug, stripVersion(c.Param("version")), body.Reason)
+	if err != nil {
+		return err
+	}
+	recordAction(c, editor, &audit.Entry{
+		Action:  audit.VersionYanked,
+		Slug:    app.Slug,
+		Version: version.Version,
+		After:   version.Yanked,
+	})
+
+	cleanVersion(version)
+	return c.JSON(http.StatusOK, version)
+}
+
+func unyankVersion(c echo.Context) (err error) {
+	app, editor, err := checkYankAccess(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := registry.UnyankVersion(getSpace(c), app.Slug, stripVersion(c.Param("version")))
+	if err != nil {
+		return err
+	}
+	recordAction(c, editor, &audit.Entry{
+		Action:  audit.VersionUnyanked,
+		Slug:    app.Slug,
+		Version: version.Version,
+	})
+
+	cleanVersion(version)
+	return c.JSON(http.StatusOK, version)
+}
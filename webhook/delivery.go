@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/go-kivik/kivik/v3"
+)
+
+const deliveriesDBSuffix = "webhooks"
+
+// Delivery is the record of an event sent to a webhook, with the result of
+// the request.
+type Delivery struct {
+	ID         string    `json:"_id,omitempty"`
+	Rev        string    `json:"_rev,omitempty"`
+	EventID    string    `json:"event_id"`
+	Event      string    `json:"event"`
+	Space      string    `json:"space"`
+	Slug       string    `json:"slug"`
+	Version    string    `json:"version,omitempty"`
+	URL        string    `json:"url"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// DB returns the database where the deliveries are recorded.
+func DB() *kivik.DB {
+	return base.DBClient.DB(context.Background(), base.DBName(deliveriesDBSuffix))
+}
+
+// Prepare makes sure that the database for the deliveries exists.
+func Prepare() error {
+	ctx := context.Background()
+	dbName := base.DBName(deliveriesDBSuffix)
+	exists, err := base.DBClient.DBExists(ctx, dbName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		fmt.Printf("Creating database %q...", dbName)
+		if err := base.DBClient.CreateDB(ctx, dbName); err != nil {
+			return err
+		}
+		fmt.Println("ok.")
+	}
+	return nil
+}
+
+func saveDelivery(delivery *Delivery) error {
+	_, err := DB().Put(context.Background(), delivery.ID, delivery)
+	return err
+}
+
+// ListDeliveries returns the last deliveries, the most recent first. If space
+// is not empty, only the deliveries for this space are returned.
+func ListDeliveries(space string, limit int) ([]*Delivery, error) {
+	selector := map[string]interface{}{
+		"_id": map[string]interface{}{"$gt": nil},
+	}
+	if space != "" {
+		selector["space"] = space
+	}
+	rows, err := DB().Find(context.Background(), map[string]interface{}{
+		"selector": selector,
+		"sort":     []map[string]string{{"_id": "desc"}},
+		"limit":    limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]*Delivery, 0)
+	for rows.Next() {
+		var d Delivery
+		if err = rows.ScanDoc(&d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, rows.Err()
+}
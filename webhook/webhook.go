@@ -0,0 +1,209 @@
+// Package webhook is used to notify some URLs of the events that happen in
+// the registry, like the publication of a new version, so that the store or
+// the monitoring don't have to poll the registry. The events are configured
+// per space, and the deliveries are recorded in CouchDB.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// The types of the events that can be sent.
+const (
+	VersionPublished          = "version.published"
+	VersionApproved           = "version.approved"
+	VersionRejected           = "version.rejected"
+	VersionDeleted            = "version.deleted"
+	VersionYanked             = "version.yanked"
+	VersionUnyanked           = "version.unyanked"
+	AppMaintenanceActivated   = "app.maintenance_activated"
+	AppMaintenanceDeactivated = "app.maintenance_deactivated"
+	AppRemoved                = "app.removed"
+	AppTransferred            = "app.transferred"
+)
+
+// Events is the list of the types of the events that can be sent.
+var Events = []string{
+	VersionPublished,
+	VersionApproved,
+	VersionRejected,
+	VersionDeleted,
+	VersionYanked,
+	VersionUnyanked,
+	AppMaintenanceActivated,
+	AppMaintenanceDeactivated,
+	AppRemoved,
+	AppTransferred,
+}
+
+// IsValidEvent returns true if the given type is the type of an event that
+// can be sent.
+func IsValidEvent(eventType string) bool {
+	for _, e := range Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers of the requests sent to the webhooks.
+const (
+	SignatureHeader = "X-Cozy-Registry-Signature"
+	EventHeader     = "X-Cozy-Registry-Event"
+	DeliveryHeader  = "X-Cozy-Registry-Delivery"
+)
+
+// maxAttempts is the number of times the registry will try to deliver an event
+// before giving up.
+const maxAttempts = 5
+
+// firstRetryDelay is the delay before the first retry, it is doubled for each
+// new attempt.
+const firstRetryDelay = 2 * time.Second
+
+var webhookClient = http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// inflight is used to wait for the deliveries before exiting a command.
+var inflight sync.WaitGroup
+
+// Event is the JSON document sent to the webhooks.
+type Event struct {
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Space   string      `json:"space"`
+	Slug    string      `json:"slug"`
+	Version string      `json:"version,omitempty"`
+	Date    time.Time   `json:"date"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// NewEvent returns an event of the given type for an application.
+func NewEvent(eventType string, prefix base.Prefix, slug string) *Event {
+	return &Event{
+		ID:    newID(),
+		Type:  eventType,
+		Space: prefix.String(),
+		Slug:  slug,
+		Date:  time.Now().UTC(),
+	}
+}
+
+// Send delivers the event in the background to the webhooks of its space that
+// accept this type of event.
+func Send(event *Event) {
+	for _, hook := range base.Config.Webhooks(base.Prefix(event.Space)) {
+		if hook.Accepts(event.Type) {
+			inflight.Add(1)
+			go func(hook base.Webhook) {
+				defer inflight.Done()
+				deliver(hook, event)
+			}(hook)
+		}
+	}
+}
+
+// Wait blocks until the deliveries in progress are finished.
+func Wait() {
+	inflight.Wait()
+}
+
+func deliver(hook base.Webhook, event *Event) {
+	delivery := &Delivery{
+		ID:        fmt.Sprintf("%019d-%s", time.Now().UnixNano(), newID()),
+		EventID:   event.ID,
+		Event:     event.Type,
+		Space:     event.Space,
+		Slug:      event.Slug,
+		Version:   event.Version,
+		URL:       hook.URL,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	body, err := json.Marshal(event)
+	if err == nil {
+		err = storage.RetryWithExpBackoff(maxAttempts, firstRetryDelay, func() error {
+			delivery.Attempts++
+			code, err := post(hook, event, delivery.ID, body)
+			delivery.StatusCode = code
+			return err
+		})
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		delivery.Success = true
+	}
+	delivery.FinishedAt = time.Now().UTC()
+
+	log := logrus.WithFields(logrus.Fields{
+		"nspace":   "webhook",
+		"space":    event.Space,
+		"slug":     event.Slug,
+		"event":    event.Type,
+		"url":      hook.URL,
+		"attempts": delivery.Attempts,
+	})
+	if err != nil {
+		log.WithField("error_msg", err).Warn("Could not deliver event")
+	}
+	if err := saveDelivery(delivery); err != nil {
+		log.WithField("error_msg", err).Error("Could not save the delivery")
+	}
+}
+
+func post(hook base.Webhook, event *Event, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(hook.Secret, body))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("webhook responded with code %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of the body with the secret of the
+// webhook. The receiver can use it to check that the request comes from the
+// registry.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
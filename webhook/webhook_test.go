@@ -0,0 +1,108 @@
+package webhook_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cozy/cozy-apps-registry/base"
+	"github.com/cozy/cozy-apps-registry/config"
+	"github.com/cozy/cozy-apps-registry/webhook"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSpace = "webhook-test"
+
+func TestSign(t *testing.T) {
+	body := []byte("The quick brown fox jumps over the lazy dog")
+	assert.Equal(t, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		webhook.Sign("key", body))
+	assert.NotEqual(t, webhook.Sign("key", body), webhook.Sign("other", body))
+}
+
+func TestIsValidEvent(t *testing.T) {
+	assert.True(t, webhook.IsValidEvent(webhook.VersionPublished))
+	assert.True(t, webhook.IsValidEvent(webhook.AppTransferred))
+	assert.False(t, webhook.IsValidEvent("version.publish"))
+	assert.False(t, webhook.IsValidEvent(""))
+}
+
+func TestDelivery(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, webhook.VersionPublished, r.Header.Get(webhook.EventHeader))
+		assert.NotEmpty(t, r.Header.Get(webhook.DeliveryHeader))
+		assert.Equal(t, "sha256="+webhook.Sign("s3cr3t", body), r.Header.Get(webhook.SignatureHeader))
+		// The first attempt fails, and the event is sent again
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	base.Config.SpaceOptions = map[string]base.SpaceOptions{
+		testSpace: {
+			Webhooks: []base.Webhook{
+				{URL: server.URL, Secret: "s3cr3t", Events: []string{webhook.VersionPublished}},
+				{URL: server.URL + "/ignored", Secret: "s3cr3t", Events: []string{webhook.AppRemoved}},
+			},
+		},
+	}
+	defer func() { base.Config.SpaceOptions = nil }()
+
+	event := webhook.NewEvent(webhook.VersionPublished, base.Prefix(testSpace), "drive")
+	event.Version = "1.2.3"
+	webhook.Send(event)
+	webhook.Wait()
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+	deliveries, err := webhook.ListDeliveries(testSpace, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 1) {
+		d := deliveries[0]
+		assert.Equal(t, event.ID, d.EventID)
+		assert.Equal(t, webhook.VersionPublished, d.Event)
+		assert.Equal(t, "drive", d.Slug)
+		assert.Equal(t, "1.2.3", d.Version)
+		assert.Equal(t, server.URL, d.URL)
+		assert.Equal(t, 2, d.Attempts)
+		assert.Equal(t, http.StatusNoContent, d.StatusCode)
+		assert.True(t, d.Success)
+		assert.Empty(t, d.Error)
+	}
+}
+
+func TestMain(m *testing.M) {
+	config.SetDefaults()
+	viper.Set("spaces", []string{"__default__"})
+
+	if err := config.ReadFile("", "cozy-registry-test"); err != nil {
+		fmt.Println("Cannot load test config:", err)
+	}
+
+	if err := config.SetupForTests(); err != nil {
+		fmt.Println("Cannot configure the services:", err)
+		os.Exit(1)
+	}
+
+	if err := config.PrepareSpaces(); err != nil {
+		fmt.Println("Cannot prepare the spaces:", err)
+		os.Exit(1)
+	}
+
+	out := m.Run()
+
+	if err := config.CleanupTests(); err != nil {
+		fmt.Println("Error while cleaning:", err)
+	}
+
+	os.Exit(out)
+}